@@ -0,0 +1,54 @@
+package clip
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/PuerkitoBio/goquery"
+	"golang.org/x/net/html/charset"
+)
+
+// isCharset reports if label is known encoding name.
+func isCharset(label string) bool {
+	e, _ := charset.Lookup(strings.TrimSpace(label))
+	return e != nil
+}
+
+// decodeBody returns r transcoded to UTF-8. Encoding is set by override
+// label (if not empty) or determined from BOM, contentType header value,
+// meta tags and content sniffing.
+func decodeBody(r io.Reader, contentType, override string) (io.Reader, error) {
+	if override = strings.TrimSpace(override); override != "" {
+		dr, err := charset.NewReaderLabel(override, r)
+		if err != nil {
+			return nil, fmt.Errorf("charset.NewReaderLabel: %w", err)
+		}
+		return dr, nil
+	}
+	dr, err := charset.NewReader(r, contentType)
+	if err != nil {
+		return nil, fmt.Errorf("charset.NewReader: %w", err)
+	}
+	return skipBOM(dr), nil
+}
+
+// skipBOM drops UTF-8 byte order mark, which is left by UTF-8 decoder.
+func skipBOM(r io.Reader) io.Reader {
+	br := bufio.NewReader(r)
+	if b, _ := br.Peek(3); string(b) == "\xef\xbb\xbf" {
+		_, _ = br.Discard(3)
+	}
+	return br
+}
+
+// setCharsetMeta replaces charset declarations in doc by utf-8 one, so
+// renderer doesn't decode transcoded document with original encoding.
+func setCharsetMeta(doc *goquery.Document) {
+	doc.Find("meta[charset]").Remove()
+	doc.Find("meta[http-equiv]").FilterFunction(func(_ int, sel *goquery.Selection) bool {
+		return strings.EqualFold(sel.AttrOr("http-equiv", ""), "content-type")
+	}).Remove()
+	doc.Find("head").PrependHtml(`<meta charset="utf-8">`)
+}
@@ -0,0 +1,62 @@
+package clip
+
+import (
+	"io/ioutil"
+	"strings"
+	"testing"
+
+	"github.com/PuerkitoBio/goquery"
+)
+
+func Test_decodeBody(t *testing.T) {
+	const (
+		cp1251 = "\xcf\xf0\xe8\xe2\xe5\xf2" // Привет
+		sjis   = "\x93\xfa\x96\x7b"         // 日本
+	)
+	tests := []struct {
+		name, body, contentType, override, want string
+	}{
+		{"header", "<p>" + cp1251, "text/html; charset=windows-1251", "", "<p>Привет"},
+		{"meta", `<meta charset="shift_jis"><p>` + sjis, "text/html", "", `<meta charset="shift_jis"><p>日本`},
+		{"http-equiv", `<meta http-equiv="Content-Type" content="text/html; charset=windows-1251">` + cp1251, "", "",
+			`<meta http-equiv="Content-Type" content="text/html; charset=windows-1251">Привет`},
+		{"bom", "\xef\xbb\xbf<p>ok", "text/html; charset=windows-1251", "", "<p>ok"},
+		{"utf-8", "<p>Привет", "text/html", "", "<p>Привет"},
+		{"override", "<p>" + cp1251, "text/html; charset=utf-8", "cp1251", "<p>Привет"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r, err := decodeBody(strings.NewReader(tt.body), tt.contentType, tt.override)
+			if err != nil {
+				t.Fatal(err)
+			}
+			b, err := ioutil.ReadAll(r)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(b) != tt.want {
+				t.Errorf("decodeBody() = %q, want %q", b, tt.want)
+			}
+		})
+	}
+	if _, err := decodeBody(strings.NewReader(""), "", "no-such-charset"); err == nil {
+		t.Error("decodeBody() with unknown override should fail")
+	}
+}
+
+func Test_setCharsetMeta(t *testing.T) {
+	doc, err := goquery.NewDocumentFromReader(strings.NewReader(`<html><head>
+		<meta http-equiv="content-type" content="text/html; charset=windows-1251">
+		<meta charset="windows-1251"><title>t</title></head></html>`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	setCharsetMeta(doc)
+	meta := doc.Find("meta")
+	if meta.Length() != 1 || meta.AttrOr("charset", "") != "utf-8" {
+		t.Errorf("unexpected meta tags: %d, charset=%q", meta.Length(), meta.AttrOr("charset", ""))
+	}
+	if goquery.NodeName(doc.Find("head").Children().First()) != "meta" {
+		t.Error("meta charset should be first element in head")
+	}
+}
@@ -0,0 +1,309 @@
+package clip
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/PuerkitoBio/goquery"
+	"github.com/andybalholm/cascadia"
+)
+
+// CleanFilters are rules of clean pass (see Params.Clean). Filters are
+// read from lists in EasyList syntax subset:
+//
+//	! comment
+//	##selector                      remove elements on all sites
+//	example.com,~www.example.com##selector  remove elements on listed sites
+//	||ads.example.com^              remove iframes, images and scripts from domain
+//
+// Other rules (exceptions, extended CSS, scriptlets, network rules with
+// paths) and selectors not supported by cascadia are skipped, so EasyList
+// files can be used as is.
+type CleanFilters struct {
+	rules     []cleanRule
+	adDomains map[string]bool
+}
+
+type cleanRule struct {
+	sel              cascadia.Selector
+	domains, exclude []string
+}
+
+// DefaultCleanFilters are used by Clipper, if ClipperOptions.CleanFilters
+// is nil.
+var DefaultCleanFilters = mustParseCleanFilters(defaultCleanList)
+
+const defaultCleanList = `! cookie consent banners
+##[id*="cookie-banner"]
+##[class*="cookie-banner"]
+##[id*="cookie-consent"]
+##[class*="cookie-consent"]
+##[id*="cookie-notice"]
+##[class*="cookie-notice"]
+##[id^="onetrust-"]
+##[id^="CybotCookiebot"]
+##[id^="sp_message_container"]
+##.cc-window
+##.qc-cmp2-container
+##.fc-consent-root
+! social share widgets
+##[class*="share-buttons"]
+##[class*="social-share"]
+##.sharethis-inline-share-buttons
+##.addthis_toolbox
+##.a2a_kit
+! ads
+##.adsbygoogle
+##[id^="div-gpt-ad"]
+##[data-ad-slot]
+##[id^="taboola-"]
+##.OUTBRAIN
+##[id^="yandex_rtb"]
+! ad and tracking domains
+||doubleclick.net^
+||googlesyndication.com^
+||googleadservices.com^
+||google-analytics.com^
+||googletagmanager.com^
+||adservice.google.com^
+||amazon-adsystem.com^
+||connect.facebook.net^
+||adnxs.com^
+||criteo.com^
+||criteo.net^
+||taboola.com^
+||outbrain.com^
+||scorecardresearch.com^
+||quantserve.com^
+||mc.yandex.ru^
+||an.yandex.ru^
+||top-fwz1.mail.ru^
+`
+
+func mustParseCleanFilters(list string) *CleanFilters {
+	f, err := ParseCleanFilters(strings.NewReader(list))
+	if err != nil {
+		panic(err)
+	}
+	return f
+}
+
+// ParseCleanFilters reads filters list from r.
+func ParseCleanFilters(r io.Reader) (*CleanFilters, error) {
+	f := &CleanFilters{adDomains: make(map[string]bool)}
+	sc := bufio.NewScanner(r)
+	sc.Buffer(nil, 1<<20)
+	for sc.Scan() {
+		f.addRule(strings.TrimSpace(sc.Text()))
+	}
+	if err := sc.Err(); err != nil {
+		return nil, fmt.Errorf("bufio.Scanner.Scan: %w", err)
+	}
+	return f, nil
+}
+
+// CleanFiltersFromFiles reads and merges filters lists.
+func CleanFiltersFromFiles(files ...string) (*CleanFilters, error) {
+	res := &CleanFilters{adDomains: make(map[string]bool)}
+	for _, file := range files {
+		f, err := os.Open(file) // nolint:gosec
+		if err != nil {
+			return nil, fmt.Errorf("os.Open: %w", err)
+		}
+		ff, err := ParseCleanFilters(f)
+		_ = f.Close()
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", file, err)
+		}
+		res.rules = append(res.rules, ff.rules...)
+		for d := range ff.adDomains {
+			res.adDomains[d] = true
+		}
+	}
+	return res, nil
+}
+
+func (f *CleanFilters) addRule(line string) {
+	if line == "" || line[0] == '!' || line[0] == '[' {
+		return
+	}
+	if strings.HasPrefix(line, "||") {
+		domain := strings.TrimPrefix(line, "||")
+		if i := strings.IndexByte(domain, '$'); i >= 0 {
+			domain = domain[:i]
+		}
+		if !strings.HasSuffix(domain, "^") || strings.ContainsAny(domain, "/*") {
+			return
+		}
+		f.adDomains[strings.ToLower(strings.TrimSuffix(domain, "^"))] = true
+		return
+	}
+	i := strings.Index(line, "##")
+	if i < 0 {
+		return
+	}
+	sel, err := cascadia.Compile(line[i+2:])
+	if err != nil {
+		return
+	}
+	rule := cleanRule{sel: sel}
+	for _, d := range strings.Split(line[:i], ",") {
+		d = strings.ToLower(strings.TrimSpace(d))
+		switch {
+		case d == "":
+		case d[0] == '~':
+			rule.exclude = append(rule.exclude, d[1:])
+		default:
+			rule.domains = append(rule.domains, d)
+		}
+	}
+	f.rules = append(f.rules, rule)
+}
+
+// isAdURL reports if u host is one of ad domains or their subdomain.
+func (f *CleanFilters) isAdURL(u string) bool {
+	pu, err := url.Parse(strings.TrimSpace(u))
+	if err != nil || pu.Host == "" {
+		return false
+	}
+	host := strings.ToLower(pu.Hostname())
+	for host != "" {
+		if f.adDomains[host] {
+			return true
+		}
+		i := strings.IndexByte(host, '.')
+		if i < 0 {
+			break
+		}
+		host = host[i+1:]
+	}
+	return false
+}
+
+// matchDomain reports if host is one of domains or their subdomain.
+func matchDomain(host string, domains []string) bool {
+	for _, d := range domains {
+		if host == d || strings.HasSuffix(host, "."+d) {
+			return true
+		}
+	}
+	return false
+}
+
+func (r *cleanRule) appliesTo(host string) bool {
+	if len(r.domains) > 0 && !matchDomain(host, r.domains) {
+		return false
+	}
+	return !matchDomain(host, r.exclude)
+}
+
+var (
+	fixedPositionRe = regexp.MustCompile(`(?i)position\s*:\s*(fixed|sticky)`)
+	zIndexRe        = regexp.MustCompile(`(?i)z-index\s*:\s*(\d+)`)
+)
+
+// Fixed elements are overlays, if they have short text or high z-index.
+const (
+	overlayMaxText = 300
+	overlayZIndex  = 100
+)
+
+// clean removes ads, trackers, overlays and other elements matching f
+// from doc. Scripts are removed unless keepScripts is set (scripts from ad
+// domains are removed anyway).
+func clean(doc *goquery.Document, f *CleanFilters, keepScripts bool) {
+	host := ""
+	if doc.Url != nil {
+		host = strings.ToLower(doc.Url.Hostname())
+	}
+	for i := range f.rules {
+		if f.rules[i].appliesTo(host) {
+			doc.FindMatcher(f.rules[i].sel).Remove()
+		}
+	}
+
+	doc.Find("iframe,img,script").Each(func(_ int, s *goquery.Selection) {
+		src := s.AttrOr("src", "")
+		if src == "" {
+			src = s.AttrOr("data-src", "")
+		}
+		if f.isAdURL(src) || goquery.NodeName(s) == "img" && isPixel(s) {
+			s.Remove()
+		}
+	})
+	if !keepScripts {
+		doc.Find("script").Remove()
+	}
+
+	doc.Find("noscript").Each(func(_ int, s *goquery.Selection) {
+		// fallback of image, which is already loaded
+		prev := s.Prev()
+		if goquery.NodeName(prev) == "img" && !isLazyImage(prev) {
+			s.Remove()
+			return
+		}
+		// tracking pixels
+		frag, err := goquery.NewDocumentFromReader(strings.NewReader(s.Text()))
+		if err != nil {
+			return
+		}
+		imgs := frag.Find("img")
+		if imgs.Length() > 0 && imgs.Length() == imgs.FilterFunction(func(_ int, img *goquery.Selection) bool {
+			return isPixel(img) || f.isAdURL(img.AttrOr("src", ""))
+		}).Length() && strings.TrimSpace(frag.Text()) == "" {
+			s.Remove()
+		}
+	})
+
+	doc.Find("body [style]").Each(func(_ int, s *goquery.Selection) {
+		style := s.AttrOr("style", "")
+		m := fixedPositionRe.FindStringSubmatch(style)
+		if m == nil {
+			return
+		}
+		if strings.EqualFold(m[1], "fixed") && isOverlay(s, style) {
+			s.Remove()
+			return
+		}
+		// sticky headers, sidebars and content wrappers are printed in place
+		s.SetAttr("style", fixedPositionRe.ReplaceAllString(style, "position:static"))
+	})
+}
+
+// isOverlay reports if fixed element s with style is overlay (banner,
+// popup or toolbar) rather than content.
+func isOverlay(s *goquery.Selection, style string) bool {
+	if s.Closest("article,main").Length() > 0 || s.Find("article,main").Length() > 0 {
+		return false
+	}
+	if len([]rune(strings.TrimSpace(s.Text()))) <= overlayMaxText {
+		return true
+	}
+	m := zIndexRe.FindStringSubmatch(style)
+	if m == nil {
+		return false
+	}
+	z, err := strconv.Atoi(m[1])
+	return err == nil && z >= overlayZIndex
+}
+
+// isPixel reports if img is 1x1 (or smaller) tracking image.
+func isPixel(img *goquery.Selection) bool {
+	size := func(attr string) (int, bool) {
+		v, ok := img.Attr(attr)
+		if !ok {
+			return 0, false
+		}
+		n, err := strconv.Atoi(strings.TrimSuffix(strings.TrimSpace(v), "px"))
+		return n, err == nil
+	}
+	w, wok := size("width")
+	h, hok := size("height")
+	return wok && hok && w <= 1 && h <= 1
+}
@@ -0,0 +1,111 @@
+package clip
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/PuerkitoBio/goquery"
+)
+
+func TestParseCleanFilters(t *testing.T) {
+	f, err := ParseCleanFilters(strings.NewReader(`[Adblock Plus 2.0]
+! comment
+##.banner
+example.com,~www.example.com##.promo
+example.org#@#.banner
+example.org#?#div:-abp-has(.ad)
+##div[
+||ads.example.net^$third-party
+||example.net/ads/*
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(f.rules) != 2 {
+		t.Fatalf("%d rules parsed, want 2", len(f.rules))
+	}
+	promo := f.rules[1]
+	for host, want := range map[string]bool{
+		"example.com":     true,
+		"sub.example.com": true,
+		"www.example.com": false,
+		"example.org":     false,
+	} {
+		if got := promo.appliesTo(host); got != want {
+			t.Errorf("appliesTo(%s) = %v, want %v", host, got, want)
+		}
+	}
+	for u, want := range map[string]bool{
+		"https://ads.example.net/x":     true,
+		"//cdn.ads.example.net/x":       true,
+		"https://example.net/ads/x.png": false,
+		"/local.png":                    false,
+	} {
+		if got := f.isAdURL(u); got != want {
+			t.Errorf("isAdURL(%s) = %v, want %v", u, got, want)
+		}
+	}
+}
+
+func Test_clean_positioned(t *testing.T) {
+	long := strings.Repeat("toc ", 100)
+	doc, err := goquery.NewDocumentFromReader(strings.NewReader(`<html><body>
+		<div id="banner" style="position: fixed; bottom: 0">subscribe</div>
+		<div id="modal" style="position:fixed;z-index:9999">` + long + `</div>
+		<nav id="toc" style="position: sticky; top: 0">` + long + `</nav>
+		<div id="wrap" style="position:fixed"><main><article><table>
+			<tr><th id="th" style="position: sticky!important; top: 0">head</th></tr>
+			<tr><td>text</td></tr>
+		</table></article></main></div>
+	</body></html>`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	clean(doc, &CleanFilters{}, false)
+	for _, id := range []string{"banner", "modal"} {
+		if doc.Find("#"+id).Length() != 0 {
+			t.Errorf("overlay #%s isn't removed", id)
+		}
+	}
+	for _, id := range []string{"toc", "wrap", "th"} {
+		s := doc.Find("#" + id)
+		if style := s.AttrOr("style", ""); s.Length() != 1 || !strings.HasPrefix(style, "position:static") {
+			t.Errorf("#%s: found %d, style = %q, want static content", id, s.Length(), style)
+		}
+	}
+}
+
+func Test_clean(t *testing.T) {
+	const page = `<html><head><script src="/app.js"></script></head><body>
+		<div id="cookie-consent-popup">we use cookies</div>
+		<header style="position: fixed; top: 0">menu</header>
+		<article>
+			<p>text</p>
+			<img src="a.png"><noscript><img src="a.png"></noscript>
+			<img src="https://example.com/stat.gif" width="1" height="1">
+			<noscript><img src="https://mc.yandex.ru/watch/1" alt=""></noscript>
+			<iframe src="https://tpc.googlesyndication.com/x"></iframe>
+			<iframe src="https://www.youtube.com/embed/x"></iframe>
+			<div class="social-share-bar">share</div>
+		</article>
+		<script>track()</script>
+	</body></html>`
+	doc, err := goquery.NewDocumentFromReader(strings.NewReader(page))
+	if err != nil {
+		t.Fatal(err)
+	}
+	clean(doc, DefaultCleanFilters, false)
+	got, _ := doc.Html()
+	got = strings.Join(strings.Fields(got), "")
+	want := `<html><head></head><body><article><p>text</p><imgsrc="a.png"/>` +
+		`<iframesrc="https://www.youtube.com/embed/x"></iframe></article></body></html>`
+	if got != want {
+		t.Errorf("clean() =\n%s\nwant\n%s", got, want)
+	}
+
+	doc, _ = goquery.NewDocumentFromReader(strings.NewReader(page))
+	clean(doc, DefaultCleanFilters, true)
+	if n := doc.Find("script").Length(); n != 2 {
+		t.Errorf("%d scripts kept, want 2", n)
+	}
+}
@@ -1,9 +1,11 @@
 package clip
 
 import (
+	"bytes"
 	"context"
 	"errors"
 	"fmt"
+	"html"
 	"io"
 	"io/ioutil"
 	"net/http"
@@ -18,28 +20,31 @@ import (
 	"github.com/SebastiaanKlippert/go-wkhtmltopdf"
 )
 
-var (
-	PrintArgs           bool   // print wkhtmltopdf args
-	SaveProcessedHTMLTo string // save processed HTML to directory (used if not empty)
-)
-
-func init() {
-	if os.Getenv("CLIP_PRINT_ARGS") != "" {
-		PrintArgs = true
-	}
-	SaveProcessedHTMLTo = os.Getenv("CLIP_SAVE_HTML")
-}
-
 // Params are used to tweak ToPDF output.
 type Params struct {
-	Query             *string `json:"query,omitempty" desc:"elements to include in result document"`                                  // css selector to be included in resulted PDF document
-	Remove            *string `json:"remove,omitempty" desc:"elements to remove from result document"`                                // css selector of elements to be removed
-	NoBreakBefore     *string `json:"no_break_before,omitempty" desc:"elements to disable break page before"`                         // css selector for elements to set break-before:avoid-page
-	NoBreakInside     *string `json:"no_break_inside,omitempty" desc:"elements to disable break page inside"`                         // css selector for elements to set break-inside:avoid-page
-	NoBreakAfter      *string `json:"no_break_after,omitempty" desc:"elements to disable break page after"`                           // css selector for elements to set break-after:avoid-page
-	CustomStyles      *string `json:"custom_styles,omitempty" desc:"custom css stylesheet (will be included in <head>)"`              // custom css styles to be injected into doc
-	WithContainers    *bool   `json:"with_containers,omitempty" desc:"preserve doc containers structure (useful when -query is set)"` // preserve all containert from document body to selector query result
-	ForceImageLoading *bool   `json:"force_image_loading,omitempty" desc:"replace img[src} attribute value by value of data-src"`     // replace img[src] by img[data-src] conetnt
+	Query             *string        `json:"query,omitempty" desc:"elements to include in result document"`                                                 // css selector to be included in resulted PDF document
+	Remove            *string        `json:"remove,omitempty" desc:"elements to remove from result document"`                                               // css selector of elements to be removed
+	NoBreakBefore     *string        `json:"no_break_before,omitempty" desc:"elements to disable break page before"`                                        // css selector for elements to set break-before:avoid-page
+	NoBreakInside     *string        `json:"no_break_inside,omitempty" desc:"elements to disable break page inside"`                                        // css selector for elements to set break-inside:avoid-page
+	NoBreakAfter      *string        `json:"no_break_after,omitempty" desc:"elements to disable break page after"`                                          // css selector for elements to set break-after:avoid-page
+	CustomStyles      *string        `json:"custom_styles,omitempty" desc:"custom css stylesheet (will be included in <head>)"`                             // custom css styles to be injected into doc
+	WithContainers    *bool          `json:"with_containers,omitempty" desc:"preserve doc containers structure (useful when -query is set)"`                // preserve all containert from document body to selector query result
+	ForceImageLoading *bool          `json:"force_image_loading,omitempty" desc:"load lazy and responsive images (data-src, srcset, picture, noscript)"`    // restore lazy images and pick srcset candidates for page width
+	EmbedMetadata     *bool          `json:"embed_metadata,omitempty" desc:"embed source url, title, author and dates into PDF metadata"`                   // write Info dictionary and XMP packet into result PDF
+	Archival          *bool          `json:"archival,omitempty" desc:"produce PDF/A-2b document for long-term archiving"`                                   // convert result to PDF/A-2b and validate it
+	InlineResources   *bool          `json:"inline_resources,omitempty" desc:"embed images and stylesheets into document (renderer works without network)"` // fetch subresources and replace them with data URIs
+	Charset           *string        `json:"charset,omitempty" desc:"source page encoding (overrides detected one)"`                                        // for sites declaring wrong encoding
+	Clean             *bool          `json:"clean,omitempty" desc:"remove ads, trackers, cookie banners, overlays and scripts" default:"true"`              // see CleanFilters
+	Transforms        *[]string      `json:"transforms,omitempty" desc:"DOM transformers to apply (spoilers, tweets, media, code, math)"`                   // named transformers, see Transformer
+	CodeTheme         *string        `json:"code_theme,omitempty" desc:"code blocks highlighting theme of code transform (github, bw, vs, none, etc)"`      // chroma style name, see DefaultCodeTheme
+	Rewrite           *[]RewriteRule `json:"rewrite,omitempty" desc:"DOM rewrite rules (JSON array of {selector, action, attr, value, target})"`            // declarative changes, see RewriteRule
+	Script            *string        `json:"script,omitempty" desc:"Starlark script transforming document (runs after query and remove)" trusted:"true"`    // sandboxed, see ScriptOptions
+	// image options
+	Format       *string `json:"format,omitempty" desc:"output format: pdf (default), png, jpg or webp"`
+	ImageWidth   *uint   `json:"image_width,omitempty" desc:"image width in pixels"`
+	ImageQuality *uint   `json:"image_quality,omitempty" desc:"image compression quality (0-100)"`
+	Crop         *string `json:"crop,omitempty" desc:"element to crop image to"`                              // css selector of element to be rendered alone
+	FullPage     *bool   `json:"full_page,omitempty" desc:"render whole page (not only viewport) into image"` // defaults to true
 	// global options
 	Grayscale    *bool   `json:"grayscale,omitempty"`
 	MarginBottom *uint   `json:"margin_bottom,omitempty"`
@@ -60,6 +65,15 @@ type Params struct {
 	PageOffset           *uint    `json:"page_offset,omitempty"`
 	Zoom                 *float64 `json:"zoom,omitempty"`
 	ViewportSize         *string  `json:"viewport_size,omitempty"`
+	// header and footer options ([page], [topage], [url], [title] and [date] placeholders are supported)
+	HeaderLeft   *string `json:"header_left,omitempty" desc:"left aligned header text"`
+	HeaderCenter *string `json:"header_center,omitempty" desc:"centered header text"`
+	HeaderRight  *string `json:"header_right,omitempty" desc:"right aligned header text"`
+	FooterLeft   *string `json:"footer_left,omitempty" desc:"left aligned footer text"`
+	FooterCenter *string `json:"footer_center,omitempty" desc:"centered footer text"`
+	FooterRight  *string `json:"footer_right,omitempty" desc:"right aligned footer text"`
+	HeaderHTML   *string `json:"header_html,omitempty" desc:"header html template (overrides header text)" trusted:"true"` // [page] and [topage] require enable_javascript
+	FooterHTML   *string `json:"footer_html,omitempty" desc:"footer html template (overrides footer text)" trusted:"true"` // [page] and [topage] require enable_javascript
 }
 
 // AddFrom adds missed in p values from o.
@@ -90,6 +104,22 @@ func (p *Params) AddFrom(o *Params) {
 	}
 }
 
+// TrustedOnly returns names of params set in p, which are allowed only in
+// presets and config (fields tagged trusted:"true"): they are rendered from
+// local files or run code, so they can't be taken from requests.
+func (p *Params) TrustedOnly() []string {
+	var res []string
+	pt := reflect.TypeOf(p).Elem()
+	pv := reflect.ValueOf(p).Elem()
+	for i := 0; i < pt.NumField(); i++ {
+		fld := pt.Field(i)
+		if fld.Tag.Get("trusted") == "true" && !pv.Field(i).IsNil() {
+			res = append(res, strings.Split(fld.Tag.Get("json"), ",")[0])
+		}
+	}
+	return res
+}
+
 // String pretty prints struct values
 func (p *Params) String() string {
 	if p == nil {
@@ -117,6 +147,24 @@ func (p *Params) validate() error {
 	if err != nil {
 		return &ValidationError{err.Error()}
 	}
+	if p.Format != nil && !isFormat(*p.Format) {
+		return &ValidationError{"bad value for format parameter: " + *p.Format}
+	}
+	if p.Charset != nil && *p.Charset != "" && !isCharset(*p.Charset) {
+		return &ValidationError{"unknown charset: " + *p.Charset}
+	}
+	if p.CodeTheme != nil && *p.CodeTheme != "" && !isCodeTheme(*p.CodeTheme) {
+		return &ValidationError{"unknown code theme: " + *p.CodeTheme}
+	}
+	if p.Rewrite != nil {
+		err = ValidateRewrite(*p.Rewrite)
+		if err != nil {
+			return err
+		}
+	}
+	if p.ImageQuality != nil && *p.ImageQuality > 100 {
+		return &ValidationError{"image_quality should be in range 0-100"}
+	}
 	if p.Orientation != nil &&
 		*p.Orientation != wkhtmltopdf.OrientationLandscape &&
 		*p.Orientation != wkhtmltopdf.OrientationPortrait {
@@ -127,7 +175,9 @@ func (p *Params) validate() error {
 }
 
 func (p *Params) skipDOMProcess() bool {
-	return p.Query == nil && p.Remove == nil &&
+	return p.Query == nil && p.Remove == nil && p.Crop == nil &&
+		p.InlineResources == nil && p.Charset == nil && p.Transforms == nil && p.Script == nil && p.Rewrite == nil &&
+		p.Clean != nil && !*p.Clean &&
 		p.CustomStyles == nil && p.ForceImageLoading == nil &&
 		p.NoBreakBefore != nil && p.NoBreakInside == nil &&
 		p.NoBreakAfter != nil
@@ -174,6 +224,11 @@ func (p *Params) mergePageOptions(o *wkhtmltopdf.PageOptions) { //nolint:unused
 		o.DisableInternalLinks.Set(*p.DisableInternalLinks)
 	}
 	o.DisableJavascript.Set(p.EnableJavascript == nil || !*p.EnableJavascript)
+	if p.InlineResources != nil && *p.InlineResources {
+		o.Proxy.Set(blackholeProxy)
+		o.LoadErrorHandling.Set("ignore")
+		o.LoadMediaErrorHandling.Set("ignore")
+	}
 	if p.NoBackground != nil {
 		o.NoBackground.Set(*p.NoBackground)
 	}
@@ -188,6 +243,100 @@ func (p *Params) mergePageOptions(o *wkhtmltopdf.PageOptions) { //nolint:unused
 	}
 }
 
+// mergeHeaderFooter sets header and footer options, expanding placeholders
+// for url. HTML templates are written to temporary files, which are removed
+// by returned cleanup func.
+func (p *Params) mergeHeaderFooter(o *wkhtmltopdf.PageOptions, url string) (cleanup func(), err error) {
+	var files []string
+	cleanup = func() {
+		for _, f := range files {
+			_ = os.Remove(f)
+		}
+	}
+	defer func() {
+		if err != nil {
+			cleanup()
+		}
+	}()
+
+	texts := []struct {
+		v   *string
+		opt interface{ Set(string) }
+	}{
+		{p.HeaderLeft, &o.HeaderLeft},
+		{p.HeaderCenter, &o.HeaderCenter},
+		{p.HeaderRight, &o.HeaderRight},
+		{p.FooterLeft, &o.FooterLeft},
+		{p.FooterCenter, &o.FooterCenter},
+		{p.FooterRight, &o.FooterRight},
+	}
+	for _, t := range texts {
+		if t.v != nil {
+			t.opt.Set(expandText(*t.v, url))
+		}
+	}
+
+	templates := []struct {
+		v   *string
+		opt interface{ Set(string) }
+	}{
+		{p.HeaderHTML, &o.HeaderHTML},
+		{p.FooterHTML, &o.FooterHTML},
+	}
+	for _, t := range templates {
+		if t.v == nil || *t.v == "" {
+			continue
+		}
+		f, err := ioutil.TempFile("", "clip-*.html")
+		if err != nil {
+			return nil, fmt.Errorf("ioutil.TempFile: %w", err)
+		}
+		files = append(files, f.Name())
+		_, err = f.WriteString(expandHTML(*t.v, url))
+		if cerr := f.Close(); err == nil {
+			err = cerr
+		}
+		if err != nil {
+			return nil, fmt.Errorf("write template: %w", err)
+		}
+		t.opt.Set(f.Name())
+	}
+
+	return cleanup, nil
+}
+
+// expandText replaces placeholders, which are not supported by
+// wkhtmltopdf, in header or footer text.
+func expandText(s, url string) string {
+	return strings.ReplaceAll(s, "[url]", url)
+}
+
+// substScript fills elements with class names equal to wkhtmltopdf
+// header and footer variables.
+const substScript = `<script>(function(){var v={};` +
+	`location.search.substring(1).split('&').forEach(function(kv){` +
+	`var p=kv.split('=',2);v[p[0]]=decodeURIComponent((p[1]||'').replace(/\+/g,' '))});` +
+	`['page','topage','title','date'].forEach(function(n){` +
+	`var es=document.getElementsByClassName(n);` +
+	`for(var i=0;i<es.length;i++)es[i].textContent=v[n]||''})})()</script>`
+
+// expandHTML replaces placeholders in header or footer HTML template.
+// [url] is substituted in place, all other placeholders are replaced by
+// elements, which are filled by substScript.
+func expandHTML(s, url string) string {
+	s = strings.NewReplacer(
+		"[url]", html.EscapeString(url),
+		"[page]", `<span class="page"></span>`,
+		"[topage]", `<span class="topage"></span>`,
+		"[title]", `<span class="title"></span>`,
+		"[date]", `<span class="date"></span>`,
+	).Replace(s)
+	if !strings.Contains(strings.ToLower(s), "<!doctype") {
+		s = "<!DOCTYPE html>" + s
+	}
+	return s + substScript
+}
+
 // Package errors.
 var (
 	ErrBadStatus       = errors.New("bad status")
@@ -217,6 +366,17 @@ func (e *URLError) Error() string {
 	return e.inner.Error()
 }
 
+// ConformanceError is returned if archival document doesn't conform
+// to PDF/A requirements.
+type ConformanceError struct {
+	Violations []string
+}
+
+// Error is error interface implementation.
+func (e *ConformanceError) Error() string {
+	return "PDF/A conformance: " + strings.Join(e.Violations, "; ")
+}
+
 type ValidationError struct {
 	Message string
 }
@@ -226,13 +386,9 @@ func (e *ValidationError) Error() string {
 	return fmt.Sprintf("validation error: %s", e.Message)
 }
 
-func ToPDF(url string, w io.Writer, p *Params) error {
-	return ToPDFCtx(context.Background(), url, w, p)
-}
-
 // ToPDFCtx downloads page from url, converts it to PDF via wkhtmltopdf
 // and writes result to w.
-func ToPDFCtx(ctx context.Context, url string, w io.Writer, p *Params) error {
+func (c *Clipper) ToPDFCtx(ctx context.Context, url string, w io.Writer, p *Params) error {
 	if ctx == nil {
 		panic("clip.ToPDFCtx: ctx is nil")
 	}
@@ -243,61 +399,91 @@ func ToPDFCtx(ctx context.Context, url string, w io.Writer, p *Params) error {
 		panic("clip.ToPDFCtx: params is nil")
 	}
 	err := p.validate()
+	if err == nil {
+		err = c.checkTransforms(p)
+	}
 	if err != nil {
 		return err
 	}
 	if url == "" {
 		return ErrNoURL
 	}
-	gen, err := wkhtmltopdf.NewPDFGenerator()
+	bin, err := c.pdfBinPath()
 	if err != nil {
-		return fmt.Errorf("wkhtmltopdf.NewPDFGenerator: %w", err)
+		return err
 	}
-	tURL, err := neturl.Parse(url)
+	gen := wkhtmltopdf.NewPDFPreparer()
+	tURL, err := parseURL(url)
 	if err != nil {
-		return &URLError{err}
-	}
-	if tURL.Scheme != "http" && tURL.Scheme != "https" { // ensure user not trying to get file from our local disk
-		return fmt.Errorf("%w: %s", ErrBadURLScheme, tURL.Scheme)
+		return err
 	}
 
+	var (
+		opts  *wkhtmltopdf.PageOptions
+		meta  *pageMeta
+		stdin io.Reader
+	)
 	switch {
 	case p.skipDOMProcess():
 		pg := wkhtmltopdf.NewPage(url)
-		p.mergePageOptions(&pg.PageOptions)
+		opts = &pg.PageOptions
 		gen.AddPage(pg)
 	default:
-		txt, err := getHTML(ctx, tURL, p)
+		var txt string
+		txt, meta, err = c.getHTML(ctx, tURL, p)
 		if err != nil {
 			return err
 		}
-		r := strings.NewReader(txt)
-		pr := wkhtmltopdf.NewPageReader(r)
-		p.mergePageOptions(&pr.PageOptions)
+		stdin = strings.NewReader(txt)
+		pr := wkhtmltopdf.NewPageReader(stdin)
+		opts = &pr.PageOptions
 		gen.AddPage(pr)
 	}
-	p.mergeGen(gen)
-	if PrintArgs {
-		fmt.Fprintln(os.Stderr, "wkhtmltopdf args:", gen.ArgString())
+	p.mergePageOptions(opts)
+	cleanup, err := p.mergeHeaderFooter(opts, url)
+	if err != nil {
+		return err
 	}
+	defer cleanup()
+	p.mergeGen(gen)
 
-	genErr := gen.Create() // this almost always return some error (underlied process stderr output)
+	// output is streamed to w, unless it has to be post-processed
+	out := newPeekWriter(w)
+	var dst io.Writer = out
+	buf := new(bytes.Buffer)
+	if p.needMeta() {
+		dst = buf
+	}
+	genErr := c.run(ctx, bin, gen.Args(), stdin, dst) // this almost always return some error (underlied process stderr output)
+	if errors.Is(genErr, ErrRenderTimeout) {
+		return genErr
+	}
 	select {
 	case <-ctx.Done():
 		return fmt.Errorf("context error: %w", ctx.Err())
 	default:
 	}
 
-	n, err := io.Copy(w, gen.Buffer())
-	if err != nil {
-		return fmt.Errorf("io.Copy: %w", err)
+	if buf.Len() > 0 {
+		b, err := postProcess(ctx, buf.Bytes(), url, p, meta)
+		if err != nil {
+			return fmt.Errorf("postProcess: %w", err)
+		}
+		_, _ = out.Write(b)
+	}
+	out.flush()
+	if out.err != nil {
+		return out.err
 	}
-	if n < 1 {
+	if out.n < 1 {
 		if genErr != nil {
 			return fmt.Errorf("no PDF was generated: %w", genErr) // now we treat wkhtmltopdf error as unrecoverable
 		}
 		return fmt.Errorf("no PDF was generated")
 	}
+	if !out.pdfComplete() {
+		return fmt.Errorf("%w: PDF is truncated after %d bytes: %v", ErrIncompleteOutput, out.n, genErr)
+	}
 
 	if genErr != nil {
 		return &IgnoredError{genErr} // just for logging
@@ -306,56 +492,121 @@ func ToPDFCtx(ctx context.Context, url string, w io.Writer, p *Params) error {
 	return nil
 }
 
-// getHTML returns processed with Params from p html string
-func getHTML(ctx context.Context, url *neturl.URL, p *Params) (string, error) {
-	req, err := http.NewRequestWithContext(ctx, "GET", url.String(), nil)
+// parseURL parses target url and checks its scheme.
+func parseURL(url string) (*neturl.URL, error) {
+	tURL, err := neturl.Parse(url)
+	if err != nil {
+		return nil, &URLError{err}
+	}
+	if tURL.Scheme != "http" && tURL.Scheme != "https" { // ensure user not trying to get file from our local disk
+		return nil, fmt.Errorf("%w: %s", ErrBadURLScheme, tURL.Scheme)
+	}
+	return tURL, nil
+}
+
+// getHTML returns processed with Params from p html string and source
+// page metadata.
+func (c *Clipper) getHTML(ctx context.Context, url *neturl.URL, p *Params) (string, *pageMeta, error) {
+	limits, client := &c.limits, c.client
+	fetchCtx, cancel := ctx, context.CancelFunc(func() {})
+	if limits.Timeout > 0 {
+		fetchCtx, cancel = context.WithTimeout(ctx, limits.Timeout)
+	}
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(fetchCtx, "GET", url.String(), nil)
 	if err != nil {
-		return "", fmt.Errorf("http.NewRequestWithContext: %w", err)
+		return "", nil, fmt.Errorf("http.NewRequestWithContext: %w", err)
 	}
 	req.Header.Set("user-agent", "clip-to-pdf/1.0")
-	resp, err := http.DefaultClient.Do(req)
+	resp, err := client.Do(req)
 	if err != nil {
-		return "", fmt.Errorf("http.DefaultClient.Do: %w", err)
+		return "", nil, fmt.Errorf("http.Client.Do: %w", fetchError(ctx, fetchCtx, err))
 	}
 	defer func() { _ = resp.Body.Close() }()
 
 	if resp.StatusCode/200 != 1 {
-		return "", fmt.Errorf("%w: %d", ErrBadStatus, resp.StatusCode)
+		return "", nil, fmt.Errorf("%w: %d", ErrBadStatus, resp.StatusCode)
+	}
+	if resp.ContentLength > 0 && limits.MaxHTMLSize > 0 && resp.ContentLength > limits.MaxHTMLSize {
+		return "", nil, fmt.Errorf("%w: page size %d exceeds %d bytes", ErrTooLarge,
+			resp.ContentLength, limits.MaxHTMLSize)
+	}
+	err = limits.checkContentType(resp.Header.Get("content-type"))
+	if err != nil {
+		return "", nil, err
 	}
 
-	doc, err := goquery.NewDocumentFromReader(resp.Body)
+	var cs string
+	if p.Charset != nil {
+		cs = *p.Charset
+	}
+	body, err := decodeBody(limitBody(resp.Body, limits.MaxHTMLSize), resp.Header.Get("content-type"), cs)
 	if err != nil {
-		return "", fmt.Errorf("goquery.NewDocumentFromReader: %w", err)
+		return "", nil, fetchError(ctx, fetchCtx, err)
+	}
+	doc, err := goquery.NewDocumentFromReader(body)
+	if err != nil {
+		return "", nil, fmt.Errorf("goquery.NewDocumentFromReader: %w", fetchError(ctx, fetchCtx, err))
 	}
 	doc.Url = url
+	setCharsetMeta(doc)
+
+	var meta *pageMeta
+	if p.needMeta() {
+		meta = extractMeta(doc)
+	}
 
-	applyChanges(doc, p)
+	err = c.applyChanges(ctx, doc, p)
+	if err != nil {
+		return "", nil, err
+	}
+	err = c.transform(ctx, doc, p)
+	if err != nil {
+		return "", nil, err
+	}
+	// clean after transforms, so embeds restored by them are filtered too
+	if p.Clean == nil || *p.Clean {
+		clean(doc, c.cleanFilters, p.EnableJavascript != nil && *p.EnableJavascript)
+	}
 	if len(doc.Find("body").Children().Nodes) == 0 {
-		return "", ErrNoQueryResult
+		return "", nil, ErrNoQueryResult
+	}
+	if p.InlineResources != nil && *p.InlineResources {
+		err = inlineResources(ctx, doc, client, c.inline)
+		if err != nil {
+			return "", nil, err
+		}
+	}
+	if c.opts.Hooks.Document != nil {
+		err = c.opts.Hooks.Document(ctx, doc)
+		if err != nil {
+			return "", nil, fmt.Errorf("document hook: %w", err)
+		}
 	}
 
 	txt, err := doc.Html()
 	if err != nil {
-		return "", fmt.Errorf("doc.Html: %w", err)
+		return "", nil, fmt.Errorf("doc.Html: %w", err)
 	}
 
-	err = dump(url, txt)
+	err = c.dump(url, txt)
 	if err != nil {
-		return "", err
+		return "", nil, err
 	}
 
-	return txt, nil
+	return txt, meta, nil
 }
 
-// dump html to <SaveProcessedHTMLTo>/<domain name> folder
-// if SaveProcessedHTMLTo != "". Name of file will be set to
+// dump html to <DumpDir>/<domain name> folder
+// if DumpDir option != "". Name of file will be set to
 // last segment of path with .html extension, or index.html,
 // if path is empty.
-func dump(url *neturl.URL, html string) error {
-	if SaveProcessedHTMLTo == "" {
+func (c *Clipper) dump(url *neturl.URL, html string) error {
+	if c.opts.DumpDir == "" {
 		return nil
 	}
-	dir := filepath.Join(SaveProcessedHTMLTo, url.Host)
+	dir := filepath.Join(c.opts.DumpDir, url.Host)
 	err := os.MkdirAll(dir, 0755) //nolint:gosec
 	if err != nil {
 		return fmt.Errorf("os.MkdirAll: %w", err)
@@ -378,7 +629,7 @@ func dump(url *neturl.URL, html string) error {
 
 // applyChanges removes elements not matching css queries in qs from DOM body.
 // It preserves containers structure if preserveContainers == true.
-func applyChanges(doc *goquery.Document, p *Params) {
+func (c *Clipper) applyChanges(ctx context.Context, doc *goquery.Document, p *Params) error {
 	body := doc.Find("body")
 	if p.Query != nil && len(*p.Query) > 0 {
 		sel := doc.Find(*p.Query)
@@ -391,14 +642,24 @@ func applyChanges(doc *goquery.Document, p *Params) {
 	if p.Remove != nil && len(*p.Remove) > 0 {
 		doc.Find(*p.Remove).Remove()
 	}
+	if p.Rewrite != nil {
+		rewrite(doc, *p.Rewrite)
+	}
+	if p.Script != nil && len(*p.Script) > 0 {
+		err := runScript(ctx, *p.Script, doc, c.script)
+		if err != nil {
+			return err
+		}
+	}
+	if p.IsImage() && p.Crop != nil && len(*p.Crop) > 0 {
+		sel := doc.Find(*p.Crop).First()
+		body.Children().Remove()
+		body.AppendSelection(sel)
+		doc.Find("head").AppendHtml("<style type=\"text/css\">" +
+			"html,body{margin:0!important;padding:0!important}</style>")
+	}
 	if p.ForceImageLoading != nil && *p.ForceImageLoading {
-		doc.Find("img").Each(func(_ int, sel *goquery.Selection) {
-			v, _ := sel.Attr("data-src")
-			if v == "" {
-				return
-			}
-			sel.SetAttr("src", v)
-		})
+		normalizeImages(doc, p.targetImageWidth())
 	}
 	head := doc.Find("head")
 	if p.NoBreakBefore != nil && len(*p.NoBreakBefore) > 0 {
@@ -417,33 +678,92 @@ func applyChanges(doc *goquery.Document, p *Params) {
 		head.AppendHtml("<style type=\"text/css\">" + *p.CustomStyles + "</style>")
 	}
 	convertURLs(doc)
+	return nil
+}
+
+// urlAttrs are attributes holding single URL.
+var urlAttrs = map[string]bool{
+	"href":       true,
+	"src":        true,
+	"poster":     true,
+	"action":     true,
+	"formaction": true,
+	"data":       true,
+	"cite":       true,
+	"background": true,
+	"longdesc":   true,
+	"manifest":   true,
+	"icon":       true,
+	"codebase":   true,
+	"profile":    true,
+	"lowsrc":     true,
+	"dynsrc":     true,
 }
 
-// convertURLs makes URLs absolute
+// convertURLs makes URLs in attributes and inline CSS absolute (resolved
+// against <base href> or document URL). In-document references
+// ("#id") are kept as is, so they remain internal links in result.
 func convertURLs(doc *goquery.Document) {
-	for _, n := range doc.Find("[href],[src]").Nodes {
+	base := doc.Url
+	if base == nil {
+		return
+	}
+	if href, ok := doc.Find("base[href]").First().Attr("href"); ok {
+		if u := absURL(base, href); u != "" {
+			base, _ = neturl.Parse(u)
+		}
+	}
+	// all URLs are absolute now, base would only break "#id" links
+	doc.Find("base").Remove()
+
+	abs := func(ref string) string {
+		if u := absURL(base, ref); u != "" {
+			return u
+		}
+		return ref
+	}
+	doc.Find("*").Each(func(_ int, sel *goquery.Selection) {
+		n := sel.Nodes[0]
 		for i := range n.Attr {
-			if n.Attr[i].Key == "href" || n.Attr[i].Key == "src" {
-				url, err := neturl.Parse(n.Attr[i].Val)
-				if err != nil || url.IsAbs() {
-					continue
-				}
-				if url.Host == "" {
-					url.Host = doc.Url.Host
-					url.Scheme = doc.Url.Scheme
+			a := &n.Attr[i]
+			switch {
+			case urlAttrs[a.Key]:
+				a.Val = abs(a.Val)
+			case a.Key == "srcset":
+				cs := parseSrcset(a.Val)
+				for i := range cs {
+					cs[i].URL = abs(cs[i].URL)
 				}
-				switch {
-				case url.Scheme == "":
-					url.Scheme = doc.Url.Scheme
-				case url.Path != "" && !strings.HasPrefix(url.Path, "/"):
-					url.Path = doc.Url.Path + "/" + url.Path
-				case url.Fragment != "":
-					url.Path = doc.Url.Path
-				}
-				n.Attr[i].Val = url.String()
+				a.Val = formatSrcset(cs)
+			case a.Key == "style" && len(cssURLs(a.Val)) > 0:
+				a.Val = rewriteCSSURLs(a.Val, abs)
 			}
 		}
+	})
+	doc.Find("style").Each(func(_ int, sel *goquery.Selection) {
+		css := sel.Text()
+		if len(cssURLs(css)) == 0 {
+			return
+		}
+		setRawText(sel, rewriteCSSURLs(css, abs))
+	})
+}
+
+// absURL resolves ref against base as RFC 3986 describes. It returns
+// empty string for empty, in-document ("#id") and invalid references.
+func absURL(base *neturl.URL, ref string) string {
+	ref = strings.TrimSpace(ref)
+	if ref == "" || strings.HasPrefix(ref, "#") {
+		return ""
+	}
+	u, err := neturl.Parse(ref)
+	if err != nil {
+		return ""
+	}
+	if u.IsAbs() {
+		return ref // keep data:, mailto:, javascript: etc untouched
 	}
+	return base.ResolveReference(u).String()
 }
 
 // containerize preserves containers structure from root to sel
@@ -1,8 +1,16 @@
 package clip
 
 import (
+	"io/ioutil"
+	neturl "net/url"
+	"os"
 	"reflect"
+	"strings"
 	"testing"
+	"time"
+
+	"github.com/PuerkitoBio/goquery"
+	"github.com/SebastiaanKlippert/go-wkhtmltopdf"
 )
 
 func TestParams_AddFrom(t *testing.T) {
@@ -33,3 +41,169 @@ func TestParams_AddFrom(t *testing.T) {
 		}
 	})
 }
+
+func Test_extractMeta(t *testing.T) {
+	doc, err := goquery.NewDocumentFromReader(strings.NewReader(`<html><head>
+		<title>Page title</title>
+		<meta property="og:title" content="OG title">
+		<meta property="article:author" content="https://example.com/users/1">
+		<meta name="description" content="Description">
+		<script type="application/ld+json">{"@graph":[
+			{"@type":"WebSite","name":"Site"},
+			{"@type":"Article","headline":"LD title","author":[{"name":"A"},{"name":"B"}],
+			 "datePublished":"2020-10-01T12:00:00+03:00"}
+		]}</script>
+		</head><body></body></html>`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := &pageMeta{
+		Title:       "OG title",
+		Author:      "A, B",
+		Description: "Description",
+		Published:   time.Date(2020, 10, 1, 9, 0, 0, 0, time.UTC),
+	}
+	got := extractMeta(doc)
+	if !got.Published.Equal(want.Published) {
+		t.Errorf("extractMeta().Published = %v, want %v", got.Published, want.Published)
+	}
+	got.Published = want.Published
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("extractMeta() = %+v, want %+v", got, want)
+	}
+}
+
+func Test_absURL(t *testing.T) {
+	base, _ := neturl.Parse("http://a/b/c/d;p?q")
+	tests := []struct {
+		ref, want string
+	}{
+		// RFC 3986, section 5.4.1
+		{"g", "http://a/b/c/g"},
+		{"./g", "http://a/b/c/g"},
+		{"g/", "http://a/b/c/g/"},
+		{"/g", "http://a/g"},
+		{"//g", "http://g"},
+		{"?y", "http://a/b/c/d;p?y"},
+		{"g?y", "http://a/b/c/g?y"},
+		{"g#s", "http://a/b/c/g#s"},
+		{";x", "http://a/b/c/;x"},
+		{".", "http://a/b/c/"},
+		{"..", "http://a/b/"},
+		{"../g", "http://a/b/g"},
+		{"../..", "http://a/"},
+		{"../../g", "http://a/g"},
+		// RFC 3986, section 5.4.2
+		{"../../../g", "http://a/g"},
+		{"/./g", "http://a/g"},
+		{"g.", "http://a/b/c/g."},
+		{"./../g", "http://a/b/g"},
+		{"g/../h", "http://a/b/c/h"},
+		// kept as is
+		{"", ""},
+		{"#s", ""},
+		{"https://x/y", "https://x/y"},
+		{"data:image/png;base64,AA==", "data:image/png;base64,AA=="},
+		{"mailto:a@b", "mailto:a@b"},
+		{" g ", "http://a/b/c/g"},
+	}
+	for _, tt := range tests {
+		if got := absURL(base, tt.ref); got != tt.want {
+			t.Errorf("absURL(%q) = %q, want %q", tt.ref, got, tt.want)
+		}
+	}
+}
+
+func Test_convertURLs(t *testing.T) {
+	tests := []struct {
+		name, in, sel, attr, want string
+	}{
+		{"relative", `<a href="../x.html">`, "a", "href", "http://site/a/x.html"},
+		{"query only", `<a href="?page=2">`, "a", "href", "http://site/a/b/page.html?page=2"},
+		{"fragment", `<a href="#top">`, "a", "href", "#top"},
+		{"base href", `<base href="/cdn/"><img src="i.png">`, "img", "src", "http://site/cdn/i.png"},
+		{"srcset", `<img srcset="i.png 1x, /j.png 2x">`, "img", "srcset", "http://site/a/b/i.png 1x, http://site/j.png 2x"},
+		{"poster", `<video poster="p.jpg"></video>`, "video", "poster", "http://site/a/b/p.jpg"},
+		{"action", `<form action="send"></form>`, "form", "action", "http://site/a/b/send"},
+		{"cite", `<blockquote cite="/src"></blockquote>`, "blockquote", "cite", "http://site/src"},
+		{"style attr", `<div style="background:url('../bg.png')"></div>`, "div", "style", `background:url("http://site/a/bg.png")`},
+		{"absolute", `<a href="https://other/x">`, "a", "href", "https://other/x"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			doc, err := goquery.NewDocumentFromReader(strings.NewReader(tt.in))
+			if err != nil {
+				t.Fatal(err)
+			}
+			doc.Url, _ = neturl.Parse("http://site/a/b/page.html")
+			convertURLs(doc)
+			if got := doc.Find(tt.sel).AttrOr(tt.attr, ""); got != tt.want {
+				t.Errorf("%s[%s] = %q, want %q", tt.sel, tt.attr, got, tt.want)
+			}
+			if doc.Find("base").Length() != 0 {
+				t.Errorf("base element was not removed")
+			}
+		})
+	}
+
+	doc, _ := goquery.NewDocumentFromReader(strings.NewReader(
+		`<style>@import "print.css"; p{background:url(i.png)}</style>`))
+	doc.Url, _ = neturl.Parse("http://site/a/")
+	convertURLs(doc)
+	want := `@import url("http://site/a/print.css"); p{background:url("http://site/a/i.png")}`
+	if got := doc.Find("style").Text(); got != want {
+		t.Errorf("style = %q, want %q", got, want)
+	}
+}
+
+func Test_expandText(t *testing.T) {
+	got := expandText("[url] [page]/[topage]", "https://example.com/a?b=1&c=2")
+	if want := "https://example.com/a?b=1&c=2 [page]/[topage]"; got != want {
+		t.Errorf("expandText() = %q, want %q", got, want)
+	}
+}
+
+func Test_expandHTML(t *testing.T) {
+	got := expandHTML(`<p>[url] [page]/[topage] [title] [date]</p>`, "https://example.com/?a=1&b=2")
+	want := `<!DOCTYPE html><p>https://example.com/?a=1&amp;b=2 <span class="page"></span>/` +
+		`<span class="topage"></span> <span class="title"></span> <span class="date"></span></p>` + substScript
+	if got != want {
+		t.Errorf("expandHTML() =\n%s\nwant\n%s", got, want)
+	}
+	if got = expandHTML("<!doctype html><p>x</p>", ""); strings.Count(strings.ToLower(got), "<!doctype") != 1 {
+		t.Errorf("expandHTML() doctype is duplicated: %s", got)
+	}
+}
+
+func TestParams_mergeHeaderFooter(t *testing.T) {
+	left, tmpl := "[url]", "<b>[page]</b>"
+	p := &Params{FooterLeft: &left, HeaderHTML: &tmpl}
+	o := wkhtmltopdf.NewPageOptions()
+	cleanup, err := p.mergeHeaderFooter(&o, "https://example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	args := o.Args()
+	var file string
+	for i := 0; i+1 < len(args); i++ {
+		switch args[i] {
+		case "--footer-left":
+			if args[i+1] != "https://example.com" {
+				t.Errorf("--footer-left = %q", args[i+1])
+			}
+		case "--header-html":
+			file = args[i+1]
+		}
+	}
+	b, err := ioutil.ReadFile(file)
+	if err != nil {
+		t.Fatalf("header template: %v", err)
+	}
+	if !strings.Contains(string(b), `<b><span class="page"></span></b>`) {
+		t.Errorf("header template = %s", b)
+	}
+	cleanup()
+	if _, err = os.Stat(file); !os.IsNotExist(err) {
+		t.Errorf("template isn't removed by cleanup: %v", err)
+	}
+}
@@ -0,0 +1,193 @@
+package clip
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/PuerkitoBio/goquery"
+	"github.com/SebastiaanKlippert/go-wkhtmltopdf"
+)
+
+// DefaultRenderTimeout limits renderer run time, if
+// ClipperOptions.RenderTimeout is zero.
+const DefaultRenderTimeout = 2 * time.Minute
+
+// Hooks are called by Clipper while page is clipped. Nil hooks are skipped.
+type Hooks struct {
+	// Document is called with processed document before it's passed to
+	// renderer. Returned error aborts clipping.
+	Document func(ctx context.Context, doc *goquery.Document) error
+	// Args is called with renderer executable and arguments before it runs.
+	Args func(ctx context.Context, bin string, args []string)
+}
+
+// ClipperOptions configure Clipper. Zero value is valid.
+type ClipperOptions struct {
+	WkhtmltopdfPath   string         // looked up by go-wkhtmltopdf if empty
+	WkhtmltoimagePath string         // looked up next to wkhtmltopdf, in executable dir and in PATH if empty
+	PdftoppmPath      string         // poppler pdftoppm used for previews, looked up as wkhtmltoimage if empty
+	HTTPClient        *http.Client   // pages and resources client (built from Limits if nil)
+	Limits            *Limits        // fetch limits (DefaultLimits if nil)
+	Inline            *InlineOptions // resources inlining options (DefaultInlineOptions if nil)
+	Script            *ScriptOptions // Params.Script limits (DefaultScriptOptions if nil)
+	CleanFilters      *CleanFilters  // Params.Clean rules (DefaultCleanFilters if nil)
+	MediaHosts        []string       // iframe hosts replaced by media transform (DefaultMediaHosts if nil)
+	RenderTimeout     time.Duration  // renderer run time limit (DefaultRenderTimeout if zero, negative - no limit)
+	DumpDir           string         // save processed HTML to <DumpDir>/<domain name> (used if not empty)
+	ArgsLog           io.Writer      // print renderer arguments to (used if not nil)
+	Hooks             Hooks
+	// Transformers are named DOM transformers, which can be enabled by
+	// Params.Transforms in addition to built-in ones (and replace
+	// built-in ones with the same name).
+	Transformers map[string]Transformer
+}
+
+// Clipper clips pages to PDF documents and images. It's safe for
+// concurrent use, and clippers with different options can be used in the
+// same process.
+type Clipper struct {
+	opts         ClipperOptions
+	limits       Limits
+	inline       InlineOptions
+	script       ScriptOptions
+	cleanFilters *CleanFilters
+	client       *http.Client
+	imageBin     binLookup
+	rasterBin    binLookup
+}
+
+// binLookup is result of executable lookup.
+type binLookup struct {
+	sync.Once
+	path string
+	err  error
+}
+
+// NewClipper creates Clipper.
+func NewClipper(opts ClipperOptions) *Clipper {
+	c := &Clipper{opts: opts, limits: DefaultLimits, inline: DefaultInlineOptions, script: DefaultScriptOptions}
+	if opts.Limits != nil {
+		c.limits = *opts.Limits
+	}
+	if opts.Inline != nil {
+		c.inline = *opts.Inline
+	}
+	if opts.Script != nil {
+		c.script = *opts.Script
+	}
+	c.cleanFilters = opts.CleanFilters
+	if c.cleanFilters == nil {
+		c.cleanFilters = DefaultCleanFilters
+	}
+	c.inline.MaxTotalSize = c.limits.MaxResourcesSize
+	c.client = opts.HTTPClient
+	if c.client == nil {
+		c.client = c.limits.client()
+	}
+	switch {
+	case opts.RenderTimeout == 0:
+		c.opts.RenderTimeout = DefaultRenderTimeout
+	case opts.RenderTimeout < 0:
+		c.opts.RenderTimeout = 0
+	}
+	return c
+}
+
+var defaultClipper = NewClipper(ClipperOptions{})
+
+// ToPDF is ToPDFCtx with background context.
+func ToPDF(url string, w io.Writer, p *Params) error {
+	return defaultClipper.ToPDFCtx(context.Background(), url, w, p)
+}
+
+// ToPDFCtx clips page with default Clipper (see Clipper.ToPDFCtx).
+func ToPDFCtx(ctx context.Context, url string, w io.Writer, p *Params) error {
+	return defaultClipper.ToPDFCtx(ctx, url, w, p)
+}
+
+// ToImageCtx clips page with default Clipper (see Clipper.ToImageCtx).
+func ToImageCtx(ctx context.Context, url string, w io.Writer, p *Params) error {
+	return defaultClipper.ToImageCtx(ctx, url, w, p)
+}
+
+// PreviewCtx renders preview with default Clipper (see Clipper.PreviewCtx).
+func PreviewCtx(ctx context.Context, url string, w io.Writer, p *Params, maxWidth, dpi uint) error {
+	return defaultClipper.PreviewCtx(ctx, url, w, p, maxWidth, dpi)
+}
+
+// pdfBinPath returns wkhtmltopdf executable path.
+func (c *Clipper) pdfBinPath() (string, error) {
+	if c.opts.WkhtmltopdfPath != "" {
+		return c.opts.WkhtmltopdfPath, nil
+	}
+	_, err := wkhtmltopdf.NewPDFGenerator()
+	if err != nil {
+		return "", fmt.Errorf("wkhtmltopdf.NewPDFGenerator: %w", err)
+	}
+	return wkhtmltopdf.GetPath(), nil
+}
+
+// imageBinPath returns WkhtmltoimagePath option if it's set, otherwise it
+// looks for wkhtmltoimage executable (see lookupBin).
+func (c *Clipper) imageBinPath() (string, error) {
+	if c.opts.WkhtmltoimagePath != "" {
+		return c.opts.WkhtmltoimagePath, nil
+	}
+	return c.lookupBin(&c.imageBin, "wkhtmltoimage")
+}
+
+// rasterBinPath returns PdftoppmPath option if it's set, otherwise it
+// looks for pdftoppm executable (see lookupBin).
+func (c *Clipper) rasterBinPath() (string, error) {
+	if c.opts.PdftoppmPath != "" {
+		return c.opts.PdftoppmPath, nil
+	}
+	return c.lookupBin(&c.rasterBin, "pdftoppm")
+}
+
+// lookupBin looks for exe next to wkhtmltopdf, in current executable dir,
+// in PATH and in WKHTMLTOPDF_PATH dir. Result is cached in l.
+func (c *Clipper) lookupBin(l *binLookup, exe string) (string, error) {
+	l.Do(func() {
+		var dirs []string
+		if bin, err := c.pdfBinPath(); err == nil {
+			dirs = append(dirs, filepath.Dir(bin))
+		}
+		if dir, err := filepath.Abs(filepath.Dir(os.Args[0])); err == nil {
+			dirs = append(dirs, dir)
+		}
+		dirs = append(dirs, "")
+		if dir := os.Getenv("WKHTMLTOPDF_PATH"); dir != "" {
+			dirs = append(dirs, dir)
+		}
+		for _, dir := range dirs {
+			path, err := exec.LookPath(filepath.Join(dir, exe))
+			if err == nil && path != "" {
+				l.path = path
+				return
+			}
+		}
+		l.err = fmt.Errorf("%s not found", exe)
+	})
+	return l.path, l.err
+}
+
+// run runs renderer (see runRenderer), calling Args hook and printing
+// arguments to ArgsLog before.
+func (c *Clipper) run(ctx context.Context, bin string, args []string, stdin io.Reader, stdout io.Writer) error {
+	if c.opts.ArgsLog != nil {
+		fmt.Fprintln(c.opts.ArgsLog, filepath.Base(bin), "args:", strings.Join(args, " "))
+	}
+	if c.opts.Hooks.Args != nil {
+		c.opts.Hooks.Args(ctx, bin, args)
+	}
+	return runRenderer(ctx, bin, args, stdin, stdout, c.opts.RenderTimeout)
+}
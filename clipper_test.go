@@ -0,0 +1,107 @@
+package clip
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	neturl "net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/PuerkitoBio/goquery"
+)
+
+func TestClipper_getHTML(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("content-type", "text/html")
+		_, _ = w.Write([]byte("<html><body><p>text</p></body></html>"))
+	}))
+	defer srv.Close()
+	dir, err := ioutil.TempDir("", "clip-dump-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = os.RemoveAll(dir) }()
+
+	errHook := errors.New("hook error")
+	var fail bool
+	c := NewClipper(ClipperOptions{
+		DumpDir: dir,
+		Hooks: Hooks{
+			Document: func(ctx context.Context, doc *goquery.Document) error {
+				if fail {
+					return errHook
+				}
+				doc.Find("p").SetText("hooked")
+				return nil
+			},
+		},
+	})
+	u, _ := neturl.Parse(srv.URL + "/page")
+	txt, _, err := c.getHTML(context.Background(), u, &Params{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(txt, "<p>hooked</p>") {
+		t.Errorf("getHTML() = %s, document hook isn't applied", txt)
+	}
+	b, err := ioutil.ReadFile(filepath.Join(dir, u.Host, "page.html"))
+	if err != nil || string(b) != txt {
+		t.Errorf("dumped html = %q, %v, want %q", b, err, txt)
+	}
+
+	fail = true
+	_, _, err = c.getHTML(context.Background(), u, &Params{})
+	if !errors.Is(err, errHook) {
+		t.Errorf("getHTML() error = %v, want hook error", err)
+	}
+}
+
+func TestClipper_getHTML_cleanAfterTransforms(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("content-type", "text/html")
+		_, _ = w.Write([]byte("<html><body><p>text</p></body></html>"))
+	}))
+	defer srv.Close()
+	c := NewClipper(ClipperOptions{Transformers: map[string]Transformer{
+		"embed": func(_ context.Context, doc *goquery.Document, _ *Params) error {
+			doc.Find("body").AppendHtml(`<div class="cookie-banner">accept</div>`)
+			return nil
+		},
+	}})
+	u, _ := neturl.Parse(srv.URL)
+	transforms, noClean := []string{"embed"}, false
+	for _, tt := range []struct {
+		p    *Params
+		want bool
+	}{
+		{&Params{Transforms: &transforms}, false},
+		{&Params{Transforms: &transforms, Clean: &noClean}, true},
+	} {
+		txt, _, err := c.getHTML(context.Background(), u, tt.p)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := strings.Contains(txt, "cookie-banner"); got != tt.want {
+			t.Errorf("getHTML(clean: %v) has transformer output = %v, want %v", tt.p.Clean == nil, got, tt.want)
+		}
+	}
+}
+
+func TestNewClipper(t *testing.T) {
+	if c := NewClipper(ClipperOptions{}); c.opts.RenderTimeout != DefaultRenderTimeout {
+		t.Errorf("RenderTimeout = %v, want default", c.opts.RenderTimeout)
+	}
+	if c := NewClipper(ClipperOptions{RenderTimeout: -1}); c.opts.RenderTimeout != 0 {
+		t.Errorf("RenderTimeout = %v, want no limit", c.opts.RenderTimeout)
+	}
+	l := Limits{MaxResourcesSize: 42}
+	if c := NewClipper(ClipperOptions{Limits: &l}); c.inline.MaxTotalSize != 42 ||
+		c.inline.Concurrency != DefaultInlineOptions.Concurrency {
+		t.Errorf("inline options = %+v", c.inline)
+	}
+}
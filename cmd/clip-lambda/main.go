@@ -1,21 +1,24 @@
 package main
 
 import (
-	"bytes"
 	"context"
 	"encoding/base64"
 	"errors"
 	"fmt"
+	"io"
 	"log"
 	"net/http"
 	"net/url"
 	"os"
 	"path/filepath"
 	"strings"
+	"sync"
 
 	"github.com/aws/aws-lambda-go/events"
 	"github.com/aws/aws-lambda-go/lambda"
 
+	"github.com/dinalt/clip"
+	"github.com/dinalt/clip/config"
 	"github.com/dinalt/clip/handler"
 	"github.com/dinalt/clip/presets"
 )
@@ -34,48 +37,117 @@ func HandleRequest(ctx context.Context, req events.APIGatewayProxyRequest) (res
 			IsBase64Encoded:   status == http.StatusOK,
 		}
 	}()
-	lroot := os.Getenv("LAMBDA_TASK_ROOT")
-	err = os.Setenv("WKHTMLTOPDF_PATH", lroot)
-	if err != nil {
-		err = fmt.Errorf("os.Setenv: %w", err)
+	setup.Do(func() {
+		setup.h, setup.err = newHandler()
+	})
+	if setup.err != nil {
+		err = setup.err
 		return
 	}
-	ps, err := presets.FromJSONFile(filepath.Join(lroot, "presets.json"))
-	if err != nil {
-		errLogger.Printf("presets.FromJSONFile: %s", err.Error())
-		if !errors.Is(err, os.ErrNotExist) {
-			return
-		}
-	}
 	r, err := makeReq(ctx, &req)
 	if err != nil {
 		err = fmt.Errorf("makeReq: %w", err)
+		return
 	}
-	poolC := make(chan struct{}, 1)
-	poolC <- struct{}{}
-	h := handler.New(handler.Params{
-		PoolC:   poolC,
-		Logger:  logger{},
-		Presets: ps,
-	})
 
 	w := responseWriter{}
-	h.ServeHTTP(&w, r)
+	setup.h.ServeHTTP(&w, r)
 
 	status = w.code
 	headers = w.header
 
+	if w.err != nil {
+		// response is incomplete, don't return partially encoded body
+		status = http.StatusInternalServerError
+		headers = nil
+		err = w.err
+		return
+	}
 	if status != 0 {
-		body = w.String()
+		body = w.body.String()
 		return
 	}
 
 	status = http.StatusOK
-	body = base64.StdEncoding.EncodeToString(w.Bytes())
+	if w.enc != nil {
+		err = w.enc.Close()
+		if err != nil {
+			status = http.StatusInternalServerError
+			return
+		}
+	}
+	body = w.body.String()
 
 	return
 }
 
+// setup is handler shared by invocations, so renderer pool and scheduler
+// are created once per execution environment.
+var setup struct {
+	sync.Once
+	h   http.HandlerFunc
+	err error
+}
+
+// newHandler creates clip handler from config.
+func newHandler() (http.HandlerFunc, error) {
+	lroot := os.Getenv("LAMBDA_TASK_ROOT")
+	err := os.Setenv("WKHTMLTOPDF_PATH", lroot)
+	if err != nil {
+		return nil, fmt.Errorf("os.Setenv: %w", err)
+	}
+	cfg, err := loadConfig(lroot)
+	if err != nil {
+		return nil, fmt.Errorf("loadConfig: %w", err)
+	}
+	ps, err := presets.FromJSONFiles(cfg.Presets...)
+	if err != nil {
+		errLogger.Printf("presets.FromJSONFiles: %s", err.Error())
+		if !errors.Is(err, os.ErrNotExist) {
+			return nil, err
+		}
+	}
+	clipperOpts := cfg.ClipperOptions()
+	if len(cfg.CleanFilters) > 0 {
+		clipperOpts.CleanFilters, err = clip.CleanFiltersFromFiles(cfg.CleanFilters...)
+		if err != nil {
+			return nil, fmt.Errorf("clip.CleanFiltersFromFiles: %w", err)
+		}
+	}
+	pool, err := clip.NewRendererPool(clip.PoolOptions{Size: 1})
+	if err != nil {
+		return nil, fmt.Errorf("clip.NewRendererPool: %w", err)
+	}
+	return handler.New(handler.Params{
+		Clipper:   clip.NewClipper(clipperOpts),
+		Scheduler: handler.NewScheduler(pool, handler.SchedulerOptions{}),
+		Logger:    logger{},
+		Presets:   ps,
+		Defaults:  cfg.Defaults,
+	}), nil
+}
+
+// loadConfig loads config file set by CLIP_CONFIG or config.yaml from
+// task root (if it exists) and applies environment. Presets are read from
+// presets.json in task root, if config doesn't set them.
+func loadConfig(lroot string) (*config.Config, error) {
+	file := os.Getenv(config.EnvFile)
+	if file == "" {
+		file = filepath.Join(lroot, "config.yaml")
+		if _, err := os.Stat(file); err != nil {
+			file = ""
+		}
+	}
+	cfg, err := config.Load(file)
+	if err != nil {
+		return nil, err
+	}
+	if len(cfg.Presets) == 0 {
+		cfg.Presets = []string{filepath.Join(lroot, "presets.json")}
+	}
+	return cfg, nil
+}
+
 func mapCustomStatus(n int) int {
 	if n < 600 {
 		return n
@@ -83,6 +155,9 @@ func mapCustomStatus(n int) int {
 	if n == handler.SNoResult {
 		return http.StatusNoContent
 	}
+	if n == handler.SFetchTimeout {
+		return http.StatusGatewayTimeout
+	}
 	return http.StatusBadRequest
 }
 func main() {
@@ -99,10 +174,29 @@ func (l logger) Error(err error) {
 	errLogger.Println(err.Error())
 }
 
+// responseWriter keeps error response body as is and base64-encodes
+// successful response body on the fly. Aborted response is discarded.
 type responseWriter struct {
-	code int
-	bytes.Buffer
+	code   int
 	header http.Header
+	body   strings.Builder
+	enc    io.WriteCloser
+	err    error // abort reason
+}
+
+// Abort implements handler.Aborter.
+func (w *responseWriter) Abort(err error) {
+	w.err = err
+}
+
+func (w *responseWriter) Write(b []byte) (int, error) {
+	if w.code != 0 {
+		return w.body.Write(b)
+	}
+	if w.enc == nil {
+		w.enc = base64.NewEncoder(base64.StdEncoding, &w.body)
+	}
+	return w.enc.Write(b)
 }
 
 func (w *responseWriter) WriteHeader(n int) {
@@ -2,83 +2,249 @@ package main
 
 import (
 	"context"
+	"crypto/tls"
 	"flag"
+	"fmt"
 	"log"
 	"net/http"
 	"os"
 	"os/signal"
+	"strings"
+	"syscall"
 	"time"
 
+	"github.com/dinalt/clip"
+	"github.com/dinalt/clip/config"
 	"github.com/dinalt/clip/handler"
 	"github.com/dinalt/clip/presets"
 )
 
-const (
-	defaultMaxWorkersCount = 10
-	defaultServeAddr       = ":8080"
-)
-
 var (
-	maxWorkersCountFlag int
-	serveAddrFlag       string
-	presetsPathFlag     string
+	configPathFlag string
+	hashKeyFlag    string
+	printConfig    bool
 )
 
+// flagKeys maps flags to config keys. Flags override config file and
+// environment.
+var flagKeys = config.FlagKeys{
+	"w":                   "renderer.workers",
+	"warm":                "renderer.warm",
+	"max-jobs":            "renderer.max_jobs",
+	"max-worker-memory":   "renderer.max_memory",
+	"render-timeout":      "renderer.timeout",
+	"a":                   "server.addr",
+	"tls-cert":            "server.tls_cert",
+	"tls-key":             "server.tls_key",
+	"tls-client-ca":       "server.tls_client_ca",
+	"http2":               "server.http2",
+	"read-timeout":        "server.read_timeout",
+	"read-header-timeout": "server.read_header_timeout",
+	"write-timeout":       "server.write_timeout",
+	"idle-timeout":        "server.idle_timeout",
+	"shutdown-timeout":    "server.shutdown_timeout",
+	"keys":                "server.keys",
+	"max-queue":           "server.max_queue",
+	"retry-after":         "server.retry_after",
+	"priorities":          "server.priorities",
+	"preview-size":        "server.preview_size",
+	"preview-dpi":         "server.preview_dpi",
+	"preview-max-size":    "server.preview_max_size",
+	"preview-max-dpi":     "server.preview_max_dpi",
+	"max-body-size":       "server.max_body_size",
+	"p":                   "presets",
+	"max-html-size":       "limits.max_html_size",
+	"max-resources-size":  "limits.max_resources_size",
+	"connect-timeout":     "limits.connect_timeout",
+	"header-timeout":      "limits.header_timeout",
+	"fetch-timeout":       "limits.fetch_timeout",
+	"max-redirects":       "limits.max_redirects",
+	"content-types":       "limits.content_types",
+	"log-level":           "log.level",
+}
+
 func init() {
-	flag.IntVar(&maxWorkersCountFlag, "w", defaultMaxWorkersCount, "maximum workers count")
-	flag.StringVar(&serveAddrFlag, "a", defaultServeAddr, "serve host:port")
-	flag.StringVar(&presetsPathFlag, "p", "", "presets json file")
+	d := config.Default()
+	flag.StringVar(&configPathFlag, "config", os.Getenv(config.EnvFile),
+		"config file, YAML or JSON (env "+config.EnvFile+")")
+	flag.BoolVar(&printConfig, "print-config", false, "print effective config and exit")
+	flag.StringVar(&hashKeyFlag, "hash-key", "", "print hash of API key for keys file and exit")
+
+	flag.Int("w", d.Renderer.Workers, "maximum workers count")
+	flag.String("a", d.Server.Addr, "serve host:port, unix:/path/to.sock or systemd (socket activation)")
+	flag.String("tls-cert", "", "TLS certificate file (reloaded on change)")
+	flag.String("tls-key", "", "TLS key file (reloaded on change)")
+	flag.String("tls-client-ca", "", "CA certificates file to verify client certificates (mTLS)")
+	flag.Bool("http2", d.Server.HTTP2, "enable HTTP/2 for TLS connections")
+	flag.Duration("read-timeout", time.Duration(d.Server.ReadTimeout), "server read timeout")
+	flag.Duration("read-header-timeout", 0, "server read header timeout (read-timeout if zero)")
+	flag.Duration("write-timeout", time.Duration(d.Server.WriteTimeout),
+		"server write timeout (should exceed fetch and render timeouts)")
+	flag.Duration("idle-timeout", time.Duration(d.Server.IdleTimeout), "server keep-alive idle timeout")
+	flag.Duration("shutdown-timeout", time.Duration(d.Server.ShutdownTimeout), "graceful shutdown timeout")
+	flag.String("p", "", "presets json files, comma separated")
+	flag.String("keys", "", "API keys json file (authentication is disabled if empty)")
+	flag.Bool("warm", false, "keep long-lived wkhtmltopdf processes")
+	flag.Int("max-queue", d.Server.MaxQueue, "max waiting requests (-1 - no limit)")
+	flag.Duration("retry-after", time.Duration(d.Server.RetryAfter), "Retry-After for rejected requests")
+	flag.String("priorities", "",
+		"client priorities, comma separated client=class pairs (client is key name if -keys is set, IP otherwise; class is low, normal or high)")
+	flag.Int("max-jobs", d.Renderer.MaxJobs, "jobs rendered by warm process before restart (0 - no limit)")
+	flag.Int64("max-worker-memory", d.Renderer.MaxMemory, "warm process memory limit, bytes (0 - no limit)")
+	flag.Uint("preview-size", d.Server.PreviewSize, "default max preview width, px")
+	flag.Uint("preview-dpi", d.Server.PreviewDPI, "default preview resolution, dpi")
+	flag.Uint("preview-max-size", d.Server.PreviewMaxSize, "max preview width allowed in requests, px")
+	flag.Uint("preview-max-dpi", d.Server.PreviewMaxDPI, "max preview resolution allowed in requests, dpi")
+	flag.Int64("max-body-size", d.Server.MaxBodySize, "max request body size, bytes (-1 - no limit)")
+	flag.String("log-level", d.Log.Level, "log level: info or error")
+
+	l := d.Limits
+	flag.Int64("max-html-size", l.MaxHTMLSize, "max page size, bytes (0 - no limit)")
+	flag.Int64("max-resources-size", l.MaxResourcesSize, "max total size of inlined resources, bytes (0 - no limit)")
+	flag.Duration("connect-timeout", time.Duration(l.ConnectTimeout), "page connect timeout")
+	flag.Duration("header-timeout", time.Duration(l.HeaderTimeout), "page response headers timeout")
+	flag.Duration("fetch-timeout", time.Duration(l.FetchTimeout), "total page fetch timeout")
+	flag.Int("max-redirects", l.MaxRedirects, "max redirects to follow (-1 - disable redirects)")
+	flag.Duration("render-timeout", time.Duration(d.Renderer.Timeout), "renderer run time limit (0 - no limit)")
+	flag.String("content-types", strings.Join(l.ContentTypes, ","),
+		"allowed page content types, comma separated (empty - any)")
 }
 
 func main() {
 	flag.Parse()
-	poolC := make(chan struct{}, maxWorkersCountFlag)
-	for i := 0; i < maxWorkersCountFlag; i++ {
-		poolC <- struct{}{}
+	if hashKeyFlag != "" {
+		fmt.Println(handler.HashKey(hashKeyFlag))
+		return
+	}
+	cfg, err := config.Load(configPathFlag)
+	if err != nil {
+		log.Fatalf("config.Load: %v", err)
+	}
+	err = cfg.ApplyFlags(flag.CommandLine, flagKeys)
+	if err != nil {
+		log.Fatalf("config.ApplyFlags: %v", err)
+	}
+	if printConfig {
+		err = cfg.Print(os.Stdout)
+		if err != nil {
+			log.Fatalf("config.Print: %v", err)
+		}
+		return
+	}
+	srvCfg := cfg.Server
+	lg := logger{quiet: cfg.Log.Level == "error"}
+
+	pool, err := clip.NewRendererPool(cfg.PoolOptions())
+	if err != nil {
+		log.Fatalf("clip.NewRendererPool: %v", err)
 	}
 
-	var (
-		ps  presets.Presets
-		err error
-	)
-	if presetsPathFlag != "" {
-		ps, err = presets.FromJSONFile(presetsPathFlag)
+	priorities, err := parsePriorities(srvCfg.Priorities)
+	if err != nil {
+		log.Fatalf("parsePriorities: %v", err)
+	}
+	sched := handler.NewScheduler(pool, handler.SchedulerOptions{
+		MaxQueue:   srvCfg.MaxQueue,
+		RetryAfter: time.Duration(srvCfg.RetryAfter),
+		Priorities: priorities,
+	})
+
+	var ps presets.Presets
+	if len(cfg.Presets) > 0 {
+		ps, err = presets.FromJSONFiles(cfg.Presets...)
+		if err != nil {
+			log.Fatalf("presets.FromJSONFiles: %v", err)
+		}
+	}
+	clipperOpts := cfg.ClipperOptions()
+	if len(cfg.CleanFilters) > 0 {
+		clipperOpts.CleanFilters, err = clip.CleanFiltersFromFiles(cfg.CleanFilters...)
 		if err != nil {
-			log.Fatalf("presets.FromJSONFile: %v", err)
+			log.Fatalf("clip.CleanFiltersFromFiles: %v", err)
 		}
 	}
+	var keys *handler.Keys
+	if srvCfg.Keys != "" {
+		keys, err = handler.KeysFromJSONFile(srvCfg.Keys)
+		if err != nil {
+			log.Fatalf("handler.KeysFromJSONFile: %v", err)
+		}
+	}
+	hp := handler.Params{
+		Clipper:        clip.NewClipper(clipperOpts),
+		Keys:           keys,
+		Scheduler:      sched,
+		Logger:         lg,
+		Presets:        ps,
+		Defaults:       cfg.Defaults,
+		PreviewSize:    srvCfg.PreviewSize,
+		PreviewDPI:     srvCfg.PreviewDPI,
+		PreviewMaxSize: srvCfg.PreviewMaxSize,
+		PreviewMaxDPI:  srvCfg.PreviewMaxDPI,
+		MaxBodySize:    srvCfg.MaxBodySize,
+	}
 	mux := http.NewServeMux()
-	mux.HandleFunc("/v0/clip", handler.New(handler.Params{
-		PoolC:   poolC,
-		Logger:  logger{},
-		Presets: ps,
-	}))
+	mux.HandleFunc("/v0/clip", handler.New(hp))
+	mux.HandleFunc("/v0/preview", handler.NewPreview(hp))
+	mux.HandleFunc("/v0/stats", handler.NewStats(sched))
+	if keys != nil {
+		mux.HandleFunc("/v0/usage", handler.NewUsage(keys, lg))
+	}
 
 	srv := http.Server{
-		Addr:         serveAddrFlag,
-		ReadTimeout:  5 * time.Second,
-		WriteTimeout: time.Minute,
-		IdleTimeout:  5 * time.Second,
-		Handler:      mux,
+		Addr:              srvCfg.Addr,
+		ReadTimeout:       time.Duration(srvCfg.ReadTimeout),
+		ReadHeaderTimeout: time.Duration(srvCfg.ReadHeaderTimeout),
+		WriteTimeout:      time.Duration(srvCfg.WriteTimeout),
+		IdleTimeout:       time.Duration(srvCfg.IdleTimeout),
+		Handler:           mux,
+	}
+	if !srvCfg.HTTP2 {
+		srv.TLSNextProto = make(map[string]func(*http.Server, *tls.Conn, http.Handler))
+	}
+	stopC := make(chan struct{})
+	defer close(stopC)
+	if srvCfg.TLSCert != "" || srvCfg.TLSKey != "" {
+		certs, err := newCertReloader(srvCfg.TLSCert, srvCfg.TLSKey)
+		if err != nil {
+			log.Fatalf("newCertReloader: %v", err)
+		}
+		go certs.watch(stopC)
+		srv.TLSConfig, err = tlsConfig(certs, srvCfg.TLSClientCA)
+		if err != nil {
+			log.Fatalf("tlsConfig: %v", err)
+		}
 	}
 
+	ln, err := listen(srvCfg.Addr)
+	if err != nil {
+		log.Fatalf("listen: %v", err)
+	}
 	srvErrC := make(chan error, 1)
 	go func() {
-		srvErrC <- srv.ListenAndServe()
+		if srv.TLSConfig != nil {
+			srvErrC <- srv.ServeTLS(ln, "", "")
+			return
+		}
+		srvErrC <- srv.Serve(ln)
 	}()
 
-	log.Println("listen on", srv.Addr)
+	log.Println("listen on", ln.Addr(), "tls:", srv.TLSConfig != nil)
 
 	sigC := make(chan os.Signal, 1)
-	signal.Notify(sigC, os.Interrupt)
+	signal.Notify(sigC, os.Interrupt, syscall.SIGTERM)
 
 	ctx := context.Background()
 	select {
 	case <-sigC:
 		log.Println("shutting down gracefully")
-		ctx, cancel := context.WithTimeout(ctx, 30*time.Second)
+		ctx, cancel := context.WithTimeout(ctx, time.Duration(srvCfg.ShutdownTimeout))
 		err = srv.Shutdown(ctx)
 		cancel()
+		if err == nil {
+			sched.Close()
+			err = pool.Close() // all requests are done, stop warm processes
+		}
 	case err = <-srvErrC:
 	}
 
@@ -87,12 +253,29 @@ func main() {
 	}
 }
 
-type logger struct{}
+// parsePriorities parses client to priority class map.
+func parsePriorities(m map[string]string) (map[string]handler.Priority, error) {
+	res := make(map[string]handler.Priority, len(m))
+	for client, class := range m {
+		p, err := handler.ParsePriority(class)
+		if err != nil {
+			return nil, fmt.Errorf("client %s: %w", client, err)
+		}
+		res[client] = p
+	}
+	return res, nil
+}
+
+type logger struct {
+	quiet bool // log errors only
+}
 
 func (l logger) Error(err error) {
 	log.Printf("ERROR: %v", err)
 }
 
 func (l logger) Printf(format string, v ...interface{}) {
-	log.Printf(format, v...)
+	if !l.quiet {
+		log.Printf(format, v...)
+	}
 }
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"sync"
+	"time"
+)
+
+// certReloadInterval is how often certificate files are checked for changes.
+const certReloadInterval = 10 * time.Second
+
+// certReloader keeps TLS certificate loaded from files and reloads it when
+// files change.
+type certReloader struct {
+	certFile, keyFile string
+
+	mu      sync.RWMutex
+	cert    *tls.Certificate
+	modTime time.Time
+}
+
+func newCertReloader(certFile, keyFile string) (*certReloader, error) {
+	r := &certReloader{certFile: certFile, keyFile: keyFile}
+	_, err := r.reload()
+	if err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// reload loads certificate if files were changed since last load.
+func (r *certReloader) reload() (bool, error) {
+	mt, err := latestModTime(r.certFile, r.keyFile)
+	if err != nil {
+		return false, err
+	}
+	r.mu.RLock()
+	same := r.cert != nil && mt.Equal(r.modTime)
+	r.mu.RUnlock()
+	if same {
+		return false, nil
+	}
+	cert, err := tls.LoadX509KeyPair(r.certFile, r.keyFile)
+	if err != nil {
+		return false, fmt.Errorf("tls.LoadX509KeyPair: %w", err)
+	}
+	r.mu.Lock()
+	r.cert, r.modTime = &cert, mt
+	r.mu.Unlock()
+	return true, nil
+}
+
+// watch reloads certificate periodically until stopC is closed. Failed
+// reload keeps previous certificate.
+func (r *certReloader) watch(stopC <-chan struct{}) {
+	t := time.NewTicker(certReloadInterval)
+	defer t.Stop()
+	for {
+		select {
+		case <-stopC:
+			return
+		case <-t.C:
+		}
+		ok, err := r.reload()
+		switch {
+		case err != nil:
+			log.Printf("ERROR: certificate reload: %v", err)
+		case ok:
+			log.Println("certificate reloaded")
+		}
+	}
+}
+
+func (r *certReloader) getCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.cert, nil
+}
+
+func latestModTime(files ...string) (time.Time, error) {
+	var res time.Time
+	for _, f := range files {
+		fi, err := os.Stat(f)
+		if err != nil {
+			return res, fmt.Errorf("os.Stat: %w", err)
+		}
+		if fi.ModTime().After(res) {
+			res = fi.ModTime()
+		}
+	}
+	return res, nil
+}
+
+// tlsConfig returns server TLS config with reloaded certificate and
+// optional client certificate verification by CAs from clientCAFile.
+func tlsConfig(r *certReloader, clientCAFile string) (*tls.Config, error) {
+	cfg := &tls.Config{
+		MinVersion:     tls.VersionTLS12,
+		GetCertificate: r.getCertificate,
+	}
+	if clientCAFile == "" {
+		return cfg, nil
+	}
+	b, err := ioutil.ReadFile(clientCAFile) // nolint:gosec
+	if err != nil {
+		return nil, fmt.Errorf("ioutil.ReadFile: %w", err)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(b) {
+		return nil, errors.New("no certificates in client CA file")
+	}
+	cfg.ClientCAs = pool
+	cfg.ClientAuth = tls.RequireAndVerifyClientCert
+	return cfg, nil
+}
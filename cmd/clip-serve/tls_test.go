@@ -0,0 +1,101 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// writeCert writes self-signed certificate with common name cn.
+func writeCert(t *testing.T, certFile, keyFile, cn string) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: cn},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	kb, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = ioutil.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600)
+	if err == nil {
+		err = ioutil.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: kb}), 0600)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func Test_certReloader(t *testing.T) {
+	dir := t.TempDir()
+	certFile, keyFile := filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+	writeCert(t, certFile, keyFile, "first")
+	r, err := newCertReloader(certFile, keyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cn := func() string {
+		c, _ := r.getCertificate(nil)
+		x, err := x509.ParseCertificate(c.Certificate[0])
+		if err != nil {
+			t.Fatal(err)
+		}
+		return x.Subject.CommonName
+	}
+	if ok, err := r.reload(); ok || err != nil {
+		t.Errorf("reload() of unchanged files = %v, %v", ok, err)
+	}
+
+	writeCert(t, certFile, keyFile, "second")
+	future := time.Now().Add(time.Minute)
+	_ = os.Chtimes(certFile, future, future)
+	if ok, err := r.reload(); !ok || err != nil || cn() != "second" {
+		t.Errorf("reload() of changed files = %v, %v, cn %s", ok, err, cn())
+	}
+
+	_ = ioutil.WriteFile(keyFile, []byte("broken"), 0600)
+	_ = os.Chtimes(keyFile, future.Add(time.Minute), future.Add(time.Minute))
+	if _, err := r.reload(); err == nil || cn() != "second" {
+		t.Errorf("reload() of broken key = %v, cn %s", err, cn())
+	}
+}
+
+func Test_listen(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "clip.sock")
+	for i := 0; i < 2; i++ { // second listen replaces stale socket
+		l, err := listen("unix:" + path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if l.Addr().Network() != "unix" {
+			t.Errorf("network = %s", l.Addr().Network())
+		}
+		if i == 0 {
+			l.(*net.UnixListener).SetUnlinkOnClose(false)
+		}
+		_ = l.Close()
+	}
+	if _, err := listen("systemd"); err == nil {
+		t.Error("listen(systemd) without passed sockets should fail")
+	}
+}
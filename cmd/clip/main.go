@@ -1,6 +1,8 @@
 package main
 
 import (
+	"context"
+	"encoding/json"
 	"errors"
 	"flag"
 	"fmt"
@@ -8,26 +10,42 @@ import (
 	"os"
 	"path/filepath"
 	"reflect"
+	"strconv"
 	"strings"
 
 	"github.com/dinalt/clip"
+	"github.com/dinalt/clip/config"
 	"github.com/dinalt/clip/presets"
 )
 
 var (
-	presetsFlag, presetsPathFlag string
-	overwriteFlag, helpFlag      bool
+	presetsFlag, configPathFlag string
+	overwriteFlag, helpFlag     bool
 )
 
+// flagKeys maps flags to config keys. Flags override config file and
+// environment.
+var flagKeys = config.FlagKeys{
+	"presets-path": "presets",
+}
+
+// paramFlags maps flags to clip.Params field indexes.
+var paramFlags = map[string]int{}
+
 func init() {
 	flag.StringVar(&presetsFlag, "p", "", "list of used presets (see -presets-path)")
 	dir, err := os.UserConfigDir()
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "unable to locate user config dir: %s\n", err.Error())
 	}
-	presetsFile := filepath.Join(dir, "clip", "presets.json")
-	flag.StringVar(&presetsPathFlag, "presets-path", presetsFile,
-		"path to presets file")
+	configFile := os.Getenv(config.EnvFile)
+	if configFile == "" {
+		configFile = filepath.Join(dir, "clip", "config.yaml")
+	}
+	flag.StringVar(&configPathFlag, "config", configFile,
+		"config file, YAML or JSON (env "+config.EnvFile+", ignored if doesn't exist)")
+	flag.String("presets-path", filepath.Join(dir, "clip", "presets.json"),
+		"path to presets files, comma separated (overrides config presets)")
 	flag.BoolVar(&overwriteFlag, "o", false, "overwrite output file if exists")
 	flag.BoolVar(&helpFlag, "h", false, "print this help message")
 	flag.BoolVar(&helpFlag, "help", false, "print this help message")
@@ -40,6 +58,7 @@ func init() {
 		param := strings.TrimSuffix(
 			strings.ReplaceAll(fld.Tag.Get("json"), "_", "-"),
 			",omitempty")
+		paramFlags[param] = i
 		kind := fld.Type.Elem().Kind()
 		switch kind {
 		case reflect.String:
@@ -47,9 +66,15 @@ func init() {
 		case reflect.Uint:
 			flag.Uint(param, 0, desc)
 		case reflect.Bool:
-			flag.Bool(param, false, desc)
+			def, _ := strconv.ParseBool(fld.Tag.Get("default"))
+			flag.Bool(param, def, desc)
 		case reflect.Float64:
 			flag.Float64(param, 0, desc)
+		case reflect.Slice:
+			if fld.Type.Elem().Elem().Kind() == reflect.String {
+				desc += ", comma separated"
+			}
+			flag.String(param, "", desc)
 		default:
 			panic("unsupported param type: " + kind.String())
 		}
@@ -68,18 +93,49 @@ func main() {
 		printHelp()
 		return
 	}
+	cfg, err := loadConfig()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "unable to load config: %s\n", err)
+		exitCode = 9
+		return
+	}
+	if flag.NArg() == 2 && flag.Arg(0) == "config" && flag.Arg(1) == "print" {
+		err = cfg.Print(os.Stdout)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "unable to print config: %s\n", err)
+			exitCode = 9
+		}
+		return
+	}
 	params := &clip.Params{}
 	val := reflect.ValueOf(params)
 	flag.Visit(func(f *flag.Flag) {
+		i, ok := paramFlags[f.Name]
+		if !ok {
+			return
+		}
+		fld := val.Elem().Field(i)
 		nv := reflect.ValueOf(f.Value.(flag.Getter).Get())
+		if typ := fld.Type().Elem(); typ.Kind() == reflect.Slice {
+			if typ.Elem().Kind() != reflect.String {
+				ptr := reflect.New(typ)
+				if err := json.Unmarshal([]byte(nv.String()), ptr.Interface()); err != nil {
+					fmt.Fprintf(os.Stderr, "invalid -%s value: %s\n", f.Name, err)
+					exitCode = 2
+					return
+				}
+				nv = ptr.Elem()
+			} else {
+				nv = reflect.ValueOf(splitList(nv.String()))
+			}
+		}
 		ptrNV := reflect.New(nv.Type())
 		ptrNV.Elem().Set(nv)
-		fld := val.Elem().FieldByName(toCamel(f.Name))
-		if !fld.IsValid() {
-			return
-		}
 		fld.Set(ptrNV)
 	})
+	if exitCode != 0 {
+		return
+	}
 
 	if flag.NArg() != 2 {
 		fmt.Fprintln(os.Stderr,
@@ -91,7 +147,7 @@ func main() {
 	out := flag.Arg(1)
 
 	if presetsFlag != "" {
-		ps, err := presets.FromJSONFile(presetsPathFlag)
+		ps, err := presets.FromJSONFiles(cfg.Presets...)
 		if err != nil {
 			fmt.Fprintf(os.Stderr, "unable to parse presets: %s\n", errors.Unwrap(err).Error())
 			exitCode = 1
@@ -118,7 +174,10 @@ func main() {
 		}
 	}
 
-	var err error
+	if cfg.Defaults != nil {
+		params.AddFrom(cfg.Defaults)
+	}
+
 	var outF io.Writer
 	switch {
 	case out == "-":
@@ -151,19 +210,68 @@ func main() {
 			}
 		}()
 	}
-	err = clip.ToPDF(url, outF, params)
+	clipperOpts := cfg.ClipperOptions()
+	if len(cfg.CleanFilters) > 0 {
+		clipperOpts.CleanFilters, err = clip.CleanFiltersFromFiles(cfg.CleanFilters...)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "unable to load clean filters: %s\n", err)
+			exitCode = 9
+			return
+		}
+	}
+	clipper := clip.NewClipper(clipperOpts)
+	ctx := clip.WithPresets(context.Background(), strings.Split(presetsFlag, ","))
+	if params.IsImage() {
+		err = clipper.ToImageCtx(ctx, url, outF, params)
+	} else {
+		err = clipper.ToPDFCtx(ctx, url, outF, params)
+	}
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "clip failed: %s\n", err)
 		exitCode = 8
 	}
 }
 
-func toCamel(v string) string {
-	parts := strings.Split(v, "-")
-	for i := range parts {
-		parts[i] = string(parts[i][0]-32) + parts[i][1:]
+// loadConfig loads config file (if it exists), applies environment and
+// flags. Without config presets are read from default presets file.
+func loadConfig() (*config.Config, error) {
+	file := configPathFlag
+	if _, err := os.Stat(file); errors.Is(err, os.ErrNotExist) && !isFlagSet("config") {
+		file = ""
+	}
+	cfg, err := config.Load(file)
+	if err != nil {
+		return nil, err
+	}
+	err = cfg.ApplyFlags(flag.CommandLine, flagKeys)
+	if err != nil {
+		return nil, err
+	}
+	if len(cfg.Presets) == 0 {
+		cfg.Presets = []string{flag.Lookup("presets-path").DefValue}
+	}
+	return cfg, nil
+}
+
+func isFlagSet(name string) bool {
+	set := false
+	flag.Visit(func(f *flag.Flag) {
+		if f.Name == name {
+			set = true
+		}
+	})
+	return set
+}
+
+// splitList splits comma separated list, skipping empty items.
+func splitList(s string) []string {
+	res := []string{}
+	for _, v := range strings.Split(s, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			res = append(res, v)
+		}
 	}
-	return strings.Join(parts, "")
+	return res
 }
 
 func printHelp() {
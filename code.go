@@ -0,0 +1,183 @@
+package clip
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/PuerkitoBio/goquery"
+	"github.com/alecthomas/chroma"
+	"github.com/alecthomas/chroma/formatters/html"
+	"github.com/alecthomas/chroma/lexers"
+	"github.com/alecthomas/chroma/styles"
+)
+
+// DefaultCodeTheme is code blocks highlighting theme, if Params.CodeTheme
+// isn't set. NoCodeTheme disables highlighting (code is wrapped only).
+const (
+	DefaultCodeTheme = "github"
+	NoCodeTheme      = "none"
+)
+
+// Code blocks metrics used to shrink long lines: monospace glyph width
+// (em), browser default font size of pre (px), and minimal font size
+// code can be shrunk to (px).
+const (
+	codeGlyphWidth   = 0.6
+	codeFontSize     = 13
+	codeMinFontSize  = 9
+	codeWidthReserve = 0.9 // part of page width available for code (block paddings and indents)
+)
+
+// codeLangPrefixes are prefixes of classes, which declare code language.
+var codeLangPrefixes = []string{"language-", "lang-", "brush:", "highlight-", "sourceCode-"}
+
+// notLangClasses are common classes of code blocks, which aren't
+// language names.
+var notLangClasses = map[string]bool{
+	"hljs": true, "highlight": true, "code": true, "prettyprint": true, "sourceCode": true,
+	"line-numbers": true, "notranslate": true, "linenums": true, "wrap": true, "nohighlight": true,
+}
+
+const codeStyle = `<style type="text/css">pre,pre code{white-space:pre-wrap!important;` +
+	`word-wrap:break-word!important;overflow-wrap:break-word!important;overflow:visible!important;` +
+	`max-height:none!important}pre{page-break-inside:auto!important;break-inside:auto!important}</style>`
+
+// isCodeTheme reports if name is NoCodeTheme or known theme name.
+func isCodeTheme(name string) bool {
+	_, ok := styles.Registry[name]
+	return ok || name == NoCodeTheme
+}
+
+// highlightCode highlights pre blocks with language declared by classes
+// (of pre or its code child) or data-lang attribute, using p.CodeTheme.
+// Long lines are shrunk to fit page width (down to codeMinFontSize) and
+// wrapped.
+func highlightCode(_ context.Context, doc *goquery.Document, p *Params) error {
+	theme := DefaultCodeTheme
+	if p.CodeTheme != nil && *p.CodeTheme != "" {
+		theme = *p.CodeTheme
+	}
+	var style *chroma.Style
+	if theme != NoCodeTheme {
+		style = styles.Registry[theme]
+		if style == nil {
+			return &ValidationError{"unknown code theme: " + theme}
+		}
+	}
+	formatter := html.New(html.PreventSurroundingPre(true), html.TabWidth(4))
+	width := float64(p.targetImageWidth())
+	if !p.IsImage() {
+		width /= printPixelRatio
+	}
+	fit := int(width * codeWidthReserve / (codeGlyphWidth * codeFontSize))
+
+	pres := doc.Find("pre")
+	var err error
+	pres.EachWithBreak(func(_ int, pre *goquery.Selection) bool {
+		block := pre
+		if code := pre.ChildrenFiltered("code"); code.Length() == 1 && pre.Children().Length() == 1 {
+			block = code
+		}
+		block.Find("br").ReplaceWithHtml("\n")
+		text := block.Text()
+		if size := shrunkFontSize(text, fit); size < codeFontSize {
+			pre.SetAttr("style", fmt.Sprintf("%sfont-size:%dpx!important;", styleAttrPrefix(pre), size))
+		}
+		if style == nil || block.Children().Length() > 0 {
+			return true // keep markup (line numbers, per-line spans), it would be lost
+		}
+		lexer := codeLexer(pre, block)
+		if lexer == nil {
+			return true
+		}
+		it, lerr := chroma.Coalesce(lexer).Tokenise(nil, text)
+		if lerr != nil {
+			return true // leave block as is
+		}
+		var buf bytes.Buffer
+		if err = formatter.Format(&buf, style, it); err != nil {
+			err = fmt.Errorf("html.Formatter.Format: %w", err)
+			return false
+		}
+		block.SetHtml(buf.String())
+		bg := style.Get(chroma.Background)
+		css := ""
+		if bg.Background.IsSet() {
+			css += "background-color:" + bg.Background.String() + "!important;"
+		}
+		if bg.Colour.IsSet() {
+			css += "color:" + bg.Colour.String() + "!important;"
+		}
+		pre.SetAttr("style", styleAttrPrefix(pre)+css)
+		return true
+	})
+	if err != nil {
+		return err
+	}
+	if pres.Length() > 0 {
+		doc.Find("head").AppendHtml(codeStyle)
+	}
+	return nil
+}
+
+// styleAttrPrefix returns s style attribute terminated with ";" (if it
+// isn't empty), so declarations can be appended to it.
+func styleAttrPrefix(s *goquery.Selection) string {
+	style := strings.TrimSpace(s.AttrOr("style", ""))
+	if style != "" && !strings.HasSuffix(style, ";") {
+		style += ";"
+	}
+	return style
+}
+
+// shrunkFontSize returns font size (px), which makes the longest line of
+// text fit into fit characters of default font size, but not less than
+// codeMinFontSize.
+func shrunkFontSize(text string, fit int) int {
+	longest := 0
+	for _, line := range strings.Split(text, "\n") {
+		if n := len([]rune(strings.ReplaceAll(line, "\t", "    "))); n > longest {
+			longest = n
+		}
+	}
+	if fit <= 0 || longest <= fit {
+		return codeFontSize
+	}
+	size := codeFontSize * fit / longest
+	if size < codeMinFontSize {
+		size = codeMinFontSize
+	}
+	return size
+}
+
+// codeLexer returns lexer of language declared by pre or block classes or
+// data-lang attributes, or nil.
+func codeLexer(pre, block *goquery.Selection) chroma.Lexer {
+	var names []string
+	for _, s := range []*goquery.Selection{block, pre} {
+		if lang := s.AttrOr("data-lang", s.AttrOr("data-language", "")); lang != "" {
+			names = append(names, lang)
+		}
+		classes := strings.Fields(s.AttrOr("class", ""))
+		for _, class := range classes {
+			for _, prefix := range codeLangPrefixes {
+				if strings.HasPrefix(class, prefix) {
+					names = append(names, strings.TrimPrefix(class, prefix))
+				}
+			}
+		}
+		for _, class := range classes {
+			if !notLangClasses[class] {
+				names = append(names, class)
+			}
+		}
+	}
+	for _, name := range names {
+		if lexer := lexers.Get(name); lexer != nil {
+			return lexer
+		}
+	}
+	return nil
+}
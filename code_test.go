@@ -0,0 +1,65 @@
+package clip
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/PuerkitoBio/goquery"
+)
+
+func Test_highlightCode(t *testing.T) {
+	long := strings.Repeat("x", 200)
+	doc, err := goquery.NewDocumentFromReader(strings.NewReader(`<html><head></head><body>
+		<pre><code class="hljs language-go">package main</code></pre>
+		<pre class="python" style="margin:0">import os</pre>
+		<pre><code data-lang="js">var s = "` + long + `"</code></pre>
+		<pre><code class="unknown">plain</code></pre>
+		<pre class="go">a := 1<br>b := 2</pre>
+		<pre class="go"><span class="line">x := 1</span><span class="line">y := 2</span></pre>
+		<pre><div class="gutter">1</div><code class="go">x := 1</code></pre>
+	</body></html>`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = highlightCode(context.Background(), doc, &Params{}); err != nil {
+		t.Fatal(err)
+	}
+	pres := doc.Find("pre")
+	for i, want := range []bool{true, true, true, false, true, false, false} {
+		if got := pres.Eq(i).Find("span[style]").Length() > 0; got != want {
+			t.Errorf("pre #%d highlighted = %v, want %v", i, got, want)
+		}
+	}
+	if got := pres.Eq(0).Text(); got != "package main" {
+		t.Errorf("pre #0 text = %q", got)
+	}
+	if got := pres.Eq(4).Text(); got != "a := 1\nb := 2" {
+		t.Errorf("pre #4 text = %q, want line breaks kept", got)
+	}
+	if pres.Eq(5).Find("span.line").Length() != 2 || pres.Eq(6).Find(".gutter").Length() != 1 {
+		t.Error("pre markup isn't kept")
+	}
+	if style := pres.Eq(1).AttrOr("style", ""); !strings.HasPrefix(style, "margin:0;background-color:") {
+		t.Errorf("pre #1 style = %q", style)
+	}
+	if style := pres.Eq(2).AttrOr("style", ""); !strings.HasPrefix(style, "font-size:9px") {
+		t.Errorf("long lines aren't shrunk, style = %q", style)
+	}
+	if !strings.Contains(doc.Find("head style").Text(), "pre-wrap") {
+		t.Error("wrapping style isn't added")
+	}
+
+	doc, _ = goquery.NewDocumentFromReader(strings.NewReader(`<html><body><pre class="go">x</pre></body></html>`))
+	theme := NoCodeTheme
+	_ = highlightCode(context.Background(), doc, &Params{CodeTheme: &theme})
+	if doc.Find("span").Length() != 0 {
+		t.Error("code is highlighted with none theme")
+	}
+	theme = "nope"
+	var verr *ValidationError
+	if err = (&Params{CodeTheme: &theme}).validate(); !errors.As(err, &verr) {
+		t.Errorf("validate() error = %v, want ValidationError", err)
+	}
+}
@@ -0,0 +1,497 @@
+// Package config is configuration schema shared by clip, clip-serve and
+// clip-lambda. Configuration is read from YAML or JSON file, then
+// overridden by CLIP_* environment variables and then by command line
+// flags.
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+
+	"github.com/dinalt/clip"
+)
+
+// EnvPrefix is prefix of environment variables names.
+const EnvPrefix = "CLIP_"
+
+// EnvFile is environment variable with config file path.
+const EnvFile = EnvPrefix + "CONFIG"
+
+// ErrUnknownKey is returned by Set for unknown config keys.
+var ErrUnknownKey = errors.New("unknown config key")
+
+// Config is configuration of clip binaries.
+type Config struct {
+	Presets      []string     `json:"presets"`            // presets JSON files, later files override earlier ones
+	CleanFilters []string     `json:"clean_filters"`      // clean pass filters lists (EasyList syntax), built-in list if empty
+	Defaults     *clip.Params `json:"defaults,omitempty"` // params used when neither request nor presets set them
+	Limits       Limits       `json:"limits"`
+	Renderer     Renderer     `json:"renderer"`
+	Server       Server       `json:"server"`
+	Log          Log          `json:"log"`
+}
+
+// Limits are page fetch limits (see clip.Limits).
+type Limits struct {
+	MaxHTMLSize      int64    `json:"max_html_size"`
+	MaxResourcesSize int64    `json:"max_resources_size"`
+	ConnectTimeout   Duration `json:"connect_timeout"`
+	HeaderTimeout    Duration `json:"header_timeout"`
+	FetchTimeout     Duration `json:"fetch_timeout"`
+	MaxRedirects     int      `json:"max_redirects"`
+	ContentTypes     []string `json:"content_types"`
+	ScriptSteps      uint64   `json:"script_steps"`      // max preset script execution steps
+	ScriptTimeout    Duration `json:"script_timeout"`    // max preset script run time
+	ScriptMaxString  int      `json:"script_max_string"` // max length of strings set and read by preset script
+	ScriptMaxSize    int64    `json:"script_max_size"`   // max document size grown by preset script, bytes
+}
+
+// Renderer configures renderer executables and workers.
+type Renderer struct {
+	WkhtmltopdfPath   string   `json:"wkhtmltopdf_path"`   // looked up if empty
+	WkhtmltoimagePath string   `json:"wkhtmltoimage_path"` // looked up if empty
+	PdftoppmPath      string   `json:"pdftoppm_path"`      // previews rasterizer, looked up if empty
+	Timeout           Duration `json:"timeout"`            // render time limit, 0 - no limit
+	Workers           int      `json:"workers"`            // max concurrent renders
+	Warm              bool     `json:"warm"`               // keep long-lived wkhtmltopdf processes
+	MaxJobs           int      `json:"max_jobs"`           // jobs per warm process
+	MaxMemory         int64    `json:"max_memory"`         // warm process memory limit, bytes
+}
+
+// Server configures clip-serve.
+type Server struct {
+	Addr              string            `json:"addr"`
+	TLSCert           string            `json:"tls_cert"`
+	TLSKey            string            `json:"tls_key"`
+	TLSClientCA       string            `json:"tls_client_ca"`
+	HTTP2             bool              `json:"http2"`
+	ReadTimeout       Duration          `json:"read_timeout"`
+	ReadHeaderTimeout Duration          `json:"read_header_timeout"`
+	WriteTimeout      Duration          `json:"write_timeout"`
+	IdleTimeout       Duration          `json:"idle_timeout"`
+	ShutdownTimeout   Duration          `json:"shutdown_timeout"`
+	MaxQueue          int               `json:"max_queue"`
+	RetryAfter        Duration          `json:"retry_after"`
+	Priorities        map[string]string `json:"priorities"` // client (API key name or IP) to priority class
+	Keys              string            `json:"keys"`       // API keys JSON file
+	PreviewSize       uint              `json:"preview_size"`
+	PreviewDPI        uint              `json:"preview_dpi"`
+	PreviewMaxSize    uint              `json:"preview_max_size"` // max preview width allowed in requests
+	PreviewMaxDPI     uint              `json:"preview_max_dpi"`  // max preview resolution allowed in requests
+	MaxBodySize       int64             `json:"max_body_size"`    // max request body size, bytes (-1 - no limit)
+}
+
+// Log configures logging.
+type Log struct {
+	Level     string `json:"level"`      // info or error
+	PrintArgs bool   `json:"print_args"` // print renderer arguments
+	SaveHTML  string `json:"save_html"`  // dir to save processed HTML to
+}
+
+// Default returns default configuration. Fetch limits and render timeout
+// defaults are taken from clip package.
+func Default() *Config {
+	l := clip.DefaultLimits
+	return &Config{
+		Limits: Limits{
+			MaxHTMLSize:      l.MaxHTMLSize,
+			MaxResourcesSize: l.MaxResourcesSize,
+			ConnectTimeout:   Duration(l.ConnectTimeout),
+			HeaderTimeout:    Duration(l.HeaderTimeout),
+			FetchTimeout:     Duration(l.Timeout),
+			MaxRedirects:     l.MaxRedirects,
+			ContentTypes:     append([]string(nil), l.ContentTypes...),
+			ScriptSteps:      clip.DefaultScriptOptions.MaxSteps,
+			ScriptTimeout:    Duration(clip.DefaultScriptOptions.Timeout),
+			ScriptMaxString:  clip.DefaultScriptOptions.MaxStringSize,
+			ScriptMaxSize:    clip.DefaultScriptOptions.MaxDocumentSize,
+		},
+		Renderer: Renderer{
+			Timeout:   Duration(clip.DefaultRenderTimeout),
+			Workers:   10,
+			MaxJobs:   100,
+			MaxMemory: 512 << 20,
+		},
+		Server: Server{
+			Addr:            ":8080",
+			HTTP2:           true,
+			ReadTimeout:     Duration(5 * time.Second),
+			WriteTimeout:    Duration(3 * time.Minute),
+			IdleTimeout:     Duration(5 * time.Second),
+			ShutdownTimeout: Duration(30 * time.Second),
+			MaxQueue:        100,
+			RetryAfter:      Duration(5 * time.Second),
+			PreviewSize:     256,
+			PreviewDPI:      clip.DefaultPreviewDPI,
+			PreviewMaxSize:  1024,
+			PreviewMaxDPI:   150,
+			MaxBodySize:     1 << 20,
+		},
+		Log: Log{
+			Level: "info",
+		},
+	}
+}
+
+// Load returns default configuration overridden by file (if not empty)
+// and then by environment variables.
+func Load(file string) (*Config, error) {
+	c := Default()
+	if file != "" {
+		err := c.ReadFile(file)
+		if err != nil {
+			return nil, err
+		}
+	}
+	err := c.ApplyEnv(os.LookupEnv)
+	if err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// ReadFile reads YAML (.yaml, .yml) or JSON file into c.
+func (c *Config) ReadFile(file string) error {
+	b, err := ioutil.ReadFile(file) // nolint:gosec
+	if err != nil {
+		return fmt.Errorf("ioutil.ReadFile: %w", err)
+	}
+	switch strings.ToLower(filepath.Ext(file)) {
+	case ".yaml", ".yml":
+		return c.ReadYAML(bytes.NewReader(b))
+	}
+	return c.ReadJSON(bytes.NewReader(b))
+}
+
+// ReadJSON reads JSON config into c. Unknown keys are rejected.
+func (c *Config) ReadJSON(r io.Reader) error {
+	dec := json.NewDecoder(r)
+	dec.DisallowUnknownFields()
+	err := dec.Decode(c)
+	if err != nil {
+		return fmt.Errorf("json.Decoder.Decode: %w", err)
+	}
+	return nil
+}
+
+// ReadYAML reads YAML config into c. YAML is converted to JSON, so keys
+// are the same in both formats.
+func (c *Config) ReadYAML(r io.Reader) error {
+	var v interface{}
+	err := yaml.NewDecoder(r).Decode(&v)
+	if errors.Is(err, io.EOF) {
+		return nil // empty file
+	}
+	if err != nil {
+		return fmt.Errorf("yaml.Decoder.Decode: %w", err)
+	}
+	b, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Errorf("json.Marshal: %w", err)
+	}
+	return c.ReadJSON(bytes.NewReader(b))
+}
+
+// ApplyEnv overrides c by environment variables. Variable name is
+// EnvPrefix followed by upper cased key with dots replaced by underscores
+// (e.g. CLIP_LIMITS_FETCH_TIMEOUT for limits.fetch_timeout). Legacy
+// CLIP_PRINT_ARGS and CLIP_SAVE_HTML variables are also supported.
+func (c *Config) ApplyEnv(lookup func(string) (string, bool)) error {
+	if v, ok := lookup(EnvPrefix + "PRINT_ARGS"); ok {
+		c.Log.PrintArgs = v != ""
+	}
+	if v, ok := lookup(EnvPrefix + "SAVE_HTML"); ok {
+		c.Log.SaveHTML = v
+	}
+	for _, key := range Keys() {
+		name := EnvPrefix + strings.ToUpper(strings.ReplaceAll(key, ".", "_"))
+		v, ok := lookup(name)
+		if !ok {
+			continue
+		}
+		err := c.Set(key, v)
+		if err != nil {
+			return fmt.Errorf("%s: %w", name, err)
+		}
+	}
+	return nil
+}
+
+// Keys returns all config keys (dot separated JSON names of leaf values).
+func Keys() []string {
+	var res []string
+	var walk func(t reflect.Type, prefix string)
+	walk = func(t reflect.Type, prefix string) {
+		for i := 0; i < t.NumField(); i++ {
+			f := t.Field(i)
+			name := jsonName(f)
+			if name == "" {
+				continue
+			}
+			ft := f.Type
+			if ft.Kind() == reflect.Ptr && ft.Elem().Kind() == reflect.Struct {
+				ft = ft.Elem()
+			}
+			if ft.Kind() == reflect.Struct {
+				walk(ft, prefix+name+".")
+				continue
+			}
+			res = append(res, prefix+name)
+		}
+	}
+	walk(reflect.TypeOf(Config{}), "")
+	return res
+}
+
+// Set sets value of config key from string. Lists are comma separated,
+// maps are comma separated key=value pairs.
+func (c *Config) Set(key, value string) error {
+	v := reflect.ValueOf(c).Elem()
+	for _, name := range strings.Split(key, ".") {
+		if v.Kind() == reflect.Ptr {
+			if v.IsNil() {
+				v.Set(reflect.New(v.Type().Elem()))
+			}
+			v = v.Elem()
+		}
+		if v.Kind() != reflect.Struct {
+			return fmt.Errorf("%w: %s", ErrUnknownKey, key)
+		}
+		f, ok := fieldByJSONName(v, name)
+		if !ok {
+			return fmt.Errorf("%w: %s", ErrUnknownKey, key)
+		}
+		v = f
+	}
+	err := setValue(v, value)
+	if err != nil {
+		return fmt.Errorf("%s: %w", key, err)
+	}
+	return nil
+}
+
+func setValue(v reflect.Value, s string) error {
+	if v.Kind() == reflect.Ptr {
+		nv := reflect.New(v.Type().Elem())
+		err := setValue(nv.Elem(), s)
+		if err != nil {
+			return err
+		}
+		v.Set(nv)
+		return nil
+	}
+	if v.Type() == reflect.TypeOf(Duration(0)) {
+		d, err := time.ParseDuration(s)
+		if err != nil {
+			return err
+		}
+		v.SetInt(int64(d))
+		return nil
+	}
+	switch v.Kind() {
+	case reflect.String:
+		v.SetString(s)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(s)
+		if err != nil {
+			return err
+		}
+		v.SetBool(b)
+	case reflect.Int, reflect.Int64:
+		n, err := strconv.ParseInt(s, 10, 64)
+		if err != nil {
+			return err
+		}
+		v.SetInt(n)
+	case reflect.Uint, reflect.Uint64:
+		n, err := strconv.ParseUint(s, 10, 64)
+		if err != nil {
+			return err
+		}
+		v.SetUint(n)
+	case reflect.Float64:
+		n, err := strconv.ParseFloat(s, 64)
+		if err != nil {
+			return err
+		}
+		v.SetFloat(n)
+	case reflect.Slice:
+		if v.Type().Elem().Kind() != reflect.String {
+			return json.Unmarshal([]byte(s), v.Addr().Interface())
+		}
+		var list []string
+		for _, item := range strings.Split(s, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				list = append(list, item)
+			}
+		}
+		v.Set(reflect.ValueOf(list))
+	case reflect.Map:
+		m := make(map[string]string)
+		for _, pair := range strings.Split(s, ",") {
+			if pair = strings.TrimSpace(pair); pair == "" {
+				continue
+			}
+			i := strings.LastIndex(pair, "=")
+			if i < 1 {
+				return fmt.Errorf("bad key=value pair: %s", pair)
+			}
+			m[pair[:i]] = pair[i+1:]
+		}
+		v.Set(reflect.ValueOf(m))
+	default:
+		return fmt.Errorf("unsupported type: %s", v.Type())
+	}
+	return nil
+}
+
+func jsonName(f reflect.StructField) string {
+	name := strings.Split(f.Tag.Get("json"), ",")[0]
+	if name == "-" {
+		return ""
+	}
+	return name
+}
+
+func fieldByJSONName(v reflect.Value, name string) (reflect.Value, bool) {
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		if jsonName(t.Field(i)) == name {
+			return v.Field(i), true
+		}
+	}
+	return reflect.Value{}, false
+}
+
+// FlagKeys maps flag names to config keys.
+type FlagKeys map[string]string
+
+// ApplyFlags overrides c by values of flags from fs, which were set on
+// command line and present in keys.
+func (c *Config) ApplyFlags(fs *flag.FlagSet, keys FlagKeys) error {
+	var err error
+	fs.Visit(func(f *flag.Flag) {
+		key, ok := keys[f.Name]
+		if !ok || err != nil {
+			return
+		}
+		err = c.Set(key, f.Value.String())
+		if err != nil {
+			err = fmt.Errorf("flag -%s: %w", f.Name, err)
+		}
+	})
+	return err
+}
+
+// ClipperOptions returns options of clip.Clipper.
+func (c *Config) ClipperOptions() clip.ClipperOptions {
+	opts := clip.ClipperOptions{
+		WkhtmltopdfPath:   c.Renderer.WkhtmltopdfPath,
+		WkhtmltoimagePath: c.Renderer.WkhtmltoimagePath,
+		PdftoppmPath:      c.Renderer.PdftoppmPath,
+		Limits: &clip.Limits{
+			MaxHTMLSize:      c.Limits.MaxHTMLSize,
+			MaxResourcesSize: c.Limits.MaxResourcesSize,
+			ConnectTimeout:   time.Duration(c.Limits.ConnectTimeout),
+			HeaderTimeout:    time.Duration(c.Limits.HeaderTimeout),
+			Timeout:          time.Duration(c.Limits.FetchTimeout),
+			MaxRedirects:     c.Limits.MaxRedirects,
+			ContentTypes:     c.Limits.ContentTypes,
+		},
+		Script: &clip.ScriptOptions{
+			MaxSteps:        c.Limits.ScriptSteps,
+			Timeout:         time.Duration(c.Limits.ScriptTimeout),
+			MaxStringSize:   c.Limits.ScriptMaxString,
+			MaxDocumentSize: c.Limits.ScriptMaxSize,
+		},
+		RenderTimeout: time.Duration(c.Renderer.Timeout),
+		DumpDir:       c.Log.SaveHTML,
+	}
+	if opts.RenderTimeout == 0 {
+		opts.RenderTimeout = -1 // no limit
+	}
+	if c.Log.PrintArgs {
+		opts.ArgsLog = os.Stderr
+	}
+	return opts
+}
+
+// PoolOptions returns options of clip.RendererPool.
+func (c *Config) PoolOptions() clip.PoolOptions {
+	return clip.PoolOptions{
+		Bin:       c.Renderer.WkhtmltopdfPath,
+		Size:      c.Renderer.Workers,
+		Warm:      c.Renderer.Warm,
+		MaxJobs:   c.Renderer.MaxJobs,
+		MaxMemory: c.Renderer.MaxMemory,
+	}
+}
+
+// Print writes c to w in YAML.
+func (c *Config) Print(w io.Writer) error {
+	b, err := json.Marshal(c)
+	if err != nil {
+		return fmt.Errorf("json.Marshal: %w", err)
+	}
+	var n yaml.Node
+	err = yaml.Unmarshal(b, &n) // JSON is YAML, node keeps fields order
+	if err != nil {
+		return fmt.Errorf("yaml.Unmarshal: %w", err)
+	}
+	resetStyle(&n)
+	enc := yaml.NewEncoder(w)
+	enc.SetIndent(2)
+	err = enc.Encode(&n)
+	if err != nil {
+		return fmt.Errorf("yaml.Encoder.Encode: %w", err)
+	}
+	return enc.Close()
+}
+
+// resetStyle replaces JSON flow style with block style.
+func resetStyle(n *yaml.Node) {
+	n.Style = 0
+	for _, c := range n.Content {
+		resetStyle(c)
+	}
+}
+
+// Duration is time.Duration encoded as string ("30s").
+type Duration time.Duration
+
+// MarshalJSON implements json.Marshaler.
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+// UnmarshalJSON implements json.Unmarshaler. Numbers are nanoseconds.
+func (d *Duration) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		var n int64
+		if err := json.Unmarshal(b, &n); err != nil {
+			return fmt.Errorf("bad duration: %s", b)
+		}
+		*d = Duration(n)
+		return nil
+	}
+	v, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	*d = Duration(v)
+	return nil
+}
@@ -0,0 +1,152 @@
+package config
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestPrecedence(t *testing.T) {
+	dir, err := ioutil.TempDir("", "clip-config-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = os.RemoveAll(dir) }()
+	file := filepath.Join(dir, "config.yaml")
+	err = ioutil.WriteFile(file, []byte(`
+presets: [a.json, b.json]
+defaults:
+  page_size: A5
+  grayscale: true
+limits:
+  fetch_timeout: 20s
+  max_redirects: 3
+renderer:
+  workers: 4
+server:
+  addr: ":9000"
+  priorities:
+    k1: high
+`), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c := Default()
+	err = c.ReadFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	env := map[string]string{
+		"CLIP_LIMITS_MAX_REDIRECTS": "5",
+		"CLIP_RENDERER_WORKERS":     "6",
+		"CLIP_DEFAULTS_ZOOM":        "1.5",
+	}
+	err = c.ApplyEnv(func(k string) (string, bool) {
+		v, ok := env[k]
+		return v, ok
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	fs.Int("w", 10, "")
+	fs.String("a", ":8080", "")
+	fs.String("priorities", "", "")
+	err = fs.Parse([]string{"-w", "8", "-priorities", "k2=low,k3=normal"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = c.ApplyFlags(fs, FlagKeys{"w": "renderer.workers", "a": "server.addr", "priorities": "server.priorities"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if want := []string{"a.json", "b.json"}; !reflect.DeepEqual(c.Presets, want) {
+		t.Errorf("Presets = %v, want %v", c.Presets, want)
+	}
+	if c.Defaults == nil || c.Defaults.PageSize == nil || *c.Defaults.PageSize != "A5" ||
+		c.Defaults.Grayscale == nil || !*c.Defaults.Grayscale ||
+		c.Defaults.Zoom == nil || *c.Defaults.Zoom != 1.5 {
+		t.Errorf("Defaults = %v", c.Defaults)
+	}
+	if c.Limits.FetchTimeout != Duration(20*time.Second) {
+		t.Errorf("FetchTimeout = %v (file)", c.Limits.FetchTimeout)
+	}
+	if c.Limits.MaxRedirects != 5 {
+		t.Errorf("MaxRedirects = %d, want 5 (env)", c.Limits.MaxRedirects)
+	}
+	if c.Renderer.Workers != 8 {
+		t.Errorf("Workers = %d, want 8 (flag)", c.Renderer.Workers)
+	}
+	if c.Server.Addr != ":9000" {
+		t.Errorf("Addr = %s, want :9000 (unset flag is ignored)", c.Server.Addr)
+	}
+	if want := map[string]string{"k2": "low", "k3": "normal"}; !reflect.DeepEqual(c.Server.Priorities, want) {
+		t.Errorf("Priorities = %v, want %v", c.Server.Priorities, want)
+	}
+	if c.Limits.HeaderTimeout != Default().Limits.HeaderTimeout {
+		t.Errorf("HeaderTimeout = %v, want default", c.Limits.HeaderTimeout)
+	}
+}
+
+func TestReadJSON(t *testing.T) {
+	c := Default()
+	err := c.ReadJSON(strings.NewReader(`{"renderer": {"timeout": "1m", "warm": true}}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.Renderer.Timeout != Duration(time.Minute) || !c.Renderer.Warm {
+		t.Errorf("Renderer = %+v", c.Renderer)
+	}
+	err = c.ReadJSON(strings.NewReader(`{"renderer": {"timeot": "1m"}}`))
+	if err == nil {
+		t.Error("unknown key is accepted")
+	}
+}
+
+func TestSet(t *testing.T) {
+	c := Default()
+	err := c.Set("limits.nope", "1")
+	if !errors.Is(err, ErrUnknownKey) {
+		t.Errorf("Set(unknown) error = %v, want ErrUnknownKey", err)
+	}
+	err = c.Set("limits.fetch_timeout", "soon")
+	if err == nil {
+		t.Error("bad duration is accepted")
+	}
+	err = c.Set("limits.content_types", "text/html, text/plain,")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"text/html", "text/plain"}; !reflect.DeepEqual(c.Limits.ContentTypes, want) {
+		t.Errorf("ContentTypes = %v, want %v", c.Limits.ContentTypes, want)
+	}
+}
+
+func TestPrint(t *testing.T) {
+	c := Default()
+	var buf bytes.Buffer
+	err := c.Print(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(buf.String(), "fetch_timeout: 30s\n") {
+		t.Errorf("unexpected output:\n%s", buf.String())
+	}
+	c2 := &Config{}
+	err = c2.ReadYAML(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(c, c2) {
+		t.Errorf("printed config is read as %+v, want %+v", c2, c)
+	}
+}
@@ -0,0 +1,63 @@
+package clip
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+// ErrRenderTimeout is returned when renderer was killed after render
+// timeout.
+var ErrRenderTimeout = errors.New("render timeout")
+
+// runRenderer runs renderer bin with args, stdin and stdout. Renderer is
+// started in its own process group, which is killed entirely when ctx is
+// done or timeout expires (zero timeout means no limit). Non empty stderr
+// output is returned as error, if renderer fails. Warm process of pool
+// worker from ctx (see WithWorker) is used instead of new one if possible.
+func runRenderer(ctx context.Context, bin string, args []string, stdin io.Reader, stdout io.Writer,
+	timeout time.Duration) error {
+	if w := workerFromContext(ctx); w.canRun(bin, args) {
+		return w.proc.render(ctx, args, stdin, stdout, timeout)
+	}
+	var stderr bytes.Buffer
+	cmd := exec.Command(bin, args...) //nolint:gosec
+	cmd.Stdin = stdin
+	cmd.Stdout = stdout
+	cmd.Stderr = &stderr
+	setProcessGroup(cmd)
+	err := cmd.Start()
+	if err != nil {
+		return fmt.Errorf("exec.Cmd.Start: %w", err)
+	}
+
+	doneC := make(chan error, 1)
+	go func() { doneC <- cmd.Wait() }()
+	var timeoutC <-chan time.Time
+	if timeout > 0 {
+		t := time.NewTimer(timeout)
+		defer t.Stop()
+		timeoutC = t.C
+	}
+
+	select {
+	case err = <-doneC:
+	case <-ctx.Done():
+		killProcessGroup(cmd)
+		<-doneC
+		return fmt.Errorf("context error: %w", ctx.Err())
+	case <-timeoutC:
+		killProcessGroup(cmd)
+		<-doneC
+		return fmt.Errorf("%w: %s killed after %v", ErrRenderTimeout, bin, timeout)
+	}
+	if err != nil && strings.TrimSpace(stderr.String()) != "" {
+		return errors.New(stderr.String())
+	}
+	return err
+}
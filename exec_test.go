@@ -0,0 +1,55 @@
+package clip
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"os/exec"
+	"runtime"
+	"testing"
+	"time"
+)
+
+func Test_runRenderer(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("requires sh")
+	}
+	sh, err := exec.LookPath("sh")
+	if err != nil {
+		t.Skip("sh not found")
+	}
+	// child process keeps stdout open, so renderer can't finish until the
+	// whole group is killed
+	hang := []string{"-c", "sleep 10 & sleep 10"}
+
+	var out bytes.Buffer
+	err = runRenderer(context.Background(), sh, []string{"-c", "printf ok"}, nil, &out, 0)
+	if err != nil || out.String() != "ok" {
+		t.Errorf("runRenderer() = %v, output %q", err, out.String())
+	}
+
+	err = runRenderer(context.Background(), sh, []string{"-c", "echo failed >&2; exit 1"}, nil, &out, 0)
+	if err == nil || err.Error() != "failed\n" {
+		t.Errorf("runRenderer() error = %v, want stderr output", err)
+	}
+
+	start := time.Now()
+	err = runRenderer(context.Background(), sh, hang, nil, &out, 100*time.Millisecond)
+	if !errors.Is(err, ErrRenderTimeout) {
+		t.Errorf("runRenderer() error = %v, want ErrRenderTimeout", err)
+	}
+	if d := time.Since(start); d > 5*time.Second {
+		t.Errorf("runRenderer() returned after %v", d)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	start = time.Now()
+	err = runRenderer(ctx, sh, hang, nil, &out, 0)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("runRenderer() error = %v, want context.DeadlineExceeded", err)
+	}
+	if d := time.Since(start); d > 5*time.Second {
+		t.Errorf("runRenderer() returned after %v", d)
+	}
+}
@@ -5,5 +5,11 @@ go 1.15
 require (
 	github.com/PuerkitoBio/goquery v1.6.0
 	github.com/SebastiaanKlippert/go-wkhtmltopdf v1.6.0
+	github.com/alecthomas/chroma v0.10.0
+	github.com/andybalholm/cascadia v1.1.0
 	github.com/aws/aws-lambda-go v1.20.0
+	go.starlark.net v0.0.0-20211013185944-b0039bd2cfe3
+	golang.org/x/net v0.0.0-20200202094626-16171245cfb2
+	golang.org/x/text v0.3.3 // indirect
+	gopkg.in/yaml.v3 v3.0.1
 )
@@ -0,0 +1,277 @@
+package handler
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	neturl "net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Auth errors.
+var (
+	ErrUnauthorized = errors.New("unauthorized")
+	ErrForbidden    = errors.New("forbidden")
+)
+
+// LimitError is returned when API key exceeds its rate limit, daily quota
+// or concurrency. Handler responds to it with 429 status.
+type LimitError struct {
+	Limit      string        // exceeded limit name
+	RetryAfter time.Duration // zero if unknown
+}
+
+func (e *LimitError) Error() string {
+	return "limit exceeded: " + e.Limit
+}
+
+// APIKey is API key configuration. Key itself isn't stored, only its
+// SHA-256 hash (see HashKey).
+type APIKey struct {
+	Name           string   `json:"name"`
+	Hash           string   `json:"hash"`                      // hex encoded SHA-256 of key
+	Rate           float64  `json:"rate,omitempty"`            // requests per second, 0 - no limit
+	Burst          int      `json:"burst,omitempty"`           // max requests burst (1 if zero)
+	DailyQuota     int      `json:"daily_quota,omitempty"`     // clips per UTC day, 0 - no limit
+	MaxConcurrency int      `json:"max_concurrency,omitempty"` // requests in progress, 0 - no limit
+	Presets        []string `json:"presets,omitempty"`         // allowed presets, empty - any
+	Hosts          []string `json:"hosts,omitempty"`           // allowed hosts (with subdomains), empty - any
+	Priority       string   `json:"priority,omitempty"`        // scheduler priority class (low, normal or high)
+}
+
+// Usage is API key usage.
+type Usage struct {
+	Name       string `json:"name"`
+	Day        string `json:"day"`                   // UTC day of Clips counter
+	Clips      int    `json:"clips"`                 // clips accepted today
+	DailyQuota int    `json:"daily_quota,omitempty"` // 0 - no limit
+	InFlight   int    `json:"in_flight"`
+	Rejected   uint64 `json:"rejected"` // requests rejected by limits since start
+}
+
+// Keys are API keys with their usage state.
+type Keys struct {
+	byHash map[string]*keyState
+}
+
+type keyState struct {
+	APIKey
+	priority Priority
+
+	mu       sync.Mutex
+	tokens   float64 // rate limiter bucket
+	last     time.Time
+	day      string
+	clips    int
+	inFlight int
+	rejected uint64
+}
+
+// HashKey returns hash of API key to be stored in keys file.
+func HashKey(key string) string {
+	h := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(h[:])
+}
+
+// KeysFromJSONFile reads keys from JSON file with array of APIKey objects.
+func KeysFromJSONFile(file string) (*Keys, error) {
+	f, err := os.Open(file) // nolint:gosec
+	if err != nil {
+		return nil, fmt.Errorf("os.Open: %w", err)
+	}
+	defer func() { _ = f.Close() }()
+	return KeysFromJSON(f)
+}
+
+// KeysFromJSON reads keys from JSON array of APIKey objects.
+func KeysFromJSON(r io.Reader) (*Keys, error) {
+	var list []APIKey
+	err := json.NewDecoder(r).Decode(&list)
+	if err != nil {
+		return nil, fmt.Errorf("json.Decoder.Decode: %w", err)
+	}
+	res := &Keys{byHash: make(map[string]*keyState, len(list))}
+	for _, k := range list {
+		h := strings.ToLower(k.Hash)
+		if len(h) != sha256.Size*2 {
+			return nil, fmt.Errorf("key %s: bad hash", k.Name)
+		}
+		if _, ok := res.byHash[h]; ok {
+			return nil, fmt.Errorf("key %s: duplicate hash", k.Name)
+		}
+		ks := &keyState{APIKey: k, priority: PriorityNormal}
+		if k.Priority != "" {
+			ks.priority, err = ParsePriority(k.Priority)
+			if err != nil {
+				return nil, fmt.Errorf("key %s: %w", k.Name, err)
+			}
+		}
+		if ks.Burst < 1 {
+			ks.Burst = 1
+		}
+		ks.tokens = float64(ks.Burst)
+		res.byHash[h] = ks
+	}
+	return res, nil
+}
+
+// requestKey returns API key from Authorization bearer token or
+// X-API-Key header.
+func requestKey(r *http.Request) string {
+	if v := r.Header.Get("authorization"); len(v) > 7 && strings.EqualFold(v[:7], "bearer ") {
+		return strings.TrimSpace(v[7:])
+	}
+	return r.Header.Get("x-api-key")
+}
+
+// authenticate returns state of request API key.
+func (k *Keys) authenticate(r *http.Request) (*keyState, error) {
+	key := requestKey(r)
+	if key == "" {
+		return nil, fmt.Errorf("%w: API key is required", ErrUnauthorized)
+	}
+	ks, ok := k.byHash[HashKey(key)]
+	if !ok {
+		return nil, fmt.Errorf("%w: unknown API key", ErrUnauthorized)
+	}
+	return ks, nil
+}
+
+// authorize checks if key is allowed to clip url with presets.
+func (ks *keyState) authorize(url string, presets []string) error {
+	if len(ks.Presets) > 0 {
+		for _, p := range presets {
+			if p != "" && !contains(ks.Presets, p) {
+				return fmt.Errorf("%w: preset %s is not allowed", ErrForbidden, p)
+			}
+		}
+	}
+	if len(ks.Hosts) > 0 {
+		u, err := neturl.Parse(url)
+		if err != nil {
+			return nil // reported by renderer
+		}
+		host := strings.ToLower(u.Hostname())
+		for _, h := range ks.Hosts {
+			h = strings.ToLower(h)
+			if host == h || strings.HasSuffix(host, "."+h) {
+				return nil
+			}
+		}
+		return fmt.Errorf("%w: host %s is not allowed", ErrForbidden, host)
+	}
+	return nil
+}
+
+// acquire checks rate limit, daily quota and concurrency, and counts
+// request in flight. Quota and rate aren't charged until charge is
+// called, so requests failed before rendering are free. release must be
+// called when request is done.
+func (ks *keyState) acquire(now time.Time) error {
+	ks.mu.Lock()
+	defer ks.mu.Unlock()
+	err := ks.check(now)
+	if err == nil && ks.MaxConcurrency > 0 && ks.inFlight >= ks.MaxConcurrency {
+		err = &LimitError{Limit: "concurrency"}
+	}
+	if err != nil {
+		ks.rejected++
+		return err
+	}
+	ks.inFlight++
+	return nil
+}
+
+// charge checks rate limit and daily quota again (they could be used by
+// concurrent requests after acquire) and charges them.
+func (ks *keyState) charge(now time.Time) error {
+	ks.mu.Lock()
+	defer ks.mu.Unlock()
+	err := ks.check(now)
+	if err != nil {
+		ks.rejected++
+		return err
+	}
+	if ks.Rate > 0 {
+		ks.tokens--
+	}
+	ks.clips++
+	return nil
+}
+
+// check checks rate limit and daily quota.
+func (ks *keyState) check(now time.Time) error {
+	if day := now.UTC().Format("2006-01-02"); day != ks.day {
+		ks.day, ks.clips = day, 0
+	}
+	if ks.DailyQuota > 0 && ks.clips >= ks.DailyQuota {
+		y, m, d := now.UTC().Date()
+		return &LimitError{"daily quota", time.Date(y, m, d+1, 0, 0, 0, 0, time.UTC).Sub(now)}
+	}
+	if ks.Rate > 0 {
+		if !ks.last.IsZero() {
+			ks.tokens = math.Min(float64(ks.Burst), ks.tokens+now.Sub(ks.last).Seconds()*ks.Rate)
+		}
+		ks.last = now
+		if ks.tokens < 1 {
+			return &LimitError{"rate", time.Duration((1 - ks.tokens) / ks.Rate * float64(time.Second))}
+		}
+	}
+	return nil
+}
+
+func (ks *keyState) release() {
+	ks.mu.Lock()
+	ks.inFlight--
+	ks.mu.Unlock()
+}
+
+func (ks *keyState) usage() Usage {
+	ks.mu.Lock()
+	defer ks.mu.Unlock()
+	if day := time.Now().UTC().Format("2006-01-02"); day != ks.day {
+		ks.day, ks.clips = day, 0
+	}
+	return Usage{
+		Name:       ks.Name,
+		Day:        ks.day,
+		Clips:      ks.clips,
+		DailyQuota: ks.DailyQuota,
+		InFlight:   ks.inFlight,
+		Rejected:   ks.rejected,
+	}
+}
+
+// NewUsage returns handler, which responds with usage of request API key
+// in JSON.
+func NewUsage(keys *Keys, log Logger) http.HandlerFunc {
+	if log == nil {
+		log = dummyLogger{}
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		ks, err := keys.authenticate(r)
+		if err != nil {
+			finalize(w, log, err)
+			return
+		}
+		w.Header().Set("content-type", "application/json")
+		_ = json.NewEncoder(w).Encode(ks.usage())
+	}
+}
+
+func contains(list []string, v string) bool {
+	for _, s := range list {
+		if s == v {
+			return true
+		}
+	}
+	return false
+}
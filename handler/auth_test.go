@@ -0,0 +1,138 @@
+package handler
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestKeys(t *testing.T) {
+	keys, err := KeysFromJSON(strings.NewReader(`[
+		{"name": "alice", "hash": "` + HashKey("secret-a") + `", "rate": 1, "burst": 2,
+			"presets": ["provenance"], "hosts": ["example.com"], "priority": "high"},
+		{"name": "bob", "hash": "` + HashKey("secret-b") + `", "daily_quota": 2, "max_concurrency": 1}
+	]`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tt := range []struct {
+		header, value, want string
+	}{
+		{"Authorization", "Bearer secret-a", "alice"},
+		{"X-API-Key", "secret-b", "bob"},
+		{"X-API-Key", "wrong", ""},
+		{"", "", ""},
+	} {
+		r := httptest.NewRequest("GET", "/v0/clip", nil)
+		if tt.header != "" {
+			r.Header.Set(tt.header, tt.value)
+		}
+		ks, err := keys.authenticate(r)
+		switch {
+		case tt.want == "" && !errors.Is(err, ErrUnauthorized):
+			t.Errorf("authenticate(%s: %s) error = %v, want ErrUnauthorized", tt.header, tt.value, err)
+		case tt.want != "" && (err != nil || ks.Name != tt.want):
+			t.Errorf("authenticate(%s: %s) = %v, %v", tt.header, tt.value, ks, err)
+		}
+	}
+
+	r := httptest.NewRequest("GET", "/v0/clip", nil)
+	r.Header.Set("X-API-Key", "secret-a")
+	alice, _ := keys.authenticate(r)
+	if alice.priority != PriorityHigh {
+		t.Errorf("priority = %v", alice.priority)
+	}
+	for _, tt := range []struct {
+		url     string
+		presets []string
+		ok      bool
+	}{
+		{"https://example.com/a", []string{"provenance", ""}, true},
+		{"https://blog.example.com/a", nil, true},
+		{"https://badexample.com/a", nil, false},
+		{"https://example.com/a", []string{"auto"}, false},
+	} {
+		err := alice.authorize(tt.url, tt.presets)
+		if tt.ok != (err == nil) || (err != nil && !errors.Is(err, ErrForbidden)) {
+			t.Errorf("authorize(%s, %v) error = %v", tt.url, tt.presets, err)
+		}
+	}
+
+	// take acquires key and charges it, as request, which got worker
+	take := func(ks *keyState, now time.Time) error {
+		if err := ks.acquire(now); err != nil {
+			return err
+		}
+		if err := ks.charge(now); err != nil {
+			ks.release()
+			return err
+		}
+		return nil
+	}
+
+	// rate: burst of 2, then 1 request per second
+	now := time.Date(2020, 1, 1, 12, 0, 0, 0, time.UTC)
+	for i, want := range []bool{true, true, false} {
+		err := take(alice, now)
+		if want != (err == nil) {
+			t.Errorf("rate limited acquire #%d error = %v", i, err)
+		}
+		if err == nil {
+			alice.release()
+		}
+	}
+	if err := take(alice, now.Add(time.Second)); err != nil {
+		t.Errorf("acquire after refill error = %v", err)
+	}
+
+	r.Header.Set("X-API-Key", "secret-b")
+	bob, _ := keys.authenticate(r)
+	if err := take(bob, now); err != nil {
+		t.Fatal(err)
+	}
+	var limitErr *LimitError
+	if err := take(bob, now); !errors.As(err, &limitErr) || limitErr.Limit != "concurrency" {
+		t.Errorf("concurrent acquire error = %v", err)
+	}
+	bob.release()
+	if err := take(bob, now); err != nil {
+		t.Fatal(err)
+	}
+	bob.release()
+	if err := take(bob, now); !errors.As(err, &limitErr) || limitErr.RetryAfter != 12*time.Hour {
+		t.Errorf("over quota acquire error = %v", err)
+	}
+	if err := take(bob, now.Add(24*time.Hour)); err != nil {
+		t.Errorf("acquire next day error = %v", err)
+	}
+	bob.release()
+	if err := bob.acquire(now.Add(24 * time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	if bob.clips != 1 {
+		t.Errorf("clips = %d, uncharged acquire is counted", bob.clips)
+	}
+	if u := bob.usage(); u.Rejected != 2 || u.InFlight != 1 {
+		t.Errorf("usage = %+v", u)
+	}
+}
+
+func Test_mapError_auth(t *testing.T) {
+	for _, tt := range []struct {
+		err  error
+		want int
+	}{
+		{ErrUnauthorized, http.StatusUnauthorized},
+		{ErrForbidden, http.StatusForbidden},
+		{&LimitError{Limit: "rate"}, http.StatusTooManyRequests},
+		{&QueueFullError{}, http.StatusTooManyRequests},
+	} {
+		if got, _ := mapError(tt.err); got != tt.want {
+			t.Errorf("mapError(%v) = %d, want %d", tt.err, got, tt.want)
+		}
+	}
+}
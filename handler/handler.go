@@ -1,15 +1,17 @@
 package handler
 
 import (
-	"bufio"
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"net/http"
 	"reflect"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/dinalt/clip"
 )
@@ -21,12 +23,18 @@ const (
 	SBadURL
 	SValidationFailed
 	SNoPreset
+	SNonConformant
+	SPageTooLarge
+	SFetchTimeout
+	STooManyRedirects
+	SBadContentType
+	SScriptFailed
 )
 
-const (
-	contentType         = "application/pdf"
-	fallbackContentType = "application/octet-stream"
-)
+const fallbackContentType = "application/octet-stream"
+
+// DefaultMaxBodySize limits request body, if Params.MaxBodySize is zero.
+const DefaultMaxBodySize = 1 << 20
 
 type Presets interface {
 	ByName(string) *clip.Params
@@ -34,15 +42,32 @@ type Presets interface {
 }
 
 type Params struct {
-	PoolC  chan struct{}
-	Logger Logger
+	Clipper   *clip.Clipper // clipper with default options if nil
+	Scheduler *Scheduler
+	Keys      *Keys // API keys, nil disables authentication
+	Logger    Logger
 	Presets
+	Defaults *clip.Params // used for params set neither by request nor by presets
+
+	MaxBodySize int64 // max request body size, bytes (DefaultMaxBodySize if zero, negative - no limit)
+
+	PreviewSize    uint // default max preview width, px (used by NewPreview)
+	PreviewDPI     uint // default preview resolution (used by NewPreview)
+	PreviewMaxSize uint // max preview width allowed in requests (clip.MaxPreviewSize if zero)
+	PreviewMaxDPI  uint // max preview resolution allowed in requests (clip.MaxPreviewDPI if zero)
 }
 
 func (p *Params) validate() {
-	if p.PoolC == nil {
-		panic("clip/handler.Params: PoolC is nil")
+	if p.Scheduler == nil {
+		panic("clip/handler.Params: Scheduler is nil")
+	}
+}
+
+func (p *Params) clipper() *clip.Clipper {
+	if p.Clipper == nil {
+		return clip.NewClipper(clip.ClipperOptions{})
 	}
+	return p.Clipper
 }
 
 type Logger interface {
@@ -54,6 +79,7 @@ var (
 	ErrBodyIsEmpty      = errors.New("request body is empty")
 	ErrJSONUnmarshal    = errors.New("json unmarshal failed")
 	ErrMethodNotAllowed = errors.New("method not allowed")
+	ErrBodyTooLarge     = errors.New("request body is too large")
 )
 
 type ParamError struct {
@@ -80,7 +106,80 @@ func (e PresetNotFoundError) Error() string {
 	return "preset not found: " + string(e)
 }
 
+// RangeError is returned if request param value is out of range.
+type RangeError struct {
+	Param    string
+	Min, Max uint
+}
+
+func (e *RangeError) Error() string {
+	return fmt.Sprintf("param %s should be in range %d-%d", e.Param, e.Min, e.Max)
+}
+
+// TrustedParamError is returned if request sets param, which is allowed
+// only in presets and config.
+type TrustedParamError string
+
+func (e TrustedParamError) Error() string {
+	return "param can be set only by presets: " + string(e)
+}
+
+// renderer renders parsed request to w.
+type renderer struct {
+	name        string // for logging
+	contentType string
+	render      func(ctx context.Context, url string, w io.Writer, p *clip.Params) error
+}
+
+// New returns handler, which clips page to PDF (or image if format
+// param is set).
 func New(p Params) http.HandlerFunc {
+	c := p.clipper()
+	return newHandler(p, func(req *parsedRequest) (renderer, error) {
+		if req.IsImage() {
+			return renderer{"clip.ToImageCtx", req.ContentType(), c.ToImageCtx}, nil
+		}
+		return renderer{"clip.ToPDFCtx", req.ContentType(), c.ToPDFCtx}, nil
+	})
+}
+
+// NewPreview returns handler, which renders PNG preview of the first page
+// of PDF, which would be returned by New handler for the same request.
+// Preview size and resolution can be set by size and dpi request params,
+// values above PreviewMaxSize and PreviewMaxDPI are rejected.
+func NewPreview(p Params) http.HandlerFunc {
+	c := p.clipper()
+	maxSize, maxDPI := p.PreviewMaxSize, p.PreviewMaxDPI
+	if maxSize == 0 || maxSize > clip.MaxPreviewSize {
+		maxSize = clip.MaxPreviewSize
+	}
+	if maxDPI == 0 || maxDPI > clip.MaxPreviewDPI {
+		maxDPI = clip.MaxPreviewDPI
+	}
+	return newHandler(p, func(req *parsedRequest) (renderer, error) {
+		size, dpi := p.PreviewSize, p.PreviewDPI
+		if req.Size != nil {
+			if *req.Size < 1 || *req.Size > maxSize {
+				return renderer{}, &RangeError{"size", 1, maxSize}
+			}
+			size = *req.Size
+		}
+		if req.DPI != nil {
+			if *req.DPI < 1 || *req.DPI > maxDPI {
+				return renderer{}, &RangeError{"dpi", 1, maxDPI}
+			}
+			dpi = *req.DPI
+		}
+		return renderer{"clip.PreviewCtx", "image/png",
+			func(ctx context.Context, url string, w io.Writer, cp *clip.Params) error {
+				return c.PreviewCtx(ctx, url, w, cp, size, dpi)
+			}}, nil
+	})
+}
+
+// newHandler returns handler, which renders request with renderer returned
+// by rendererFor (it rejects request, if error is returned).
+func newHandler(p Params, rendererFor func(*parsedRequest) (renderer, error)) http.HandlerFunc {
 	p.validate()
 	log := p.Logger
 	if log == nil {
@@ -90,11 +189,16 @@ func New(p Params) http.HandlerFunc {
 	if presets == nil {
 		presets = dummyPresets{}
 	}
-	poolC := p.PoolC
+	sched := p.Scheduler
+	maxBody := p.MaxBodySize
+	if maxBody == 0 {
+		maxBody = DefaultMaxBodySize
+	}
 
 	return func(w http.ResponseWriter, r *http.Request) {
 		log.Printf("new request: %v", r.URL)
 		var err error
+		bw := &bodyWriter{ResponseWriter: w}
 		defer func() {
 			if rec := recover(); rec != nil {
 				err = fmt.Errorf("recovered from: %+v", rec)
@@ -102,9 +206,28 @@ func New(p Params) http.HandlerFunc {
 			if r.Body != nil {
 				_ = r.Body.Close()
 			}
+			var ignored *clip.IgnoredError
+			if err != nil && bw.written && !errors.As(err, &ignored) {
+				log.Error(err)
+				abort(w, err)
+				return
+			}
 			finalize(w, log, err)
 		}()
 
+		// key is checked before body is read, so unauthenticated
+		// clients can't make server buffer it
+		var ks *keyState
+		if p.Keys != nil {
+			ks, err = p.Keys.authenticate(r)
+			if err != nil {
+				return
+			}
+		}
+		if r.Body != nil && maxBody > 0 {
+			r.Body = &maxBytesBody{ReadCloser: http.MaxBytesReader(w, r.Body, maxBody), max: maxBody}
+		}
+
 		var pReq *parsedRequest
 
 		pReq, err = parse(r)
@@ -112,50 +235,123 @@ func New(p Params) http.HandlerFunc {
 			err = fmt.Errorf("parse: %w", err)
 			return
 		}
+		if names := pReq.TrustedOnly(); len(names) > 0 {
+			err = TrustedParamError(names[0])
+			return
+		}
+		client, prio := clientIP(r), PriorityNormal
+		if ks != nil {
+			err = ks.authorize(pReq.URL, pReq.Presets)
+			if err != nil {
+				return
+			}
+			err = ks.acquire(time.Now())
+			if err != nil {
+				err = fmt.Errorf("key %s: %w", ks.Name, err)
+				return
+			}
+			defer ks.release()
+			client, prio = ks.Name, ks.priority
+		}
+		prio = sched.Priority(client, prio)
 		err = pReq.buildParams(presets)
 		if err != nil {
 			err = fmt.Errorf("pReq.buildParams: %w", err)
 			return
 		}
+		if p.Defaults != nil {
+			pReq.AddFrom(p.Defaults)
+		}
+		var rnd renderer
+		rnd, err = rendererFor(pReq)
+		if err != nil {
+			return
+		}
 
-		ctx := r.Context()
-		select {
-		case <-ctx.Done():
-			log.Error(ctx.Err())
+		wrk, pos, acqErr := sched.Acquire(r.Context(), client, prio)
+		if acqErr != nil {
+			err = fmt.Errorf("sched.Acquire: %w", acqErr)
 			return
-		case <-poolC:
 		}
-		defer func() { poolC <- struct{}{} }()
+		defer sched.Release(wrk)
+		if ks != nil {
+			err = ks.charge(time.Now())
+			if err != nil {
+				err = fmt.Errorf("key %s: %w", ks.Name, err)
+				return
+			}
+		}
+		ctx := clip.WithWorker(r.Context(), wrk)
+		log.Printf("client %s got worker at queue position %d", client, pos)
 
 		var ct = fallbackContentType
 		if strings.Contains(strings.ToLower(r.Header.Get("accept")),
-			contentType) {
-			ct = contentType
+			rnd.contentType) {
+			ct = rnd.contentType
 		}
 		w.Header().Add("content-type", ct)
 
-		bw := bufio.NewWriter(w)
-		defer func() {
-			err := bw.Flush()
-			if err != nil {
-				log.Error(fmt.Errorf("bufio.Writer.Flush: %w", err))
-			}
-		}()
-
 		log.Printf("request: url: %s, presets: %s, params: %v",
 			pReq.URL, pReq.Presets, pReq.Params)
 
-		err = clip.ToPDFCtx(ctx, pReq.URL, bw, pReq.Params)
+		err = rnd.render(clip.WithPresets(ctx, pReq.Presets), pReq.URL, bw, pReq.Params)
 		if err != nil {
 			var ignored *clip.IgnoredError
 			if !errors.As(err, &ignored) {
-				err = fmt.Errorf("clip.ToPDFCtx(ctx, %s, %v): %w", pReq.URL, pReq.Params, err)
+				err = fmt.Errorf("%s(ctx, %s, %v): %w", rnd.name, pReq.URL, pReq.Params, err)
 				return
 			}
 		}
 	}
 }
 
+// maxBytesBody replaces http.MaxBytesReader error with ErrBodyTooLarge.
+type maxBytesBody struct {
+	io.ReadCloser
+	n, max int64
+}
+
+func (b *maxBytesBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	b.n += int64(n)
+	if err != nil && err != io.EOF && b.n >= b.max {
+		err = ErrBodyTooLarge
+	}
+	return n, err
+}
+
+// bodyWriter reports if response body was written, so status can't be
+// changed.
+type bodyWriter struct {
+	http.ResponseWriter
+	written bool
+}
+
+func (w *bodyWriter) Write(b []byte) (int, error) {
+	if len(b) > 0 {
+		w.written = true
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+// Aborter is implemented by response writers, which are not served by
+// net/http server, to be notified that response is broken (see abort).
+type Aborter interface {
+	Abort(err error)
+}
+
+// abort breaks response, which failed after its body was partially
+// written, so client can't take truncated body for complete one:
+// connection is closed without terminating chunk (HTTP/1.1) or stream is
+// reset (HTTP/2).
+func abort(w http.ResponseWriter, err error) {
+	if a, ok := w.(Aborter); ok {
+		a.Abort(err)
+		return
+	}
+	panic(http.ErrAbortHandler)
+}
+
 func finalize(w http.ResponseWriter, log Logger, err error) {
 	if err != nil {
 		log.Error(err)
@@ -167,6 +363,18 @@ func finalize(w http.ResponseWriter, log Logger, err error) {
 
 	status, body := mapError(err)
 
+	var (
+		queueFull *QueueFullError
+		limitErr  *LimitError
+	)
+	switch {
+	case errors.As(err, &queueFull):
+		w.Header().Set("retry-after", retryAfter(queueFull.RetryAfter))
+	case errors.As(err, &limitErr) && limitErr.RetryAfter > 0:
+		w.Header().Set("retry-after", retryAfter(limitErr.RetryAfter))
+	case errors.Is(err, ErrUnauthorized):
+		w.Header().Set("www-authenticate", "Bearer")
+	}
 	w.Header().Set("content-type", "text/plain")
 	w.WriteHeader(status)
 	_, err = w.Write([]byte(body))
@@ -179,8 +387,14 @@ func mapError(err error) (status int, body string) {
 	var (
 		urlErr         *clip.URLError
 		validErr       *clip.ValidationError
+		confErr        *clip.ConformanceError
+		scriptErr      *clip.ScriptError
 		valErr         *ParamError
 		presetNotFound PresetNotFoundError
+		trustedParam   TrustedParamError
+		rangeErr       *RangeError
+		queueFull      *QueueFullError
+		limitErr       *LimitError
 	)
 	switch {
 	case errors.Is(err, clip.ErrBadStatus):
@@ -195,6 +409,42 @@ func mapError(err error) (status int, body string) {
 	case errors.Is(err, clip.ErrNoQueryResult):
 		body = "no result elements for given selectors"
 		status = SNoResult
+	case errors.Is(err, clip.ErrTooLarge):
+		body = "requested page or its resources are too large"
+		status = SPageTooLarge
+	case errors.Is(err, clip.ErrRenderTimeout):
+		body = "document rendering timed out"
+		status = http.StatusGatewayTimeout
+	case errors.Is(err, clip.ErrFetchTimeout):
+		body = "requested page fetch timed out"
+		status = SFetchTimeout
+	case errors.Is(err, clip.ErrTooManyRedirects):
+		body = "too many redirects for requested url"
+		status = STooManyRedirects
+	case errors.Is(err, clip.ErrBadContentType):
+		body = "requested url content type is not supported"
+		status = SBadContentType
+	case errors.Is(err, ErrUnauthorized):
+		body = "valid API key is required"
+		status = http.StatusUnauthorized
+	case errors.Is(err, ErrForbidden):
+		body = err.Error()
+		status = http.StatusForbidden
+	case errors.As(err, &limitErr):
+		body = "API key limit exceeded: " + limitErr.Limit
+		status = http.StatusTooManyRequests
+	case errors.As(err, &queueFull):
+		body = "too many requests in queue, retry later"
+		status = http.StatusTooManyRequests
+	case errors.Is(err, ErrSchedulerClosed):
+		body = "server is shutting down"
+		status = http.StatusServiceUnavailable
+	case errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded):
+		body = "request is cancelled"
+		status = http.StatusServiceUnavailable
+	case errors.Is(err, ErrBodyTooLarge):
+		body = "request body is too large"
+		status = http.StatusRequestEntityTooLarge
 	case errors.Is(err, ErrBodyIsEmpty):
 		body = "request body is empty"
 		status = http.StatusBadRequest
@@ -206,9 +456,22 @@ func mapError(err error) (status int, body string) {
 	case errors.As(err, &presetNotFound):
 		body = "preset not found: " + string(presetNotFound)
 		status = SNoPreset
+	case errors.As(err, &rangeErr):
+		body = rangeErr.Error()
+		status = http.StatusBadRequest
+	case errors.As(err, &trustedParam):
+		body = "param " + string(trustedParam) + " can be set only by presets"
+		status = http.StatusBadRequest
 	case errors.As(err, &validErr):
 		body = validErr.Message
 		status = SValidationFailed
+	case errors.As(err, &scriptErr):
+		body = "preset script failed: " + scriptErr.Message
+		status = SScriptFailed
+	case errors.As(err, &confErr):
+		body = "archival document doesn't conform to PDF/A: " +
+			strings.Join(confErr.Violations, "; ")
+		status = SNonConformant
 	case errors.As(err, &urlErr):
 		body = "malformed url"
 		status = SBadURL
@@ -229,6 +492,8 @@ func mapError(err error) (status int, body string) {
 type parsedRequest struct {
 	URL     string   `json:"url,omitempty"`
 	Presets []string `json:"presets,omitempty"`
+	Size    *uint    `json:"size,omitempty"` // preview only
+	DPI     *uint    `json:"dpi,omitempty"`  // preview only
 	*clip.Params
 }
 
@@ -331,17 +596,59 @@ func parseForm(r *http.Request) (*parsedRequest, error) {
 				return nil, &ParamError{nil, fieldName, "bool (true or false)"}
 			}
 			newV = reflect.ValueOf(&boolv)
+		case reflect.Slice:
+			if fldt.Type.Elem().Elem().Kind() != reflect.String {
+				newV = reflect.New(fldt.Type.Elem())
+				err := json.Unmarshal([]byte(reqv), newV.Interface())
+				if err != nil {
+					return nil, &ParamError{err, fieldName, "JSON array"}
+				}
+				break
+			}
+			list := []string{}
+			for _, v := range strings.Split(reqv, ",") {
+				if v = strings.TrimSpace(v); v != "" {
+					list = append(list, v)
+				}
+			}
+			newV = reflect.ValueOf(&list)
 		}
 		pv.Elem().Field(i).Set(newV)
 	}
 
+	size, err := parseUintParam(r, "size")
+	if err != nil {
+		return nil, err
+	}
+	dpi, err := parseUintParam(r, "dpi")
+	if err != nil {
+		return nil, err
+	}
+
 	return &parsedRequest{
 		Presets: strings.Split(r.Form.Get("presets"), ","),
 		URL:     r.Form.Get("url"),
+		Size:    size,
+		DPI:     dpi,
 		Params:  res,
 	}, nil
 }
 
+// parseUintParam returns value of unsigned integer form param or nil
+// if param is not set.
+func parseUintParam(r *http.Request, name string) (*uint, error) {
+	reqv := r.Form.Get(name)
+	if reqv == "" {
+		return nil, nil
+	}
+	v, err := strconv.ParseUint(reqv, 10, 32)
+	if err != nil {
+		return nil, &ParamError{err, name, "unsigned integer"}
+	}
+	uiv := uint(v)
+	return &uiv, nil
+}
+
 type dummyLogger struct{}
 
 func (dummyLogger) Printf(string, ...interface{}) {}
@@ -0,0 +1,249 @@
+package handler
+
+import (
+	"context"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/dinalt/clip"
+)
+
+func TestNew_rejectsTrustedParams(t *testing.T) {
+	pool, err := clip.NewRendererPool(clip.PoolOptions{Size: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := NewScheduler(pool, SchedulerOptions{})
+	defer s.Close()
+	h := New(Params{Scheduler: s})
+
+	for _, tt := range []struct {
+		name, method, query, body, param string
+	}{
+		{"form", "GET", "?url=https://example.com&header_html=%3Ciframe%3E", "", "header_html"},
+		{"json", "POST", "", `{"url":"https://example.com","footer_html":"<b>"}`, "footer_html"},
+		{"script", "POST", "", `{"url":"https://example.com","script":"doc.find(\"p\").remove()"}`, "script"},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(tt.method, "/v0/clip"+tt.query, strings.NewReader(tt.body))
+			if tt.body != "" {
+				r.Header.Set("content-type", "application/json")
+			}
+			w := httptest.NewRecorder()
+			h(w, r)
+			if w.Code != http.StatusBadRequest || !strings.Contains(w.Body.String(), tt.param) {
+				t.Errorf("response = %d %q, want 400 about %s", w.Code, w.Body.String(), tt.param)
+			}
+		})
+	}
+}
+
+func TestNewPreview_limits(t *testing.T) {
+	pool, err := clip.NewRendererPool(clip.PoolOptions{Size: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := NewScheduler(pool, SchedulerOptions{})
+	defer s.Close()
+	h := NewPreview(Params{Scheduler: s, PreviewMaxSize: 512, PreviewMaxDPI: 100})
+
+	for _, q := range []string{"size=513", "size=0", "dpi=101", "dpi=100000000"} {
+		w := httptest.NewRecorder()
+		h(w, httptest.NewRequest("GET", "/v0/preview?url=https://example.com&"+q, nil))
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("%s: response = %d %q, want 400", q, w.Code, w.Body.String())
+		}
+	}
+}
+
+type abortRecorder struct {
+	*httptest.ResponseRecorder
+	err error
+}
+
+func (w *abortRecorder) Abort(err error) {
+	w.err = err
+}
+
+func TestNewHandler_abortsPartialResponse(t *testing.T) {
+	pool, err := clip.NewRendererPool(clip.PoolOptions{Size: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := NewScheduler(pool, SchedulerOptions{})
+	defer s.Close()
+	renderErr := errors.New("killed")
+	h := newHandler(Params{Scheduler: s}, func(*parsedRequest) (renderer, error) {
+		return renderer{"fail", "application/pdf",
+			func(_ context.Context, _ string, w io.Writer, _ *clip.Params) error {
+				_, _ = w.Write([]byte("%PDF-1.4"))
+				return renderErr
+			}}, nil
+	})
+
+	w := &abortRecorder{ResponseRecorder: httptest.NewRecorder()}
+	h(w, httptest.NewRequest("GET", "/v0/clip?url=https://example.com", nil))
+	if !errors.Is(w.err, renderErr) {
+		t.Errorf("Abort() error = %v, want %v", w.err, renderErr)
+	}
+	if strings.Contains(w.Body.String(), "killed") {
+		t.Errorf("error is written to body: %q", w.Body.String())
+	}
+
+	srv := httptest.NewServer(h)
+	defer srv.Close()
+	res, err := http.Get(srv.URL + "/v0/clip?url=https://example.com")
+	if err == nil {
+		_, err = ioutil.ReadAll(res.Body)
+		_ = res.Body.Close()
+	}
+	if err == nil {
+		t.Error("truncated response is read without error")
+	}
+}
+
+func TestNew_acquireErrors(t *testing.T) {
+	pool, err := clip.NewRendererPool(clip.PoolOptions{Size: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := NewScheduler(pool, SchedulerOptions{})
+	h := New(Params{Scheduler: s})
+	busy, _, err := s.Acquire(context.Background(), "other", PriorityNormal)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Release(busy)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	w := httptest.NewRecorder()
+	done := make(chan struct{})
+	go func() {
+		h(w, httptest.NewRequest("GET", "/v0/clip?url=https://example.com", nil).WithContext(ctx))
+		close(done)
+	}()
+	for queuedTotal(s.Stats()) != 1 {
+		time.Sleep(time.Millisecond)
+	}
+	cancel()
+	<-done
+	if w.Code != http.StatusServiceUnavailable {
+		t.Errorf("cancelled while queued: response = %d %q, want 503", w.Code, w.Body.String())
+	}
+
+	s.Close()
+	w = httptest.NewRecorder()
+	h(w, httptest.NewRequest("GET", "/v0/clip?url=https://example.com", nil))
+	if w.Code != http.StatusServiceUnavailable {
+		t.Errorf("closed scheduler: response = %d %q, want 503", w.Code, w.Body.String())
+	}
+}
+
+// countingReader counts bytes read from it.
+type countingReader struct {
+	r io.Reader
+	n int
+}
+
+func (r *countingReader) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p)
+	r.n += n
+	return n, err
+}
+
+func TestNew_body(t *testing.T) {
+	pool, err := clip.NewRendererPool(clip.PoolOptions{Size: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := NewScheduler(pool, SchedulerOptions{})
+	defer s.Close()
+	keys, err := KeysFromJSON(strings.NewReader(`[{"name": "a", "hash": "` + HashKey("secret") + `"}]`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := New(Params{Scheduler: s, Keys: keys, MaxBodySize: 100})
+	body := `{"url":"https://example.com","title":"` + strings.Repeat("x", 200) + `"}`
+
+	cr := &countingReader{r: strings.NewReader(body)}
+	r := httptest.NewRequest("POST", "/v0/clip", cr)
+	r.Header.Set("content-type", "application/json")
+	w := httptest.NewRecorder()
+	h(w, r)
+	if w.Code != http.StatusUnauthorized || cr.n != 0 {
+		t.Errorf("unauthenticated: response = %d, %d bytes read, want 401 and none", w.Code, cr.n)
+	}
+
+	r = httptest.NewRequest("POST", "/v0/clip", strings.NewReader(body))
+	r.Header.Set("content-type", "application/json")
+	r.Header.Set("X-API-Key", "secret")
+	w = httptest.NewRecorder()
+	h(w, r)
+	if w.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("large body: response = %d %q, want 413", w.Code, w.Body.String())
+	}
+}
+
+func TestNew_keyCharge(t *testing.T) {
+	pool, err := clip.NewRendererPool(clip.PoolOptions{Size: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := NewScheduler(pool, SchedulerOptions{MaxQueue: 1})
+	defer s.Close()
+	keys, err := KeysFromJSON(strings.NewReader(`[{"name": "a", "hash": "` + HashKey("secret") + `", "daily_quota": 5}]`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := New(Params{Scheduler: s, Keys: keys})
+	request := func(query string) int {
+		r := httptest.NewRequest("GET", "/v0/clip?url=https://example.com"+query, nil)
+		r.Header.Set("X-API-Key", "secret")
+		w := httptest.NewRecorder()
+		h(w, r)
+		return w.Code
+	}
+
+	// fill pool and queue, so request is rejected by scheduler
+	busy, _, err := s.Acquire(context.Background(), "other", PriorityNormal)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	queued := make(chan struct{})
+	go func() {
+		_, _, _ = s.Acquire(ctx, "other", PriorityNormal)
+		close(queued)
+	}()
+	for queuedTotal(s.Stats()) != 1 {
+		time.Sleep(time.Millisecond)
+	}
+	for _, tt := range []struct {
+		query string
+		want  int
+	}{
+		{"&margin_top=x", SValidationFailed},
+		{"&presets=nope", SNoPreset},
+		{"", http.StatusTooManyRequests},
+	} {
+		if got := request(tt.query); got != tt.want {
+			t.Errorf("request(%q) = %d, want %d", tt.query, got, tt.want)
+		}
+	}
+	cancel()
+	<-queued
+	s.Release(busy)
+
+	r := httptest.NewRequest("GET", "/v0/usage", nil)
+	r.Header.Set("X-API-Key", "secret")
+	ks, _ := keys.authenticate(r)
+	if u := ks.usage(); u.Clips != 0 || u.InFlight != 0 {
+		t.Errorf("usage after failed requests = %+v, want no clips", u)
+	}
+}
@@ -0,0 +1,342 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/dinalt/clip"
+)
+
+// Priority is request priority class. Workers are given to waiting
+// requests of higher class first.
+type Priority int
+
+// Priority classes.
+const (
+	PriorityLow Priority = iota
+	PriorityNormal
+	PriorityHigh
+	priorityCount
+)
+
+// ParsePriority parses priority class name (low, normal or high).
+func ParsePriority(s string) (Priority, error) {
+	switch s {
+	case "low":
+		return PriorityLow, nil
+	case "normal":
+		return PriorityNormal, nil
+	case "high":
+		return PriorityHigh, nil
+	}
+	return PriorityNormal, fmt.Errorf("unknown priority: %s", s)
+}
+
+// ErrSchedulerClosed is returned by Scheduler.Acquire after Close.
+var ErrSchedulerClosed = errors.New("scheduler is closed")
+
+// Scheduler defaults.
+const (
+	DefaultMaxQueue   = 100
+	DefaultRetryAfter = 5 * time.Second
+)
+
+// SchedulerOptions configure Scheduler.
+type SchedulerOptions struct {
+	MaxQueue   int                 // max waiting requests (DefaultMaxQueue if zero, negative - no limit)
+	RetryAfter time.Duration       // Retry-After value for rejected requests (DefaultRetryAfter if zero)
+	Priorities map[string]Priority // priority classes by client (key name, or IP if auth is disabled), PriorityNormal by default
+}
+
+// QueueFullError is returned when request can't be queued. Handler
+// responds to it with 429 status and Retry-After header.
+type QueueFullError struct {
+	RetryAfter time.Duration
+}
+
+func (e *QueueFullError) Error() string {
+	return "queue is full"
+}
+
+// SchedulerStats are scheduler metrics.
+type SchedulerStats struct {
+	Queued      [priorityCount]int `json:"queued"`       // waiting requests by priority class
+	Served      uint64             `json:"served"`       // requests, which got worker
+	Rejected    uint64             `json:"rejected"`     // requests rejected due to full queue
+	Cancelled   uint64             `json:"cancelled"`    // requests cancelled while waiting
+	AvgWait     time.Duration      `json:"avg_wait_ns"`  // average wait time of served requests
+	MaxWait     time.Duration      `json:"max_wait_ns"`  // max wait time of served requests
+	LastWait    time.Duration      `json:"last_wait_ns"` // wait time of the last served request
+	MaxPosition int                `json:"max_position"` // max queue position at enqueue time
+}
+
+// Scheduler gives renderer pool workers to requests. Waiting requests are
+// served by priority class, in round robin order of clients within class
+// and in FIFO order within client, so one client can't starve others.
+type Scheduler struct {
+	pool *clip.RendererPool
+	opts SchedulerOptions
+
+	mu        sync.Mutex
+	classes   [priorityCount]queueClass
+	queued    int
+	notifyC   chan struct{}
+	closeC    chan struct{}
+	stats     SchedulerStats
+	totalWait time.Duration
+}
+
+// queueClass is queue of single priority class.
+type queueClass struct {
+	order   []string // clients with waiting requests, round robin order
+	waiting map[string][]*waiter
+}
+
+type waiter struct {
+	workerC chan *clip.Worker // receives worker, buffered
+	enqueue time.Time
+}
+
+// NewScheduler creates scheduler for pool and starts dispatching.
+func NewScheduler(pool *clip.RendererPool, opts SchedulerOptions) *Scheduler {
+	if opts.MaxQueue == 0 {
+		opts.MaxQueue = DefaultMaxQueue
+	}
+	if opts.RetryAfter == 0 {
+		opts.RetryAfter = DefaultRetryAfter
+	}
+	s := &Scheduler{
+		pool:    pool,
+		opts:    opts,
+		notifyC: make(chan struct{}, 1),
+		closeC:  make(chan struct{}),
+	}
+	for i := range s.classes {
+		s.classes[i].waiting = make(map[string][]*waiter)
+	}
+	go s.dispatch()
+	return s
+}
+
+// Close stops dispatching. Pool isn't closed.
+func (s *Scheduler) Close() {
+	close(s.closeC)
+}
+
+// Priority returns priority class of client set by
+// SchedulerOptions.Priorities or def, if it isn't set.
+func (s *Scheduler) Priority(client string, def Priority) Priority {
+	if p, ok := s.opts.Priorities[client]; ok {
+		return p
+	}
+	return def
+}
+
+// Acquire waits for worker in queue of client. Position is queue length
+// at enqueue time. Worker must be returned with Release.
+func (s *Scheduler) Acquire(ctx context.Context, client string, prio Priority) (w *clip.Worker, position int, err error) {
+	if prio < 0 || prio >= priorityCount {
+		prio = PriorityNormal
+	}
+	wt := &waiter{workerC: make(chan *clip.Worker, 1), enqueue: time.Now()}
+
+	select {
+	case <-s.closeC:
+		return nil, 0, ErrSchedulerClosed
+	default:
+	}
+	s.mu.Lock()
+	if s.opts.MaxQueue > 0 && s.queued >= s.opts.MaxQueue {
+		s.stats.Rejected++
+		s.mu.Unlock()
+		return nil, 0, &QueueFullError{s.opts.RetryAfter}
+	}
+	position = s.queued
+	if position > s.stats.MaxPosition {
+		s.stats.MaxPosition = position
+	}
+	s.classes[prio].push(client, wt)
+	s.queued++
+	s.mu.Unlock()
+
+	select {
+	case s.notifyC <- struct{}{}:
+	default:
+	}
+
+	err = ErrSchedulerClosed
+	select {
+	case w = <-wt.workerC:
+		return w, position, nil
+	case <-ctx.Done():
+		err = fmt.Errorf("context error: %w", ctx.Err())
+	case <-s.closeC:
+	}
+
+	s.mu.Lock()
+	removed := s.classes[prio].remove(client, wt)
+	if removed {
+		s.queued--
+		s.stats.Cancelled++
+	}
+	s.mu.Unlock()
+	if !removed { // worker was given concurrently
+		s.Release(<-wt.workerC)
+	}
+	return nil, position, err
+}
+
+// Release returns worker to pool.
+func (s *Scheduler) Release(w *clip.Worker) {
+	s.pool.Release(w)
+}
+
+// Stats returns scheduler metrics.
+func (s *Scheduler) Stats() SchedulerStats {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	st := s.stats
+	for i := range s.classes {
+		for _, ws := range s.classes[i].waiting {
+			st.Queued[i] += len(ws)
+		}
+	}
+	if st.Served > 0 {
+		st.AvgWait = s.totalWait / time.Duration(st.Served)
+	}
+	return st
+}
+
+// dispatch acquires pool workers while there are waiting requests and
+// gives them to the next waiter.
+func (s *Scheduler) dispatch() {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		<-s.closeC
+		cancel()
+	}()
+	for {
+		s.mu.Lock()
+		empty := s.queued == 0
+		s.mu.Unlock()
+		if empty {
+			select {
+			case <-s.notifyC:
+				continue
+			case <-ctx.Done():
+				return
+			}
+		}
+		w, err := s.pool.Acquire(ctx)
+		if err != nil {
+			return
+		}
+		s.mu.Lock()
+		wt := s.next()
+		if wt != nil {
+			s.queued--
+			wait := time.Since(wt.enqueue)
+			s.stats.Served++
+			s.stats.LastWait = wait
+			s.totalWait += wait
+			if wait > s.stats.MaxWait {
+				s.stats.MaxWait = wait
+			}
+			wt.workerC <- w
+		}
+		s.mu.Unlock()
+		if wt == nil { // waiters were cancelled
+			s.pool.Release(w)
+		}
+	}
+}
+
+// next pops the next waiter (s.mu must be held).
+func (s *Scheduler) next() *waiter {
+	for i := len(s.classes) - 1; i >= 0; i-- {
+		if wt := s.classes[i].pop(); wt != nil {
+			return wt
+		}
+	}
+	return nil
+}
+
+func (q *queueClass) push(client string, wt *waiter) {
+	if len(q.waiting[client]) == 0 {
+		q.order = append(q.order, client)
+	}
+	q.waiting[client] = append(q.waiting[client], wt)
+}
+
+// pop returns the first waiter of the next client in round robin order.
+func (q *queueClass) pop() *waiter {
+	if len(q.order) == 0 {
+		return nil
+	}
+	client := q.order[0]
+	q.order = q.order[1:]
+	ws := q.waiting[client]
+	wt := ws[0]
+	if len(ws) == 1 {
+		delete(q.waiting, client)
+	} else {
+		q.waiting[client] = ws[1:]
+		q.order = append(q.order, client)
+	}
+	return wt
+}
+
+// remove removes waiter from queue, it returns false if waiter wasn't found.
+func (q *queueClass) remove(client string, wt *waiter) bool {
+	ws := q.waiting[client]
+	for i := range ws {
+		if ws[i] != wt {
+			continue
+		}
+		ws = append(ws[:i:i], ws[i+1:]...)
+		if len(ws) > 0 {
+			q.waiting[client] = ws
+			return true
+		}
+		delete(q.waiting, client)
+		for j, c := range q.order {
+			if c == client {
+				q.order = append(q.order[:j:j], q.order[j+1:]...)
+				break
+			}
+		}
+		return true
+	}
+	return false
+}
+
+// clientIP returns client IP, which is used for fair scheduling if
+// authentication is disabled (otherwise key name is used).
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	return host
+}
+
+// NewStats returns handler, which responds with scheduler metrics in JSON.
+func NewStats(s *Scheduler) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("content-type", "application/json")
+		_ = json.NewEncoder(w).Encode(s.Stats())
+	}
+}
+
+// retryAfter formats Retry-After header value (seconds, rounded up).
+func retryAfter(d time.Duration) string {
+	return strconv.Itoa(int((d + time.Second - 1) / time.Second))
+}
@@ -0,0 +1,93 @@
+package handler
+
+import (
+	"context"
+	"errors"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/dinalt/clip"
+)
+
+func TestScheduler(t *testing.T) {
+	pool, err := clip.NewRendererPool(clip.PoolOptions{Size: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := NewScheduler(pool, SchedulerOptions{
+		MaxQueue:   4,
+		Priorities: map[string]Priority{"vip": PriorityHigh},
+	})
+	defer s.Close()
+	ctx := context.Background()
+
+	w, _, err := s.Acquire(ctx, "x", PriorityNormal)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// queue: x, x, y (normal), vip (high); served order: vip, x, y, x
+	orderC := make(chan string, 4)
+	for i, client := range []string{"x", "x", "y", "vip"} {
+		go func(client string) {
+			w, _, err := s.Acquire(ctx, client, s.Priority(client, PriorityNormal))
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			orderC <- client
+			s.Release(w)
+		}(client)
+		for queuedTotal(s.Stats()) != i+1 { // keep enqueue order
+			time.Sleep(time.Millisecond)
+		}
+	}
+
+	if p := s.Priority("y", PriorityLow); p != PriorityLow {
+		t.Errorf("Priority() of unknown client = %v, want default", p)
+	}
+	if _, _, err = s.Acquire(ctx, "z", PriorityNormal); !errors.As(err, new(*QueueFullError)) {
+		t.Errorf("Acquire() with full queue error = %v, want QueueFullError", err)
+	}
+	cctx, cancel := context.WithCancel(ctx)
+	cancel()
+	s.opts.MaxQueue = 5
+	if _, _, err = s.Acquire(cctx, "z", PriorityNormal); !errors.Is(err, context.Canceled) {
+		t.Errorf("Acquire() with cancelled context error = %v", err)
+	}
+
+	s.Release(w)
+	var got []string
+	for i := 0; i < 4; i++ {
+		got = append(got, <-orderC)
+	}
+	want := []string{"vip", "x", "y", "x"}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("served order = %v, want %v", got, want)
+		}
+	}
+
+	st := s.Stats()
+	if st.Served != 5 || st.Rejected != 1 || st.Cancelled != 1 || queuedTotal(st) != 0 || st.MaxPosition != 4 {
+		t.Errorf("unexpected stats: %+v", st)
+	}
+}
+
+func queuedTotal(st SchedulerStats) int {
+	var n int
+	for _, v := range st.Queued {
+		n += v
+	}
+	return n
+}
+
+func Test_clientIP(t *testing.T) {
+	r := httptest.NewRequest("GET", "/v0/clip", nil)
+	r.RemoteAddr = "10.0.0.5:4321"
+	r.Header.Set("X-API-Key", "secret")
+	if got := clientIP(r); got != "10.0.0.5" {
+		t.Errorf("clientIP() = %q, want %q", got, "10.0.0.5")
+	}
+}
@@ -0,0 +1,174 @@
+package clip
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// Output formats.
+const (
+	FormatPDF  = "pdf"
+	FormatPNG  = "png"
+	FormatJPEG = "jpg"
+	FormatWebP = "webp" // requires Qt webp image plugin in wkhtmltoimage build
+)
+
+const defaultViewportHeight = 768
+
+func isFormat(f string) bool {
+	switch strings.ToLower(f) {
+	case FormatPDF, FormatPNG, FormatJPEG, "jpeg", FormatWebP:
+		return true
+	}
+	return false
+}
+
+// IsImage reports if p requests image output.
+func (p *Params) IsImage() bool {
+	return p.Format != nil && *p.Format != "" &&
+		strings.ToLower(*p.Format) != FormatPDF
+}
+
+// imageFormat returns normalized image format.
+func (p *Params) imageFormat() string {
+	f := strings.ToLower(*p.Format)
+	if f == "jpeg" {
+		f = FormatJPEG
+	}
+	return f
+}
+
+// ContentType returns MIME type of output produced for p.
+func (p *Params) ContentType() string {
+	if !p.IsImage() {
+		return "application/pdf"
+	}
+	switch p.imageFormat() {
+	case FormatJPEG:
+		return "image/jpeg"
+	case FormatWebP:
+		return "image/webp"
+	}
+	return "image/png"
+}
+
+// imageArgs returns wkhtmltoimage arguments (without input and output).
+func (p *Params) imageArgs() []string {
+	args := []string{"--format", p.imageFormat()}
+	if p.ImageWidth != nil {
+		args = append(args, "--width", strconv.FormatUint(uint64(*p.ImageWidth), 10))
+	}
+	if p.ImageQuality != nil {
+		args = append(args, "--quality", strconv.FormatUint(uint64(*p.ImageQuality), 10))
+	}
+	if p.FullPage != nil && !*p.FullPage {
+		args = append(args, "--height", strconv.Itoa(p.viewportHeight()))
+	}
+	if p.EnableJavascript == nil || !*p.EnableJavascript {
+		args = append(args, "--disable-javascript")
+	}
+	if p.NoImages != nil && *p.NoImages {
+		args = append(args, "--no-images")
+	}
+	if p.InlineResources != nil && *p.InlineResources {
+		args = append(args, "--proxy", blackholeProxy, "--load-error-handling", "ignore",
+			"--load-media-error-handling", "ignore")
+	}
+	if p.Zoom != nil {
+		args = append(args, "--zoom", strconv.FormatFloat(*p.Zoom, 'f', -1, 64))
+	}
+	return args
+}
+
+// viewportHeight returns height from ViewportSize param (WxH) or default.
+func (p *Params) viewportHeight() int {
+	if p.ViewportSize != nil {
+		parts := strings.SplitN(strings.ToLower(*p.ViewportSize), "x", 2)
+		if len(parts) == 2 {
+			if h, err := strconv.Atoi(strings.TrimSpace(parts[1])); err == nil && h > 0 {
+				return h
+			}
+		}
+	}
+	return defaultViewportHeight
+}
+
+// ToImageCtx downloads page from url, renders it to image via
+// wkhtmltoimage in format set by p.Format and writes result to w.
+func (c *Clipper) ToImageCtx(ctx context.Context, url string, w io.Writer, p *Params) error {
+	if ctx == nil {
+		panic("clip.ToImageCtx: ctx is nil")
+	}
+	if w == nil {
+		panic("clip.ToImageCtx: w is nil")
+	}
+	if p == nil {
+		panic("clip.ToImageCtx: params is nil")
+	}
+	err := p.validate()
+	if err == nil {
+		err = c.checkTransforms(p)
+	}
+	if err != nil {
+		return err
+	}
+	if !p.IsImage() {
+		return &ValidationError{"image format is required"}
+	}
+	if url == "" {
+		return ErrNoURL
+	}
+	bin, err := c.imageBinPath()
+	if err != nil {
+		return err
+	}
+	tURL, err := parseURL(url)
+	if err != nil {
+		return err
+	}
+
+	args := p.imageArgs()
+	var stdin io.Reader
+	switch {
+	case p.skipDOMProcess():
+		args = append(args, url, "-")
+	default:
+		txt, _, err := c.getHTML(ctx, tURL, p)
+		if err != nil {
+			return err
+		}
+		stdin = strings.NewReader(txt)
+		args = append(args, "-", "-")
+	}
+	out := newPeekWriter(w)
+	runErr := c.run(ctx, bin, args, stdin, out)
+	if errors.Is(runErr, ErrRenderTimeout) {
+		return runErr
+	}
+	select {
+	case <-ctx.Done():
+		return fmt.Errorf("context error: %w", ctx.Err())
+	default:
+	}
+
+	out.flush()
+	if out.err != nil {
+		return out.err
+	}
+	if out.n < 1 {
+		if runErr != nil {
+			return fmt.Errorf("no image was generated: %w", runErr)
+		}
+		return fmt.Errorf("no image was generated")
+	}
+
+	if runErr != nil {
+		return &IgnoredError{runErr} // just for logging
+	}
+
+	return nil
+}
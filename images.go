@@ -0,0 +1,157 @@
+package clip
+
+import (
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/PuerkitoBio/goquery"
+)
+
+// printPixelRatio is device pixel ratio used to choose responsive image
+// candidates: images are printed, so they need higher resolution than
+// for screen.
+const printPixelRatio = 2
+
+// Attributes used by lazy-loading libraries (lazysizes, lozad, jQuery
+// lazyload, etc) to keep real image source, in order of preference.
+var (
+	lazySrcAttrs = []string{"data-src", "data-lazy-src", "data-original", "data-lazy",
+		"data-echo", "data-url", "data-hi-res-src", "data-full-src"}
+	lazySrcsetAttrs = []string{"data-srcset", "data-lazy-srcset", "data-original-set"}
+)
+
+// image types, which are not supported by renderer
+var unsupportedImageTypes = map[string]bool{
+	"image/webp": true,
+	"image/avif": true,
+	"image/jxl":  true,
+}
+
+// targetImageWidth returns width (px) of the widest image in document,
+// rendered with p.
+func (p *Params) targetImageWidth() int {
+	if p.IsImage() {
+		if p.ImageWidth != nil && *p.ImageWidth > 0 {
+			return int(*p.ImageWidth)
+		}
+		return 1024 // wkhtmltoimage default
+	}
+	size, margins := p.pageGeometry()
+	return int((size[0] - margins[1] - margins[3]) / mmPerInch * cssDPI * printPixelRatio)
+}
+
+// normalizeImages makes lazy-loading and responsive images printable:
+// unwraps noscript fallbacks, restores sources from lazy-loading
+// attributes and replaces srcset and picture sources by single src with
+// the best candidate for width.
+func normalizeImages(doc *goquery.Document, width int) {
+	doc.Find("noscript").Each(func(_ int, sel *goquery.Selection) {
+		txt := sel.Text()
+		if !strings.Contains(strings.ToLower(txt), "<img") {
+			return
+		}
+		// lazy loading placeholder usually precedes noscript fallback
+		prev := sel.Prev()
+		if goquery.NodeName(prev) == "img" && isLazyImage(prev) {
+			prev.Remove()
+		}
+		sel.ReplaceWithHtml(txt)
+	})
+
+	doc.Find("img,source").Each(func(_ int, sel *goquery.Selection) {
+		for _, a := range lazySrcsetAttrs {
+			if v := sel.AttrOr(a, ""); v != "" {
+				sel.SetAttr("srcset", v)
+				break
+			}
+		}
+		if goquery.NodeName(sel) == "source" {
+			return
+		}
+		for _, a := range lazySrcAttrs {
+			if v := sel.AttrOr(a, ""); v != "" && !strings.HasPrefix(v, "data:") {
+				sel.SetAttr("src", v)
+				break
+			}
+		}
+		sel.RemoveAttr("loading")
+	})
+
+	doc.Find("picture").Each(func(_ int, sel *goquery.Selection) {
+		img := sel.Find("img").First()
+		if img.Length() == 0 {
+			return
+		}
+		sel.Find("source").EachWithBreak(func(_ int, src *goquery.Selection) bool {
+			if unsupportedImageTypes[strings.ToLower(src.AttrOr("type", ""))] {
+				return true
+			}
+			if _, ok := src.Attr("srcset"); !ok {
+				return true
+			}
+			img.SetAttr("srcset", src.AttrOr("srcset", ""))
+			return false
+		})
+		sel.Find("source").Remove()
+	})
+
+	doc.Find("img[srcset]").Each(func(_ int, sel *goquery.Selection) {
+		if best := bestCandidate(parseSrcset(sel.AttrOr("srcset", "")), width); best != "" {
+			sel.SetAttr("src", best)
+		}
+		sel.RemoveAttr("srcset")
+		sel.RemoveAttr("sizes")
+	})
+}
+
+// isLazyImage reports if img has no real source yet.
+func isLazyImage(img *goquery.Selection) bool {
+	src := img.AttrOr("src", "")
+	if src == "" || strings.HasPrefix(src, "data:") {
+		return true
+	}
+	for _, a := range append(lazySrcAttrs, lazySrcsetAttrs...) {
+		if _, ok := img.Attr(a); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// bestCandidate returns URL of the smallest candidate not narrower than
+// width (or the widest one). Candidates with pixel density descriptors
+// are compared by density against printPixelRatio.
+func bestCandidate(cs []srcsetCandidate, width int) string {
+	var (
+		best      string
+		bestScore = math.Inf(-1) // closeness to target, larger is better
+	)
+	for _, c := range cs {
+		var v, target float64
+		d := strings.ToLower(c.Descriptor)
+		switch {
+		case strings.HasSuffix(d, "w"):
+			v, _ = strconv.ParseFloat(strings.TrimSuffix(d, "w"), 64)
+			target = float64(width)
+		case strings.HasSuffix(d, "x"):
+			v, _ = strconv.ParseFloat(strings.TrimSuffix(d, "x"), 64)
+			target = printPixelRatio
+		default:
+			v, target = 1, printPixelRatio
+		}
+		if v <= 0 {
+			continue
+		}
+		// candidates large enough are scored by excess (smaller is better),
+		// others are scored below them by their size
+		score := -v
+		if v < target {
+			score = v - 2*target - 1e9
+		}
+		if best == "" || score > bestScore {
+			best, bestScore = c.URL, score
+		}
+	}
+	return best
+}
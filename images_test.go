@@ -0,0 +1,68 @@
+package clip
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/PuerkitoBio/goquery"
+)
+
+func Test_bestCandidate(t *testing.T) {
+	tests := []struct {
+		in    string
+		width int
+		want  string
+	}{
+		{"a.png 400w, b.png 800w, c.png 1600w", 700, "b.png"},
+		{"a.png 400w, b.png 800w, c.png 1600w", 800, "b.png"},
+		{"a.png 400w, b.png 800w", 2000, "b.png"},
+		{"a.png 1x, b.png 2x, c.png 3x", 0, "b.png"},
+		{"a.png, b.png 1.5x", 0, "b.png"},
+		{"a.png 0w", 100, ""},
+	}
+	for _, tt := range tests {
+		if got := bestCandidate(parseSrcset(tt.in), tt.width); got != tt.want {
+			t.Errorf("bestCandidate(%q, %d) = %q, want %q", tt.in, tt.width, got, tt.want)
+		}
+	}
+}
+
+func Test_normalizeImages(t *testing.T) {
+	doc, err := goquery.NewDocumentFromReader(strings.NewReader(`<html><body>
+		<img id="lazy" src="data:image/gif;base64,R0lGOD" data-src="real.png" loading="lazy">
+		<img id="set" data-srcset="s.png 300w, m.png 600w, l.png 1200w" sizes="100vw">
+		<picture id="pic">
+			<source type="image/webp" srcset="p.webp">
+			<source srcset="p-small.jpg 500w, p-large.jpg 1000w">
+			<img src="p.jpg">
+		</picture>
+		<img class="lazyload" src="placeholder.gif" data-src="ns.png"><noscript><img id="ns" src="ns.png"></noscript>
+		</body></html>`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	normalizeImages(doc, 800)
+
+	for sel, want := range map[string]string{
+		"#lazy":    "real.png",
+		"#set":     "l.png",
+		"#pic img": "p-large.jpg",
+		"#ns":      "ns.png",
+	} {
+		img := doc.Find(sel)
+		if got := img.AttrOr("src", ""); got != want {
+			t.Errorf("%s[src] = %q, want %q", sel, got, want)
+		}
+		for _, a := range []string{"srcset", "sizes", "loading"} {
+			if _, ok := img.Attr(a); ok {
+				t.Errorf("%s[%s] was not removed", sel, a)
+			}
+		}
+	}
+	if n := doc.Find("source").Length(); n != 0 {
+		t.Errorf("%d source elements left", n)
+	}
+	if n := doc.Find("img.lazyload,noscript").Length(); n != 0 {
+		t.Errorf("noscript fallback was not unwrapped")
+	}
+}
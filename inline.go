@@ -0,0 +1,403 @@
+package clip
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	neturl "net/url"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/PuerkitoBio/goquery"
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+// InlineOptions control fetching of subresources, when they are inlined
+// into document (see Params.InlineResources). Zero value of size and time
+// limits means no limit.
+type InlineOptions struct {
+	Concurrency     int           // max parallel requests
+	MaxResourceSize int64         // resources larger than this are skipped
+	MaxTotalSize    int64         // all resources size limit
+	Timeout         time.Duration // per resource timeout
+}
+
+// DefaultInlineOptions are used by Clipper, if ClipperOptions.Inline is
+// nil. MaxTotalSize is always taken from Limits.
+var DefaultInlineOptions = InlineOptions{
+	Concurrency:     8,
+	MaxResourceSize: 10 << 20,
+	Timeout:         15 * time.Second,
+}
+
+// blackholeProxy is unreachable proxy address. It is passed to wkhtmltopdf
+// when all resources are inlined, so renderer never accesses network.
+const blackholeProxy = "http://127.0.0.1:9"
+
+var (
+	cssURLRe    = regexp.MustCompile(`url\(\s*(?:'([^']*)'|"([^"]*)"|([^'")\s]+))\s*\)`)
+	cssImportRe = regexp.MustCompile(`@import\s+(?:'([^']*)'|"([^"]*)")`)
+	styleEndRe  = regexp.MustCompile(`(?i)</(style)`)
+)
+
+// srcsetCandidate is image candidate string from srcset attribute.
+type srcsetCandidate struct {
+	URL        string
+	Descriptor string // width (100w) or pixel density (2x) descriptor, may be empty
+}
+
+// parseSrcset splits srcset attribute value into image candidates
+// (simplified HTML spec algorithm: URLs may contain commas, but can't
+// start or end with them).
+func parseSrcset(s string) []srcsetCandidate {
+	var res []srcsetCandidate
+	isSpace := func(c byte) bool { return c == ' ' || c == '\t' || c == '\n' || c == '\r' || c == '\f' }
+	for i := 0; i < len(s); {
+		for i < len(s) && (isSpace(s[i]) || s[i] == ',') {
+			i++
+		}
+		start := i
+		for i < len(s) && !isSpace(s[i]) {
+			i++
+		}
+		if start == i {
+			break
+		}
+		c := srcsetCandidate{URL: s[start:i]}
+		if strings.HasSuffix(c.URL, ",") {
+			c.URL = strings.TrimRight(c.URL, ",")
+			res = append(res, c)
+			continue
+		}
+		start = i
+		for i < len(s) && s[i] != ',' {
+			i++
+		}
+		c.Descriptor = strings.TrimSpace(s[start:i])
+		res = append(res, c)
+	}
+	return res
+}
+
+// formatSrcset is reverse of parseSrcset.
+func formatSrcset(cs []srcsetCandidate) string {
+	parts := make([]string, len(cs))
+	for i, c := range cs {
+		parts[i] = strings.TrimSpace(c.URL + " " + c.Descriptor)
+	}
+	return strings.Join(parts, ", ")
+}
+
+// cssURLs returns all URLs referenced by url() and @import in css.
+func cssURLs(css string) []string {
+	var res []string
+	for _, re := range []*regexp.Regexp{cssURLRe, cssImportRe} {
+		for _, m := range re.FindAllStringSubmatch(css, -1) {
+			for _, v := range m[1:] {
+				if v != "" {
+					res = append(res, v)
+				}
+			}
+		}
+	}
+	return res
+}
+
+// rewriteCSSURLs replaces URLs in url() and @import by result of fn.
+func rewriteCSSURLs(css string, fn func(string) string) string {
+	css = cssURLRe.ReplaceAllStringFunc(css, func(m string) string {
+		sm := cssURLRe.FindStringSubmatch(m)
+		v := sm[1] + sm[2] + sm[3]
+		if v == "" {
+			return m
+		}
+		return "url(\"" + fn(v) + "\")"
+	})
+	return cssImportRe.ReplaceAllStringFunc(css, func(m string) string {
+		sm := cssImportRe.FindStringSubmatch(m)
+		return "@import url(\"" + fn(sm[1]+sm[2]) + "\")"
+	})
+}
+
+// resource is fetched subresource.
+type resource struct {
+	contentType string
+	data        []byte
+}
+
+// dataURI returns resource encoded as data URI.
+func (r *resource) dataURI() string {
+	return "data:" + r.contentType + ";base64," + base64.StdEncoding.EncodeToString(r.data)
+}
+
+// inliner fetches subresources and keeps fetched results.
+type inliner struct {
+	client *http.Client
+	opts   InlineOptions
+	sem    chan struct{}
+	mu     sync.Mutex
+	res    map[string]*resource // nil value for failed resources
+	total  int64                // size of fetched resources
+	err    error                // set when total size limit is exceeded
+}
+
+func newInliner(client *http.Client, opts InlineOptions) *inliner {
+	if opts.Concurrency < 1 {
+		opts.Concurrency = 1
+	}
+	return &inliner{
+		client: client,
+		opts:   opts,
+		sem:    make(chan struct{}, opts.Concurrency),
+		res:    make(map[string]*resource),
+	}
+}
+
+// fetchAll fetches all urls (skipping already fetched) concurrently.
+func (in *inliner) fetchAll(ctx context.Context, urls []string) {
+	var wg sync.WaitGroup
+	for _, u := range urls {
+		in.mu.Lock()
+		_, ok := in.res[u]
+		if !ok {
+			in.res[u] = nil
+		}
+		in.mu.Unlock()
+		if ok {
+			continue
+		}
+		wg.Add(1)
+		go func(u string) {
+			defer wg.Done()
+			select {
+			case <-ctx.Done():
+				return
+			case in.sem <- struct{}{}:
+			}
+			defer func() { <-in.sem }()
+			r, err := in.fetch(ctx, u)
+			if err != nil {
+				return // resource is left as is
+			}
+			in.mu.Lock()
+			defer in.mu.Unlock()
+			in.total += int64(len(r.data))
+			if in.opts.MaxTotalSize > 0 && in.total > in.opts.MaxTotalSize {
+				if in.err == nil {
+					in.err = fmt.Errorf("%w: subresources exceed %d bytes", ErrTooLarge, in.opts.MaxTotalSize)
+				}
+				return
+			}
+			in.res[u] = r
+		}(u)
+	}
+	wg.Wait()
+}
+
+func (in *inliner) fetch(ctx context.Context, url string) (*resource, error) {
+	if in.opts.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, in.opts.Timeout)
+		defer cancel()
+	}
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+	if err != nil {
+		return nil, fmt.Errorf("http.NewRequestWithContext: %w", err)
+	}
+	req.Header.Set("user-agent", "clip-to-pdf/1.0")
+	resp, err := in.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("http.Client.Do: %w", err)
+	}
+	defer func() { _ = resp.Body.Close() }()
+	if resp.StatusCode/200 != 1 {
+		return nil, fmt.Errorf("%w: %d", ErrBadStatus, resp.StatusCode)
+	}
+	var body io.Reader = resp.Body
+	if in.opts.MaxResourceSize > 0 {
+		body = io.LimitReader(resp.Body, in.opts.MaxResourceSize+1)
+	}
+	b, err := ioutil.ReadAll(body)
+	if err != nil {
+		return nil, fmt.Errorf("ioutil.ReadAll: %w", err)
+	}
+	if in.opts.MaxResourceSize > 0 && int64(len(b)) > in.opts.MaxResourceSize {
+		return nil, fmt.Errorf("resource is too large: %s", url)
+	}
+	ct := resp.Header.Get("content-type")
+	if _, _, err := mime.ParseMediaType(ct); err != nil || ct == "" {
+		ct = http.DetectContentType(b)
+	}
+	return &resource{contentType: ct, data: b}, nil
+}
+
+// get returns fetched resource or nil.
+func (in *inliner) get(url string) *resource {
+	in.mu.Lock()
+	defer in.mu.Unlock()
+	return in.res[url]
+}
+
+// uri returns data URI for fetched url or url itself if it wasn't fetched.
+func (in *inliner) uri(url string) string {
+	if r := in.get(url); r != nil {
+		return r.dataURI()
+	}
+	return url
+}
+
+// inlineCSS fetches resources referenced by css (relative to base) and
+// returns css with references replaced by data URIs.
+func (in *inliner) inlineCSS(ctx context.Context, css string, base *neturl.URL) string {
+	var urls []string
+	for _, v := range cssURLs(css) {
+		if u := resolve(base, v); u != "" {
+			urls = append(urls, u)
+		}
+	}
+	in.fetchAll(ctx, urls)
+	return rewriteCSSURLs(css, func(v string) string {
+		if u := resolve(base, v); u != "" {
+			return in.uri(u)
+		}
+		return v
+	})
+}
+
+// resolve returns absolute http(s) URL for ref relative to base or empty
+// string if ref can't (or shouldn't) be fetched.
+func resolve(base *neturl.URL, ref string) string {
+	ref = strings.TrimSpace(ref)
+	if ref == "" || strings.HasPrefix(ref, "data:") || strings.HasPrefix(ref, "#") {
+		return ""
+	}
+	u, err := neturl.Parse(ref)
+	if err != nil {
+		return ""
+	}
+	u = base.ResolveReference(u)
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return ""
+	}
+	u.Fragment = ""
+	return u.String()
+}
+
+// inlineResources replaces images, stylesheets and CSS references in doc
+// by data URIs. It fails only if total size limit is exceeded.
+func inlineResources(ctx context.Context, doc *goquery.Document, client *http.Client, opts InlineOptions) error {
+	in := newInliner(client, opts)
+	base := doc.Url
+
+	// first pass: collect and fetch
+	var urls []string
+	add := func(v string) {
+		if u := resolve(base, v); u != "" {
+			urls = append(urls, u)
+		}
+	}
+	doc.Find("img[src],input[type=image][src],video[poster]").Each(func(_ int, sel *goquery.Selection) {
+		for _, a := range []string{"src", "poster"} {
+			if v, ok := sel.Attr(a); ok {
+				add(v)
+			}
+		}
+	})
+	doc.Find("img[srcset],source[srcset]").Each(func(_ int, sel *goquery.Selection) {
+		for _, c := range parseSrcset(sel.AttrOr("srcset", "")) {
+			add(c.URL)
+		}
+	})
+	stylesheets := doc.Find("link[href]").FilterFunction(func(_ int, sel *goquery.Selection) bool {
+		for _, rel := range strings.Fields(strings.ToLower(sel.AttrOr("rel", ""))) {
+			if rel == "stylesheet" {
+				return true
+			}
+		}
+		return false
+	})
+	stylesheets.Each(func(_ int, sel *goquery.Selection) { add(sel.AttrOr("href", "")) })
+	in.fetchAll(ctx, urls)
+
+	// second pass: rewrite
+	doc.Find("img[src],input[type=image][src],video[poster]").Each(func(_ int, sel *goquery.Selection) {
+		for _, a := range []string{"src", "poster"} {
+			if v, ok := sel.Attr(a); ok {
+				if u := resolve(base, v); u != "" {
+					sel.SetAttr(a, in.uri(u))
+				}
+			}
+		}
+	})
+	doc.Find("img[srcset],source[srcset]").Each(func(_ int, sel *goquery.Selection) {
+		cs := parseSrcset(sel.AttrOr("srcset", ""))
+		for i := range cs {
+			if u := resolve(base, cs[i].URL); u != "" {
+				cs[i].URL = in.uri(u)
+			}
+		}
+		sel.SetAttr("srcset", formatSrcset(cs))
+	})
+	stylesheets.Each(func(_ int, sel *goquery.Selection) {
+		u := resolve(base, sel.AttrOr("href", ""))
+		r := in.get(u)
+		if r == nil {
+			return
+		}
+		cssBase, _ := neturl.Parse(u)
+		css := in.inlineCSS(ctx, string(r.data), cssBase)
+		style := &html.Node{
+			Type:     html.ElementNode,
+			DataAtom: atom.Style,
+			Data:     "style",
+			Attr:     []html.Attribute{{Key: "type", Val: "text/css"}},
+		}
+		if media, ok := sel.Attr("media"); ok {
+			style.Attr = append(style.Attr, html.Attribute{Key: "media", Val: media})
+		}
+		style.AppendChild(&html.Node{Type: html.TextNode, Data: escapeStyle(css)})
+		sel.ReplaceWithNodes(style)
+	})
+	doc.Find("style").Each(func(_ int, sel *goquery.Selection) {
+		css := sel.Text()
+		if len(cssURLs(css)) == 0 {
+			return
+		}
+		setRawText(sel, in.inlineCSS(ctx, css, base))
+	})
+	doc.Find("[style]").Each(func(_ int, sel *goquery.Selection) {
+		css := sel.AttrOr("style", "")
+		if len(cssURLs(css)) == 0 {
+			return
+		}
+		sel.SetAttr("style", in.inlineCSS(ctx, css, base))
+	})
+	return in.err
+}
+
+// escapeStyle makes css safe to be style element content. "</style" in
+// valid CSS can only appear in strings and comments, where "<\/style" is
+// equivalent, otherwise it would close the element and the rest would be
+// parsed as HTML.
+func escapeStyle(css string) string {
+	return styleEndRe.ReplaceAllString(css, `<\/$1`)
+}
+
+// setRawText replaces content of elements in sel by text node with value
+// v. Unlike goquery.Selection.SetText it doesn't escape v, which is
+// required for raw text elements (style, script).
+func setRawText(sel *goquery.Selection, v string) {
+	for _, n := range sel.Nodes {
+		for c := n.FirstChild; c != nil; c = n.FirstChild {
+			n.RemoveChild(c)
+		}
+		n.AppendChild(&html.Node{Type: html.TextNode, Data: v})
+	}
+}
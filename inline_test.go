@@ -0,0 +1,121 @@
+package clip
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	neturl "net/url"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/PuerkitoBio/goquery"
+)
+
+func Test_parseSrcset(t *testing.T) {
+	tests := []struct {
+		in   string
+		want []srcsetCandidate
+	}{
+		{"a.png", []srcsetCandidate{{"a.png", ""}}},
+		{"a.png 1x, b.png 2x", []srcsetCandidate{{"a.png", "1x"}, {"b.png", "2x"}}},
+		{" a.png 100w,b.png   200w ", []srcsetCandidate{{"a.png", "100w"}, {"b.png", "200w"}}},
+		{"a.png,b.png 2x", []srcsetCandidate{{"a.png,b.png", "2x"}}},
+		{"a.png, b.png", []srcsetCandidate{{"a.png", ""}, {"b.png", ""}}},
+		{"/img?w=1,h=2 1x", []srcsetCandidate{{"/img?w=1,h=2", "1x"}}},
+	}
+	for _, tt := range tests {
+		if got := parseSrcset(tt.in); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("parseSrcset(%q) = %v, want %v", tt.in, got, tt.want)
+		}
+	}
+}
+
+func Test_inlineResources(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/css/style.css":
+			w.Header().Set("content-type", "text/css")
+			_, _ = w.Write([]byte(`body{background:url(../bg.png)}`))
+		case "/css/evil.css":
+			w.Header().Set("content-type", "text/css")
+			_, _ = w.Write([]byte(`a::after{content:"</STYLE><img src=evil.png>"}`))
+		case "/bg.png", "/img.png":
+			w.Header().Set("content-type", "image/png")
+			_, _ = w.Write([]byte("png"))
+		case "/big.png":
+			_, _ = w.Write([]byte(strings.Repeat("x", 100)))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer srv.Close()
+
+	doc, err := goquery.NewDocumentFromReader(strings.NewReader(`<html><head>
+		<link rel="stylesheet" href="/css/style.css" media="print">
+		<link rel="stylesheet" href="/css/evil.css">
+		</head><body>
+		<img src="img.png" srcset="/img.png 1x, /missing.png 2x">
+		<img src="/big.png">
+		<div style="background-image:url('/bg.png')"></div>
+		</body></html>`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	doc.Url, _ = neturl.Parse(srv.URL + "/page/")
+	err = inlineResources(context.Background(), doc, srv.Client(), InlineOptions{
+		Concurrency:     2,
+		MaxResourceSize: 50,
+		Timeout:         DefaultInlineOptions.Timeout,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	const png = "data:image/png;base64,cG5n"
+	if got := doc.Find("img").First().AttrOr("src", ""); got != srv.URL+"/page/img.png" {
+		t.Errorf("img[src] of missing resource = %q, want absolute URL", got)
+	}
+	if got := doc.Find("img").First().AttrOr("srcset", ""); got != png+" 1x, "+srv.URL+"/missing.png 2x" {
+		t.Errorf("img[srcset] = %q", got)
+	}
+	if got := doc.Find("img").Last().AttrOr("src", ""); got != srv.URL+"/big.png" {
+		t.Errorf("img[src] of too large resource = %q, want absolute URL", got)
+	}
+	if got := doc.Find("div").AttrOr("style", ""); got != `background-image:url("`+png+`")` {
+		t.Errorf("div[style] = %q", got)
+	}
+	style := doc.Find("style[media=print]")
+	if style.Length() != 1 || style.Text() != `body{background:url("`+png+`")}` {
+		t.Errorf("stylesheet was not inlined: %q", style.Text())
+	}
+	if doc.Find("link").Length() != 0 {
+		t.Errorf("link element was not removed")
+	}
+	txt, _ := doc.Html()
+	doc, _ = goquery.NewDocumentFromReader(strings.NewReader(txt))
+	if doc.Find("img[src=evil\\.png]").Length() != 0 || doc.Find("style").Length() != 2 {
+		t.Errorf("stylesheet content breaks out of style element: %s", txt)
+	}
+}
+
+func Test_inlineResources_zeroOptions(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("content-type", "image/png")
+		_, _ = w.Write([]byte("png"))
+	}))
+	defer srv.Close()
+
+	doc, err := goquery.NewDocumentFromReader(strings.NewReader(`<html><body><img src="/img.png"></body></html>`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	doc.Url, _ = neturl.Parse(srv.URL)
+	err = inlineResources(context.Background(), doc, srv.Client(), InlineOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := doc.Find("img").AttrOr("src", ""); got != "data:image/png;base64,cG5n" {
+		t.Errorf("img[src] = %q, want data URI (zero limits mean no limit)", got)
+	}
+}
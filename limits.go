@@ -0,0 +1,150 @@
+package clip
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Limits restrict fetching of page and its subresources. Zero value of
+// any field means no limit.
+type Limits struct {
+	MaxHTMLSize      int64         // page body size, bytes
+	MaxResourcesSize int64         // total size of inlined subresources, bytes
+	ConnectTimeout   time.Duration // TCP connect and TLS handshake timeout
+	HeaderTimeout    time.Duration // time to wait for response headers
+	Timeout          time.Duration // total page fetch timeout (including body)
+	MaxRedirects     int           // redirects to follow, negative value disables redirects
+	ContentTypes     []string      // allowed page media types
+}
+
+// DefaultLimits are used by Clipper, if ClipperOptions.Limits is nil.
+// Limits are not applied when page is passed to renderer as is (without
+// DOM processing).
+var DefaultLimits = Limits{
+	MaxHTMLSize:      10 << 20,
+	MaxResourcesSize: 50 << 20,
+	ConnectTimeout:   10 * time.Second,
+	HeaderTimeout:    15 * time.Second,
+	Timeout:          30 * time.Second,
+	MaxRedirects:     10,
+	ContentTypes:     []string{"text/html", "application/xhtml+xml"},
+}
+
+// Limit errors.
+var (
+	ErrTooLarge          = errors.New("response is too large")
+	ErrFetchTimeout      = errors.New("fetch timeout")
+	ErrTooManyRedirects  = errors.New("too many redirects")
+	ErrBadContentType    = errors.New("content type is not allowed")
+	errRedirectsDisabled = fmt.Errorf("%w: redirects are disabled", ErrTooManyRedirects)
+)
+
+var transports struct {
+	sync.Mutex
+	m map[[2]time.Duration]*http.Transport
+}
+
+// transport returns shared transport with connect and header timeouts.
+func transport(connect, header time.Duration) *http.Transport {
+	transports.Lock()
+	defer transports.Unlock()
+	key := [2]time.Duration{connect, header}
+	if t, ok := transports.m[key]; ok {
+		return t
+	}
+	t := http.DefaultTransport.(*http.Transport).Clone()
+	t.DialContext = (&net.Dialer{Timeout: connect, KeepAlive: 30 * time.Second}).DialContext
+	t.TLSHandshakeTimeout = connect
+	t.ResponseHeaderTimeout = header
+	if transports.m == nil {
+		transports.m = make(map[[2]time.Duration]*http.Transport)
+	}
+	transports.m[key] = t
+	return t
+}
+
+// client returns HTTP client enforcing l timeouts and redirect policy.
+func (l *Limits) client() *http.Client {
+	max := l.MaxRedirects
+	return &http.Client{
+		Transport: transport(l.ConnectTimeout, l.HeaderTimeout),
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			switch {
+			case max < 0:
+				return errRedirectsDisabled
+			case max > 0 && len(via) > max:
+				return fmt.Errorf("%w: more than %d", ErrTooManyRedirects, max)
+			}
+			return nil
+		},
+	}
+}
+
+// checkContentType returns ErrBadContentType if contentType header value
+// isn't empty and isn't in l.ContentTypes.
+func (l *Limits) checkContentType(contentType string) error {
+	if len(l.ContentTypes) == 0 || contentType == "" {
+		return nil
+	}
+	mt, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return fmt.Errorf("%w: %s", ErrBadContentType, contentType)
+	}
+	for _, v := range l.ContentTypes {
+		if strings.EqualFold(mt, v) {
+			return nil
+		}
+	}
+	return fmt.Errorf("%w: %s", ErrBadContentType, mt)
+}
+
+// limitedReader returns ErrTooLarge when more than n bytes are read.
+type limitedReader struct {
+	r io.Reader
+	n int64 // bytes left
+}
+
+func (l *limitedReader) Read(p []byte) (int, error) {
+	if l.n < 0 {
+		return 0, ErrTooLarge
+	}
+	if int64(len(p)) > l.n+1 {
+		p = p[:l.n+1]
+	}
+	n, err := l.r.Read(p)
+	l.n -= int64(n)
+	if l.n < 0 {
+		return n, ErrTooLarge
+	}
+	return n, err
+}
+
+// limitBody wraps r, so it fails with ErrTooLarge after max bytes.
+func limitBody(r io.Reader, max int64) io.Reader {
+	if max <= 0 {
+		return r
+	}
+	return &limitedReader{r: r, n: max}
+}
+
+// fetchError converts timeout errors of fetch with ctx (derived from
+// parent) to ErrFetchTimeout.
+func fetchError(parent, ctx context.Context, err error) error {
+	var netErr net.Error
+	switch {
+	case parent.Err() != nil:
+		return err
+	case errors.Is(err, context.DeadlineExceeded) && ctx.Err() != nil,
+		errors.As(err, &netErr) && netErr.Timeout():
+		return fmt.Errorf("%w: %v", ErrFetchTimeout, err)
+	}
+	return err
+}
@@ -0,0 +1,89 @@
+package clip
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	neturl "net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+func Test_getHTML_limits(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/ok":
+			_, _ = w.Write([]byte("<html><body><p>ok</p></body></html>"))
+		case "/large":
+			w.Header().Set("content-type", "text/html")
+			_, _ = w.Write([]byte("<html><body><p>" + strings.Repeat("x", 1000) + "</p></body></html>"))
+		case "/pdf":
+			w.Header().Set("content-type", "application/pdf")
+			_, _ = w.Write([]byte("%PDF-1.4"))
+		case "/slow":
+			time.Sleep(200 * time.Millisecond)
+			_, _ = w.Write([]byte("<html><body><p>slow</p></body></html>"))
+		case "/loop":
+			http.Redirect(w, r, "/loop", http.StatusFound)
+		case "/redirect":
+			http.Redirect(w, r, "/ok", http.StatusFound)
+		}
+	}))
+	defer srv.Close()
+
+	tests := []struct {
+		path   string
+		limits func(*Limits)
+		want   error
+	}{
+		{"/ok", nil, nil},
+		{"/redirect", nil, nil},
+		{"/large", func(l *Limits) { l.MaxHTMLSize = 100 }, ErrTooLarge},
+		{"/pdf", nil, ErrBadContentType},
+		{"/pdf", func(l *Limits) { l.ContentTypes = nil }, nil},
+		{"/slow", func(l *Limits) { l.HeaderTimeout = 50 * time.Millisecond }, ErrFetchTimeout},
+		{"/slow", func(l *Limits) { l.Timeout = 50 * time.Millisecond }, ErrFetchTimeout},
+		{"/loop", func(l *Limits) { l.MaxRedirects = 3 }, ErrTooManyRedirects},
+		{"/redirect", func(l *Limits) { l.MaxRedirects = -1 }, ErrTooManyRedirects},
+	}
+	for _, tt := range tests {
+		l := Limits{
+			ConnectTimeout: time.Second,
+			MaxRedirects:   10,
+			ContentTypes:   []string{"text/html"},
+		}
+		if tt.limits != nil {
+			tt.limits(&l)
+		}
+		u, _ := neturl.Parse(srv.URL + tt.path)
+		_, _, err := NewClipper(ClipperOptions{Limits: &l}).getHTML(context.Background(), u, &Params{})
+		if tt.want == nil && err != nil && !errors.Is(err, ErrNoQueryResult) {
+			t.Errorf("getHTML(%s) error = %v", tt.path, err)
+		}
+		if tt.want != nil && !errors.Is(err, tt.want) {
+			t.Errorf("getHTML(%s) error = %v, want %v", tt.path, err, tt.want)
+		}
+	}
+}
+
+func Test_limitBody(t *testing.T) {
+	var buf [4]byte
+	r := limitBody(strings.NewReader("12345"), 5)
+	n, err := r.Read(buf[:])
+	if n != 4 || err != nil {
+		t.Fatalf("Read() = %d, %v", n, err)
+	}
+	if n, err = r.Read(buf[:]); n != 1 || err != nil {
+		t.Fatalf("Read() = %d, %v", n, err)
+	}
+	if _, err = r.Read(buf[:]); err == nil || errors.Is(err, ErrTooLarge) {
+		t.Fatalf("Read() at EOF error = %v, want io.EOF", err)
+	}
+	r = limitBody(strings.NewReader("123456"), 5)
+	b := make([]byte, 10)
+	if _, err = r.Read(b); !errors.Is(err, ErrTooLarge) {
+		t.Fatalf("Read() error = %v, want ErrTooLarge", err)
+	}
+}
@@ -0,0 +1,149 @@
+package clip
+
+import (
+	"context"
+	"html"
+	"regexp"
+	"strings"
+
+	"github.com/PuerkitoBio/goquery"
+	nethtml "golang.org/x/net/html"
+)
+
+// mathContainers are elements, which text is searched for $$...$$,
+// \[...\] and \(...\) formulas.
+const mathContainers = "p,li,td,th,dd,blockquote,.math,.tex,.latex,.arithmatex"
+
+// mathDelimitersRe matches display ($$...$$, \[...\]) and inline (\(...\))
+// formulas in text.
+var mathDelimitersRe = regexp.MustCompile(`(?s)\$\$(.+?)\$\$|\\\[(.+?)\\\]|\\\((.+?)\\\)`)
+
+// mathStyle lays out MathML elements with CSS, because renderer's
+// QtWebKit doesn't support MathML and renders it as plain inline text
+// (including TeX source in annotation). Layout is approximate: fractions,
+// scripts, limits, roots and matrices are positioned, but operators
+// aren't stretched.
+const mathStyle = `<style type="text/css">` +
+	`math{font-family:"Times New Roman",Times,serif;font-style:normal;font-weight:normal;white-space:nowrap}` +
+	`math[display="block"]{display:block;text-align:center;` +
+	`margin:.5em 0;page-break-inside:avoid;break-inside:avoid-page}` +
+	`math annotation,math annotation-xml{display:none}` +
+	`math mi{font-style:italic}math mi[mathvariant],math mstyle[mathvariant] mi{font-style:normal}` +
+	`math [mathvariant*="italic"]{font-style:italic}math [mathvariant^="bold"]{font-weight:bold}` +
+	`math [mathvariant="monospace"]{font-family:monospace}math [mathvariant$="sans-serif"]{font-family:sans-serif}` +
+	`math mo{padding:0 .15em}math mspace{display:inline-block;width:.3em}` +
+	`math mspace[linebreak="newline"]{display:block;width:auto}` +
+	`math mfrac{display:inline-block;vertical-align:middle;text-align:center;margin:0 .1em}` +
+	`math mfrac>*{display:block;padding:0 .1em}math mfrac>*:first-child{border-bottom:1px solid}` +
+	`math msub>*+*,math msubsup>*+*{vertical-align:sub;font-size:75%}` +
+	`math msup>*+*,math msubsup>*+*+*{vertical-align:super;font-size:75%}` +
+	`math munder,math mover,math munderover{display:inline-table;vertical-align:middle;text-align:center}` +
+	`math munder>*,math mover>*,math munderover>*{display:table-row;font-size:75%}` +
+	`math munder>*:first-child,math mover>*:first-child,math munderover>*:first-child{font-size:100%}` +
+	`math mover>*+*,math munderover>*+*+*{display:table-header-group}` +
+	`math msqrt,math mroot>*:first-child{border-top:1px solid;padding-right:.1em}` +
+	`math msqrt:before,math mroot>*:first-child:before{content:"\221A"}` +
+	`math mroot{display:-webkit-inline-box;-webkit-box-direction:reverse}` +
+	`math mroot>*+*{font-size:60%}` +
+	`math mtable{display:inline-table;vertical-align:middle}math mtr{display:table-row}` +
+	`math mtd{display:table-cell;padding:0 .4em;text-align:center}` +
+	`math menclose[notation*="updiagonalstrike"]{text-decoration:line-through}</style>`
+
+// renderMath replaces TeX formulas with MathML, so they are rendered
+// without MathJax or KaTeX (and JavaScript). Formulas are taken from
+// MathJax script[type="math/tex"] elements, elements with data-tex
+// attribute (TeX source is in source, data-tex or alt attribute) and
+// $$...$$, \[...\] and \(...\) in text of mathContainers. Images with
+// data-tex and loaded source (pre-rendered formulas) are kept, as well as
+// formulas, which can't be converted (images are made visible).
+func renderMath(_ context.Context, doc *goquery.Document, _ *Params) error {
+	converted := false
+	convert := func(tex string, display bool) (string, bool) {
+		res, err := texToMathML(strings.TrimSpace(tex), display)
+		if err != nil {
+			return "", false
+		}
+		converted = true
+		return res, true
+	}
+
+	// MathJax previews show TeX source, when MathJax isn't run
+	doc.Find(".MathJax_Preview").Remove()
+	doc.Find(`script[type^="math/tex"]`).Each(func(_ int, s *goquery.Selection) {
+		display := strings.Contains(s.AttrOr("type", ""), "mode=display")
+		if m, ok := convert(s.Text(), display); ok {
+			s.ReplaceWithHtml(m)
+		} else {
+			s.ReplaceWithHtml(`<code class="tex">` + html.EscapeString(s.Text()) + `</code>`)
+		}
+	})
+
+	doc.Find("[data-tex]").Each(func(_ int, s *goquery.Selection) {
+		if goquery.NodeName(s) == "img" && !isLazyImage(s) {
+			// pre-rendered formula is better than MathML laid out by CSS
+			s.SetAttr("style", styleAttrPrefix(s)+"visibility:visible!important;")
+			return
+		}
+		mode := s.AttrOr("data-tex", "")
+		tex := s.AttrOr("source", "")
+		if tex == "" && mode != "inline" && mode != "display" {
+			tex = mode
+		}
+		if tex == "" {
+			tex = s.AttrOr("alt", "")
+		}
+		display := mode == "display" || s.HasClass("display") || s.HasClass("block")
+		if strings.TrimSpace(tex) != "" {
+			if m, ok := convert(tex, display); ok {
+				s.ReplaceWithHtml(m)
+				return
+			}
+		}
+		if goquery.NodeName(s) == "img" {
+			s.SetAttr("style", styleAttrPrefix(s)+"visibility:visible!important;")
+		}
+	})
+
+	doc.Find(mathContainers).Each(func(_ int, s *goquery.Selection) {
+		s.Contents().Each(func(_ int, c *goquery.Selection) {
+			n := c.Get(0)
+			if n.Type != nethtml.TextNode || !mathDelimitersRe.MatchString(n.Data) {
+				return
+			}
+			var b strings.Builder
+			text, last := n.Data, 0
+			for _, m := range mathDelimitersRe.FindAllStringSubmatchIndex(text, -1) {
+				tex, display := "", true
+				switch {
+				case m[2] >= 0:
+					tex = text[m[2]:m[3]]
+				case m[4] >= 0:
+					tex = text[m[4]:m[5]]
+				default:
+					tex, display = text[m[6]:m[7]], false
+				}
+				res, ok := convert(tex, display)
+				if !ok {
+					continue
+				}
+				b.WriteString(html.EscapeString(text[last:m[0]]) + res)
+				last = m[1]
+			}
+			if last > 0 {
+				c.ReplaceWithHtml(b.String() + html.EscapeString(text[last:]))
+			}
+		})
+	})
+
+	if converted {
+		// multi-letter identifiers (function names) are upright in
+		// MathML, but mathStyle can't select them by length
+		doc.Find("math mi:not([mathvariant])").Each(func(_ int, s *goquery.Selection) {
+			if len([]rune(s.Text())) > 1 {
+				s.SetAttr("mathvariant", "normal")
+			}
+		})
+		doc.Find("head").AppendHtml(mathStyle)
+	}
+	return nil
+}
@@ -0,0 +1,106 @@
+package clip
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os/exec"
+	"strings"
+	"testing"
+
+	"github.com/PuerkitoBio/goquery"
+)
+
+func Test_renderMath(t *testing.T) {
+	doc, err := goquery.NewDocumentFromReader(strings.NewReader(`<html><head></head><body>
+		<span class="MathJax_Preview">x^2</span><script type="math/tex">x^2</script>
+		<script type="math/tex; mode=display">\frac{a}{b}</script>
+		<img data-tex="inline" source="\alpha" src="a.svg" style="visibility:hidden">
+		<img data-tex="inline" source="\beta" src="data:image/gif;base64,R0lGOD">
+		<img data-tex="display" alt="\badcmd" src="b.svg">
+		<p>Euler: $$e^{i\pi}+1=0$$ and \(x&lt;y\), not <code>$$a$$</code> $$\bad$$</p>
+	</body></html>`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = renderMath(context.Background(), doc, &Params{}); err != nil {
+		t.Fatal(err)
+	}
+	if n := doc.Find(".MathJax_Preview,script").Length(); n != 0 {
+		t.Errorf("%d MathJax elements left", n)
+	}
+	var got []string
+	doc.Find("math").Each(func(_ int, s *goquery.Selection) {
+		got = append(got, s.AttrOr("display", "")+":"+s.Find("annotation").Text())
+	})
+	want := []string{`inline:x^2`, `block:\frac{a}{b}`, `inline:\beta`, `block:e^{i\pi}+1=0`, `inline:x<y`}
+	if strings.Join(got, " ") != strings.Join(want, " ") {
+		t.Errorf("formulas = %q, want %q", got, want)
+	}
+	if style := doc.Find(`img[src="a.svg"]`).AttrOr("style", ""); style != "visibility:hidden;visibility:visible!important;" {
+		t.Errorf("pre-rendered formula image style = %q", style)
+	}
+	if style := doc.Find(`img[src="b.svg"]`).AttrOr("style", ""); style != "visibility:visible!important;" {
+		t.Errorf("unconverted formula image style = %q", style)
+	}
+	p := doc.Find("p")
+	if text := p.Find("code").Text(); text != "$$a$$" {
+		t.Errorf("code text = %q", text)
+	}
+	if !strings.HasPrefix(strings.TrimSpace(p.Contents().First().Text()), "Euler:") ||
+		!strings.Contains(p.Text(), `$$\bad$$`) {
+		t.Errorf("paragraph text = %q", p.Text())
+	}
+	if !strings.Contains(doc.Find("head style").Text(), "math annotation,math annotation-xml{display:none}") {
+		t.Error("TeX annotations aren't hidden")
+	}
+
+	doc, _ = goquery.NewDocumentFromReader(strings.NewReader(`<html><head></head><body><p>\(\sin x\)</p></body></html>`))
+	_ = renderMath(context.Background(), doc, &Params{})
+	if v := doc.Find("mi").First().AttrOr("mathvariant", ""); v != "normal" {
+		t.Errorf("function name mathvariant = %q, want normal", v)
+	}
+	if _, ok := doc.Find("mi").Last().Attr("mathvariant"); ok {
+		t.Error("single letter identifier is made upright")
+	}
+}
+
+// TestClipper_ToPDFCtx_math renders formulas with wkhtmltopdf and checks
+// PDF text with pdftotext: formulas are printed without TeX source.
+func TestClipper_ToPDFCtx_math(t *testing.T) {
+	pdftotext, err := exec.LookPath("pdftotext")
+	if err != nil {
+		t.Skip("pdftotext not found")
+	}
+	c := NewClipper(ClipperOptions{})
+	if _, err = c.pdfBinPath(); err != nil {
+		t.Skip("wkhtmltopdf not found")
+	}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("content-type", "text/html")
+		_, _ = w.Write([]byte(`<html><head></head><body><p>Ratio $$\frac{a}{b}$$ squared \(y^2\)</p></body></html>`))
+	}))
+	defer srv.Close()
+
+	var pdf bytes.Buffer
+	transforms := []string{"math"}
+	if err = c.ToPDFCtx(context.Background(), srv.URL, &pdf, &Params{Transforms: &transforms}); err != nil {
+		t.Fatal(err)
+	}
+	cmd := exec.Command(pdftotext, "-", "-")
+	cmd.Stdin = &pdf
+	out, err := cmd.Output()
+	if err != nil {
+		t.Fatal(err)
+	}
+	text := strings.Join(strings.Fields(string(out)), " ")
+	if strings.Contains(text, `\frac`) || strings.Contains(text, "y^2") {
+		t.Errorf("PDF text has TeX source: %q", text)
+	}
+	for _, s := range []string{"Ratio", "a", "b", "squared", "y"} {
+		if !strings.Contains(text, s) {
+			t.Errorf("PDF text = %q, want %q", text, s)
+		}
+	}
+}
@@ -0,0 +1,278 @@
+package clip
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"html"
+	"io/ioutil"
+	"net/http"
+	neturl "net/url"
+	"sort"
+	"strings"
+
+	"github.com/PuerkitoBio/goquery"
+)
+
+// Embedded code sources (variables for tests).
+var (
+	gistAPIURL       = "https://api.github.com/gists/"
+	codepenSourceURL = "https://codepen.io/"
+)
+
+// DefaultMediaHosts are hosts (with subdomains) of embed iframes, which
+// are replaced by media transform, if ClipperOptions.MediaHosts is nil.
+// Iframes from other hosts are left as is: they could be maps, charts or
+// other content, which is printed.
+var DefaultMediaHosts = []string{
+	"youtube.com", "youtube-nocookie.com", "vimeo.com", "dailymotion.com",
+	"rutube.ru", "coub.com", "twitch.tv", "tiktok.com", "ted.com", "loom.com",
+	"soundcloud.com", "spotify.com", "mixcloud.com", "bandcamp.com",
+	"codepen.io", "jsfiddle.net", "codesandbox.io", "stackblitz.com",
+	"twitter.com", "facebook.com", "instagram.com",
+	"slideshare.net", "speakerdeck.com",
+}
+
+// mediaEmbed is printable replacement of embedded media.
+type mediaEmbed struct {
+	title string
+	link  string
+	thumb string // preview image URL
+	code  []codeFile
+}
+
+// codeFile is source code of embed (gist file, pen HTML, CSS or JS).
+type codeFile struct {
+	name string
+	lang string
+	text string
+}
+
+// html returns placeholder markup: preview image or code blocks, and
+// caption with link to original.
+func (e *mediaEmbed) html() string {
+	link, title := html.EscapeString(e.link), html.EscapeString(e.title)
+	var b strings.Builder
+	b.WriteString(`<figure class="clip-media">`)
+	if e.thumb != "" {
+		b.WriteString(`<a href="` + link + `"><img src="` + html.EscapeString(e.thumb) +
+			`" alt="` + title + `"></a>`)
+	}
+	for _, f := range e.code {
+		if f.name != "" {
+			b.WriteString(`<div class="clip-media-file">` + html.EscapeString(f.name) + `</div>`)
+		}
+		b.WriteString(`<pre><code`)
+		if f.lang != "" {
+			b.WriteString(` class="language-` + html.EscapeString(f.lang) + `"`)
+		}
+		b.WriteString(`>` + html.EscapeString(f.text) + `</code></pre>`)
+	}
+	b.WriteString(`<figcaption><a href="` + link + `">` + title + `</a> ` +
+		`<span class="clip-media-url">` + link + `</span></figcaption></figure>`)
+	return b.String()
+}
+
+const mediaStyle = `<style type="text/css">figure.clip-media{margin:1em 0;padding:.5em;` +
+	`border:1px solid #ccc;page-break-inside:avoid;break-inside:avoid-page}` +
+	`figure.clip-media img{max-width:100%}figure.clip-media pre{white-space:pre-wrap}` +
+	`.clip-media-file{font-weight:bold}.clip-media-url{color:#666;font-size:smaller}</style>`
+
+// convertMedia replaces iframes of media hosts (YouTube, Vimeo, CodePen
+// and others, see DefaultMediaHosts), video and audio elements, which are
+// printed as blank boxes, with placeholders: preview image (poster or
+// video thumbnail), title and link. Gist and CodePen embeds are replaced with their source code, if
+// it can be fetched. Tweets are replaced with generic placeholders, put
+// tweets transform before media to get tweet links.
+func (c *Clipper) convertMedia(ctx context.Context, doc *goquery.Document, _ *Params) error {
+	fetchCtx := ctx
+	if c.limits.Timeout > 0 {
+		var cancel context.CancelFunc
+		fetchCtx, cancel = context.WithTimeout(ctx, c.limits.Timeout)
+		defer cancel()
+	}
+	replaced := false
+	replace := func(s *goquery.Selection, e *mediaEmbed) {
+		s.ReplaceWithHtml(e.html())
+		replaced = true
+	}
+
+	doc.Find(`script[src*="gist.github.com/"]`).Each(func(_ int, s *goquery.Selection) {
+		if e := c.gistEmbed(fetchCtx, s.AttrOr("src", "")); e != nil {
+			replace(s, e)
+		}
+	})
+	doc.Find(".codepen[data-slug-hash]").Each(func(_ int, s *goquery.Selection) {
+		user, id := s.AttrOr("data-user", ""), s.AttrOr("data-slug-hash", "")
+		if user == "" || id == "" {
+			return
+		}
+		replace(s, c.codepenEmbed(fetchCtx, user, id, s.AttrOr("data-pen-title", "")))
+	})
+	doc.Find(`script[src*="codepen.io"]`).Remove()
+
+	doc.Find("iframe").Each(func(_ int, s *goquery.Selection) {
+		src := s.AttrOr("src", "")
+		if src == "" || src == "about:blank" {
+			src = s.AttrOr("data-src", "")
+		}
+		u, err := neturl.Parse(src)
+		if err != nil || u.Scheme != "http" && u.Scheme != "https" || !c.isMediaHost(u.Hostname()) {
+			return
+		}
+		replace(s, c.iframeEmbed(fetchCtx, u, s.AttrOr("title", "")))
+	})
+
+	doc.Find("video,audio").Each(func(_ int, s *goquery.Selection) {
+		e := &mediaEmbed{title: s.AttrOr("title", s.AttrOr("aria-label", ""))}
+		e.link = s.AttrOr("src", "")
+		if e.link == "" {
+			e.link = s.Find("source[src]").First().AttrOr("src", "")
+		}
+		if (e.link == "" || strings.HasPrefix(e.link, "blob:")) && doc.Url != nil {
+			e.link = doc.Url.String()
+		}
+		if goquery.NodeName(s) == "video" {
+			e.thumb = s.AttrOr("poster", "")
+			if e.title == "" {
+				e.title = "Video"
+			}
+		} else if e.title == "" {
+			e.title = "Audio"
+		}
+		replace(s, e)
+	})
+
+	if replaced {
+		doc.Find("head").AppendHtml(mediaStyle)
+	}
+	if ctx.Err() != nil {
+		return fmt.Errorf("context error: %w", ctx.Err())
+	}
+	return nil
+}
+
+// isMediaHost reports whether host is one of c media hosts or their
+// subdomain.
+func (c *Clipper) isMediaHost(host string) bool {
+	hosts := c.opts.MediaHosts
+	if hosts == nil {
+		hosts = DefaultMediaHosts
+	}
+	host = strings.ToLower(host)
+	for _, h := range hosts {
+		if host == h || strings.HasSuffix(host, "."+h) {
+			return true
+		}
+	}
+	return false
+}
+
+// iframeEmbed returns placeholder of iframe with URL u.
+func (c *Clipper) iframeEmbed(ctx context.Context, u *neturl.URL, title string) *mediaEmbed {
+	host := strings.TrimPrefix(strings.ToLower(u.Hostname()), "www.")
+	parts := strings.Split(strings.Trim(u.Path, "/"), "/")
+	e := &mediaEmbed{title: title, link: u.String()}
+	switch {
+	case (host == "youtube.com" || host == "youtube-nocookie.com") && len(parts) == 2 && parts[0] == "embed":
+		e.link = "https://www.youtube.com/watch?v=" + neturl.QueryEscape(parts[1])
+		e.thumb = "https://i.ytimg.com/vi/" + neturl.PathEscape(parts[1]) + "/hqdefault.jpg"
+		if e.title == "" {
+			e.title = "YouTube video"
+		}
+	case host == "player.vimeo.com" && len(parts) == 2 && parts[0] == "video":
+		e.link = "https://vimeo.com/" + neturl.PathEscape(parts[1])
+		if e.title == "" {
+			e.title = "Vimeo video"
+		}
+	case host == "codepen.io" && len(parts) >= 3 && parts[1] == "embed":
+		return c.codepenEmbed(ctx, parts[0], parts[len(parts)-1], title)
+	case e.title == "":
+		e.title = "Embedded content (" + u.Hostname() + ")"
+	}
+	return e
+}
+
+// gistEmbed returns placeholder of gist embedding script with URL src,
+// or nil, if src isn't gist script URL.
+func (c *Clipper) gistEmbed(ctx context.Context, src string) *mediaEmbed {
+	u, err := neturl.Parse(src)
+	if err != nil || !strings.HasSuffix(u.Path, ".js") {
+		return nil
+	}
+	path := strings.TrimSuffix(u.Path, ".js")
+	id := path[strings.LastIndexByte(path, '/')+1:]
+	if id == "" {
+		return nil
+	}
+	e := &mediaEmbed{title: "Gist " + id, link: "https://gist.github.com" + path}
+	var gist struct {
+		Description string `json:"description"`
+		Files       map[string]struct {
+			Language string `json:"language"`
+			Content  string `json:"content"`
+		} `json:"files"`
+	}
+	body, err := c.fetchText(ctx, gistAPIURL+neturl.PathEscape(id))
+	if err != nil || json.Unmarshal([]byte(body), &gist) != nil {
+		return e
+	}
+	if gist.Description != "" {
+		e.title = gist.Description
+	}
+	only := u.Query().Get("file")
+	names := make([]string, 0, len(gist.Files))
+	for name := range gist.Files {
+		if only == "" || name == only {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		f := gist.Files[name]
+		e.code = append(e.code, codeFile{name: name, lang: strings.ToLower(f.Language), text: f.Content})
+	}
+	return e
+}
+
+// codepenEmbed returns placeholder of pen with HTML, CSS and JS sources,
+// which could be fetched.
+func (c *Clipper) codepenEmbed(ctx context.Context, user, id, title string) *mediaEmbed {
+	path := neturl.PathEscape(user) + "/pen/" + neturl.PathEscape(id)
+	e := &mediaEmbed{title: title, link: "https://codepen.io/" + path}
+	if e.title == "" {
+		e.title = "CodePen " + id
+	}
+	for _, lang := range []string{"html", "css", "js"} {
+		text, err := c.fetchText(ctx, codepenSourceURL+path+"."+lang)
+		if err != nil {
+			break
+		}
+		if strings.TrimSpace(text) != "" {
+			e.code = append(e.code, codeFile{name: strings.ToUpper(lang), lang: lang, text: text})
+		}
+	}
+	return e
+}
+
+// fetchText returns body of url, which is fetched with c limits.
+func (c *Clipper) fetchText(ctx context.Context, url string) (string, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+	if err != nil {
+		return "", fmt.Errorf("http.NewRequestWithContext: %w", err)
+	}
+	req.Header.Set("user-agent", "clip-to-pdf/1.0")
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("http.Client.Do: %w", err)
+	}
+	defer func() { _ = resp.Body.Close() }()
+	if resp.StatusCode/200 != 1 {
+		return "", fmt.Errorf("%w: %d", ErrBadStatus, resp.StatusCode)
+	}
+	b, err := ioutil.ReadAll(limitBody(resp.Body, c.limits.MaxHTMLSize))
+	if err != nil {
+		return "", fmt.Errorf("ioutil.ReadAll: %w", err)
+	}
+	return string(b), nil
+}
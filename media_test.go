@@ -0,0 +1,144 @@
+package clip
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/PuerkitoBio/goquery"
+)
+
+func TestClipper_convertMedia(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/gists/abc":
+			_, _ = w.Write([]byte(`{"description": "Hello", "files": {
+				"main.go": {"language": "Go", "content": "package main\n"},
+				"README.md": {"language": "Markdown", "content": "# hi"}}}`))
+		case "/u/pen/p1.html":
+			_, _ = w.Write([]byte(`<p>pen</p>`))
+		case "/u/pen/p1.css":
+		case "/u/pen/p1.js":
+			_, _ = w.Write([]byte(`alert(1)`))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer srv.Close()
+	defer func(gist, codepen string) { gistAPIURL, codepenSourceURL = gist, codepen }(gistAPIURL, codepenSourceURL)
+	gistAPIURL, codepenSourceURL = srv.URL+"/gists/", srv.URL+"/"
+
+	doc, err := goquery.NewDocumentFromReader(strings.NewReader(`<html><head></head><body>
+		<script src="https://gist.github.com/user/abc.js?file=main.go"></script>
+		<script src="https://gist.github.com/user/none.js"></script>
+		<p class="codepen" data-user="u" data-slug-hash="p1" data-pen-title="Pen">See the pen</p>
+		<iframe src="https://www.youtube-nocookie.com/embed/vid1" title="Talk"></iframe>
+		<iframe src="about:blank" data-src="https://maps.example.com/m"></iframe>
+		<video poster="https://example.com/p.jpg"><source src="https://example.com/v.mp4"></video>
+		<audio src="blob:https://example.com/1"></audio>
+	</body></html>`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	doc.Url, _ = doc.Url.Parse("https://example.com/post")
+	c := NewClipper(ClipperOptions{})
+	if err = c.convertMedia(context.Background(), doc, &Params{}); err != nil {
+		t.Fatal(err)
+	}
+	left := doc.Find("iframe,video,audio,script")
+	if left.Length() != 1 || left.AttrOr("data-src", "") != "https://maps.example.com/m" {
+		t.Errorf("%d embeds left, want maps iframe only", left.Length())
+	}
+	figs := doc.Find("figure.clip-media")
+	if figs.Length() != 6 {
+		t.Fatalf("%d placeholders, want 6", figs.Length())
+	}
+	tests := []struct {
+		link, title, thumb, code string
+	}{
+		{"https://gist.github.com/user/abc", "Hello", "", "package main\n"},
+		{"https://gist.github.com/user/none", "Gist none", "", ""},
+		{"https://codepen.io/u/pen/p1", "Pen", "", "<p>pen</p>alert(1)"},
+		{"https://www.youtube.com/watch?v=vid1", "Talk", "https://i.ytimg.com/vi/vid1/hqdefault.jpg", ""},
+		{"https://example.com/v.mp4", "Video", "https://example.com/p.jpg", ""},
+	}
+	for i, tt := range tests {
+		fig := figs.Eq(i)
+		a := fig.Find("figcaption a")
+		if got := a.AttrOr("href", ""); got != tt.link {
+			t.Errorf("#%d link = %s, want %s", i, got, tt.link)
+		}
+		if got := a.Text(); got != tt.title {
+			t.Errorf("#%d title = %s, want %s", i, got, tt.title)
+		}
+		if got := fig.Find("img").AttrOr("src", ""); got != tt.thumb {
+			t.Errorf("#%d thumb = %s, want %s", i, got, tt.thumb)
+		}
+		if got := fig.Find("pre code").Text(); got != tt.code {
+			t.Errorf("#%d code = %q, want %q", i, got, tt.code)
+		}
+	}
+	if cls := figs.Eq(0).Find("code").AttrOr("class", ""); cls != "language-go" {
+		t.Errorf("gist code class = %s, want language-go", cls)
+	}
+	audio := doc.Find("figure.clip-media").Last().Find("figcaption a")
+	if audio.AttrOr("href", "") != "https://example.com/post" || audio.Text() != "Audio" {
+		t.Errorf("audio placeholder = %s %s", audio.AttrOr("href", ""), audio.Text())
+	}
+}
+
+func TestClipper_convertMedia_hosts(t *testing.T) {
+	const page = `<html><head></head><body>
+		<iframe src="https://maps.example.com/m"></iframe>
+		<iframe src="https://www.youtube.com/embed/vid1"></iframe>
+	</body></html>`
+	for _, tt := range []struct {
+		hosts []string
+		link  string
+	}{
+		{nil, "https://www.youtube.com/watch?v=vid1"},
+		{[]string{"example.com"}, "https://maps.example.com/m"},
+	} {
+		doc, err := goquery.NewDocumentFromReader(strings.NewReader(page))
+		if err != nil {
+			t.Fatal(err)
+		}
+		c := NewClipper(ClipperOptions{MediaHosts: tt.hosts})
+		if err = c.convertMedia(context.Background(), doc, &Params{}); err != nil {
+			t.Fatal(err)
+		}
+		figs := doc.Find("figure.clip-media figcaption a")
+		if figs.Length() != 1 || figs.AttrOr("href", "") != tt.link || doc.Find("iframe").Length() != 1 {
+			t.Errorf("hosts %v: %d placeholders (%s), want 1 (%s)", tt.hosts, figs.Length(), figs.AttrOr("href", ""), tt.link)
+		}
+	}
+}
+
+func TestClipper_convertMedia_tweets(t *testing.T) {
+	const page = `<html><head></head><body>
+		<iframe src="https://platform.twitter.com/embed/Tweet.html?id=42"></iframe>
+	</body></html>`
+	c := NewClipper(ClipperOptions{})
+	for _, tt := range []struct {
+		transforms  []string
+		tweet, figs int
+	}{
+		{[]string{"media"}, 0, 1},
+		{[]string{"tweets", "media"}, 1, 0},
+	} {
+		doc, err := goquery.NewDocumentFromReader(strings.NewReader(page))
+		if err != nil {
+			t.Fatal(err)
+		}
+		doc.Url, _ = doc.Url.Parse("https://example.com/post")
+		if err = c.transform(context.Background(), doc, &Params{Transforms: &tt.transforms}); err != nil {
+			t.Fatal(err)
+		}
+		tweets, figs := doc.Find("blockquote.twitter-tweet").Length(), doc.Find("figure.clip-media").Length()
+		if tweets != tt.tweet || figs != tt.figs {
+			t.Errorf("%v: %d tweets and %d placeholders, want %d and %d", tt.transforms, tweets, figs, tt.tweet, tt.figs)
+		}
+	}
+}
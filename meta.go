@@ -0,0 +1,230 @@
+package clip
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/PuerkitoBio/goquery"
+
+	"github.com/dinalt/clip/pdf"
+)
+
+// pageMeta is source page metadata.
+type pageMeta struct {
+	Title       string
+	Author      string
+	Description string
+	Keywords    string
+	Published   time.Time
+}
+
+type presetsKey struct{}
+
+// WithPresets returns context carrying names of presets used to build
+// Params. They are embedded into PDF metadata.
+func WithPresets(ctx context.Context, presets []string) context.Context {
+	var names []string
+	for _, v := range presets {
+		if v != "" {
+			names = append(names, v)
+		}
+	}
+	return context.WithValue(ctx, presetsKey{}, names)
+}
+
+func presetsFromContext(ctx context.Context) []string {
+	v, _ := ctx.Value(presetsKey{}).([]string)
+	return v
+}
+
+// extractMeta reads page metadata from og:/article: meta tags, JSON-LD
+// and common meta tags (in order of precedence).
+func extractMeta(doc *goquery.Document) *pageMeta {
+	res := &pageMeta{}
+	meta := func(attr, name string) string {
+		v, _ := doc.Find("meta[" + attr + "=\"" + name + "\"]").First().Attr("content")
+		return strings.TrimSpace(v)
+	}
+	first := func(vs ...string) string {
+		for _, v := range vs {
+			if v != "" {
+				return v
+			}
+		}
+		return ""
+	}
+	ld := extractJSONLD(doc)
+
+	res.Title = first(meta("property", "og:title"), ld.Headline,
+		meta("name", "twitter:title"), strings.TrimSpace(doc.Find("title").First().Text()))
+	res.Author = first(meta("property", "article:author"), ld.Author,
+		meta("name", "author"))
+	if strings.HasPrefix(res.Author, "http") && ld.Author != "" {
+		res.Author = ld.Author // article:author is often a profile URL
+	}
+	res.Description = first(meta("property", "og:description"), ld.Description,
+		meta("name", "description"))
+	res.Keywords = meta("name", "keywords")
+	res.Published = parseTime(first(meta("property", "article:published_time"),
+		ld.DatePublished, meta("name", "date"), meta("itemprop", "datePublished")))
+	return res
+}
+
+type jsonLD struct {
+	Headline      string
+	Description   string
+	Author        string
+	DatePublished string
+}
+
+// extractJSONLD reads first article-like object from JSON-LD scripts.
+func extractJSONLD(doc *goquery.Document) jsonLD {
+	var res jsonLD
+	doc.Find("script[type=\"application/ld+json\"]").EachWithBreak(func(_ int, sel *goquery.Selection) bool {
+		var v interface{}
+		if err := json.Unmarshal([]byte(sel.Text()), &v); err != nil {
+			return true
+		}
+		for _, obj := range ldObjects(v) {
+			if _, ok := obj["datePublished"]; !ok {
+				if _, ok := obj["headline"]; !ok {
+					continue
+				}
+			}
+			res.Headline = ldString(obj["headline"])
+			res.Description = ldString(obj["description"])
+			res.Author = ldString(obj["author"])
+			res.DatePublished = ldString(obj["datePublished"])
+			return false
+		}
+		return true
+	})
+	return res
+}
+
+// ldObjects flattens JSON-LD value (array, @graph container or object)
+// into list of objects.
+func ldObjects(v interface{}) []map[string]interface{} {
+	switch v := v.(type) {
+	case []interface{}:
+		var res []map[string]interface{}
+		for i := range v {
+			res = append(res, ldObjects(v[i])...)
+		}
+		return res
+	case map[string]interface{}:
+		if g, ok := v["@graph"]; ok {
+			return ldObjects(g)
+		}
+		return []map[string]interface{}{v}
+	}
+	return nil
+}
+
+// ldString returns string value of JSON-LD property: string itself,
+// name property of object or comma separated list for arrays.
+func ldString(v interface{}) string {
+	switch v := v.(type) {
+	case string:
+		return strings.TrimSpace(v)
+	case map[string]interface{}:
+		return ldString(v["name"])
+	case []interface{}:
+		var parts []string
+		for i := range v {
+			if s := ldString(v[i]); s != "" {
+				parts = append(parts, s)
+			}
+		}
+		return strings.Join(parts, ", ")
+	}
+	return ""
+}
+
+// parseTime parses date in one of commonly used formats. Zero time is
+// returned if s can't be parsed.
+func parseTime(s string) time.Time {
+	for _, layout := range []string{
+		time.RFC3339,
+		"2006-01-02T15:04:05Z0700",
+		"2006-01-02T15:04:05",
+		"2006-01-02 15:04:05",
+		"2006-01-02",
+		time.RFC1123Z,
+		time.RFC1123,
+	} {
+		if t, err := time.Parse(layout, s); err == nil {
+			return t
+		}
+	}
+	return time.Time{}
+}
+
+// postProcess writes page and clip metadata into PDF document and converts
+// it to PDF/A if requested by p. Data is returned as is if no post
+// processing is required.
+func postProcess(ctx context.Context, data []byte, url string, p *Params, m *pageMeta) ([]byte, error) {
+	archival := p.Archival != nil && *p.Archival
+	if !archival && (p.EmbedMetadata == nil || !*p.EmbedMetadata) {
+		return data, nil
+	}
+	doc, err := pdf.Parse(data)
+	if err != nil {
+		return nil, fmt.Errorf("pdf.Parse: %w", err)
+	}
+	if m == nil {
+		m = &pageMeta{}
+	}
+	md := &pdf.Metadata{
+		Title:     m.Title,
+		Author:    m.Author,
+		Subject:   m.Description,
+		Keywords:  m.Keywords,
+		Creator:   "clip",
+		Source:    url,
+		Published: m.Published,
+		Created:   time.Now(),
+	}
+	if p.Title != nil {
+		md.Title = *p.Title
+	}
+	if ps := presetsFromContext(ctx); len(ps) > 0 {
+		md.Custom = map[string]string{"presets": strings.Join(ps, ",")}
+	}
+	u := doc.Update()
+	if archival {
+		err = u.ConvertPDFA(md)
+	} else {
+		err = u.SetMetadata(md)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("pdf.Update: %w", err)
+	}
+	var b bytes.Buffer
+	_, err = u.WriteTo(&b)
+	if err != nil {
+		return nil, fmt.Errorf("pdf.Update.WriteTo: %w", err)
+	}
+	if !archival {
+		return b.Bytes(), nil
+	}
+
+	doc, err = pdf.Parse(b.Bytes())
+	if err != nil {
+		return nil, fmt.Errorf("pdf.Parse: %w", err)
+	}
+	if v := doc.CheckPDFA(); len(v) > 0 {
+		return nil, &ConformanceError{v}
+	}
+	return b.Bytes(), nil
+}
+
+// needMeta reports if source page metadata is used by post processing.
+func (p *Params) needMeta() bool {
+	return (p.EmbedMetadata != nil && *p.EmbedMetadata) ||
+		(p.Archival != nil && *p.Archival)
+}
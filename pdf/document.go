@@ -0,0 +1,328 @@
+package pdf
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"strconv"
+)
+
+// Document is parsed PDF file.
+type Document struct {
+	data      []byte
+	offsets   map[int]int // object number -> offset of object in data
+	startxref int
+	Trailer   Dict
+}
+
+// Parse parses cross-reference tables of PDF file from data.
+// Cross-reference streams are not supported.
+func Parse(data []byte) (*Document, error) {
+	i := bytes.LastIndex(data, []byte("startxref"))
+	if i < 0 {
+		return nil, fmt.Errorf("%w: startxref not found", ErrMalformed)
+	}
+	p := &parser{data: data, pos: i + len("startxref")}
+	startxref, err := strconv.Atoi(p.keyword())
+	if err != nil || startxref < 0 || startxref >= len(data) {
+		return nil, fmt.Errorf("%w: bad startxref value", ErrMalformed)
+	}
+
+	doc := &Document{
+		data:      data,
+		offsets:   make(map[int]int),
+		startxref: startxref,
+	}
+	seen := make(map[int]bool)
+	for off := startxref; ; {
+		if seen[off] {
+			return nil, fmt.Errorf("%w: xref loop", ErrMalformed)
+		}
+		seen[off] = true
+		trailer, err := doc.readXRef(off)
+		if err != nil {
+			return nil, err
+		}
+		if doc.Trailer == nil {
+			doc.Trailer = trailer
+		}
+		prev, ok := trailer["Prev"].(int)
+		if !ok {
+			break
+		}
+		off = prev
+	}
+	if _, ok := doc.Trailer["Root"].(Ref); !ok {
+		return nil, fmt.Errorf("%w: no document catalog", ErrMalformed)
+	}
+	return doc, nil
+}
+
+// readXRef reads xref section at offset off. Entries already read
+// (from later sections) are not overwritten.
+func (d *Document) readXRef(off int) (Dict, error) {
+	p := &parser{data: d.data, pos: off}
+	if kw := p.keyword(); kw != "xref" {
+		if _, err := strconv.Atoi(kw); err == nil {
+			return nil, fmt.Errorf("%w: cross-reference streams", ErrUnsupported)
+		}
+		return nil, p.errorf("xref expected")
+	}
+	for {
+		kw := p.keyword()
+		if kw == "trailer" {
+			break
+		}
+		start, err := strconv.Atoi(kw)
+		if err != nil {
+			return nil, p.errorf("bad xref subsection")
+		}
+		cnt, err := strconv.Atoi(p.keyword())
+		if err != nil {
+			return nil, p.errorf("bad xref subsection")
+		}
+		for i := 0; i < cnt; i++ {
+			offset, err1 := strconv.Atoi(p.keyword())
+			_, err2 := strconv.Atoi(p.keyword())
+			typ := p.keyword()
+			if err1 != nil || err2 != nil || (typ != "n" && typ != "f") {
+				return nil, p.errorf("bad xref entry")
+			}
+			if _, ok := d.offsets[start+i]; ok || typ != "n" {
+				continue
+			}
+			d.offsets[start+i] = offset
+		}
+	}
+	o, err := p.object()
+	if err != nil {
+		return nil, err
+	}
+	trailer, ok := o.(Dict)
+	if !ok {
+		return nil, p.errorf("trailer is not a dictionary")
+	}
+	return trailer, nil
+}
+
+// Refs returns references to all objects in document.
+func (d *Document) Refs() []Ref {
+	res := make([]Ref, 0, len(d.offsets))
+	for n := range d.offsets {
+		res = append(res, Ref{Num: n})
+	}
+	return res
+}
+
+// Object reads indirect object by reference.
+func (d *Document) Object(r Ref) (Object, error) {
+	off, ok := d.offsets[r.Num]
+	if !ok {
+		return nil, nil // reference to missing object is null
+	}
+	p := &parser{data: d.data, pos: off}
+	if kw := p.keyword(); kw != strconv.Itoa(r.Num) {
+		return nil, p.errorf("object %v expected", r)
+	}
+	p.keyword() // generation
+	if err := p.expect("obj"); err != nil {
+		return nil, err
+	}
+	o, err := p.object()
+	if err != nil {
+		return nil, err
+	}
+	dict, ok := o.(Dict)
+	if !ok {
+		return o, nil
+	}
+	save := p.pos
+	if p.keyword() != "stream" {
+		p.pos = save
+		return dict, nil
+	}
+	// stream keyword is followed by CRLF or LF
+	if p.pos < len(d.data) && d.data[p.pos] == '\r' {
+		p.pos++
+	}
+	if p.pos < len(d.data) && d.data[p.pos] == '\n' {
+		p.pos++
+	}
+	length, err := d.Resolve(dict["Length"])
+	if err != nil {
+		return nil, err
+	}
+	n, ok := length.(int)
+	if !ok || n < 0 || p.pos+n > len(d.data) {
+		return nil, p.errorf("bad stream length")
+	}
+	return &Stream{Dict: dict, Data: d.data[p.pos : p.pos+n]}, nil
+}
+
+// Resolve returns referenced object if o is Ref or o itself otherwise.
+func (d *Document) Resolve(o Object) (Object, error) {
+	r, ok := o.(Ref)
+	if !ok {
+		return o, nil
+	}
+	return d.Object(r)
+}
+
+// Catalog returns document catalog.
+func (d *Document) Catalog() (Dict, error) {
+	o, err := d.Object(d.Trailer["Root"].(Ref))
+	if err != nil {
+		return nil, err
+	}
+	cat, ok := o.(Dict)
+	if !ok {
+		return nil, fmt.Errorf("%w: catalog is not a dictionary", ErrMalformed)
+	}
+	return cat, nil
+}
+
+// Update returns new incremental update for document.
+func (d *Document) Update() *Update {
+	size, _ := d.Trailer["Size"].(int)
+	for n := range d.offsets {
+		if n >= size {
+			size = n + 1
+		}
+	}
+	return &Update{
+		doc:     d,
+		objects: make(map[int]Object),
+		size:    size,
+		Trailer: Dict{},
+	}
+}
+
+// Update is incremental update of document: objects added or replaced
+// are appended to the original file.
+type Update struct {
+	doc     *Document
+	objects map[int]Object
+	size    int
+	Trailer Dict // trailer entries to set
+
+	headerComment []byte // inserted after header line, forces full xref
+}
+
+// Add adds new object and returns reference to it.
+func (u *Update) Add(o Object) Ref {
+	r := Ref{Num: u.size}
+	u.size++
+	u.objects[r.Num] = o
+	return r
+}
+
+// Set replaces object referenced by r.
+func (u *Update) Set(r Ref, o Object) {
+	u.objects[r.Num] = o
+	if r.Num >= u.size {
+		u.size = r.Num + 1
+	}
+}
+
+// Object returns object by reference, taking into account objects
+// replaced by update.
+func (u *Update) Object(r Ref) (Object, error) {
+	if o, ok := u.objects[r.Num]; ok {
+		return o, nil
+	}
+	return u.doc.Object(r)
+}
+
+// Catalog returns (possibly already updated) document catalog.
+func (u *Update) Catalog() (Dict, error) {
+	o, err := u.Object(u.doc.Trailer["Root"].(Ref))
+	if err != nil {
+		return nil, err
+	}
+	cat, ok := o.(Dict)
+	if !ok {
+		return nil, fmt.Errorf("%w: catalog is not a dictionary", ErrMalformed)
+	}
+	return cat, nil
+}
+
+// SetCatalog replaces document catalog.
+func (u *Update) SetCatalog(cat Dict) {
+	u.Set(u.doc.Trailer["Root"].(Ref), cat)
+}
+
+// InsertHeaderComment inserts comment line c (including leading % and
+// trailing EOL) after file header line. Offsets of all original objects
+// are shifted, so update is written with complete cross-reference table.
+func (u *Update) InsertHeaderComment(c []byte) {
+	u.headerComment = c
+}
+
+// WriteTo writes original document followed by the update.
+func (u *Update) WriteTo(w io.Writer) (int64, error) {
+	buf := bytes.NewBuffer(make([]byte, 0, len(u.doc.data)+4096))
+	offsets := make(map[int]int, len(u.objects))
+	data := u.doc.data
+	if len(u.headerComment) > 0 {
+		eol := bytes.IndexByte(data, '\n') + 1
+		buf.Write(data[:eol])
+		buf.Write(u.headerComment)
+		data = data[eol:]
+		for n, off := range u.doc.offsets {
+			offsets[n] = off + len(u.headerComment)
+		}
+	}
+	buf.Write(data)
+	if len(data) > 0 && data[len(data)-1] != '\n' {
+		buf.WriteByte('\n')
+	}
+
+	for n := 0; n < u.size; n++ {
+		o, ok := u.objects[n]
+		if !ok {
+			continue
+		}
+		offsets[n] = buf.Len()
+		fmt.Fprintf(buf, "%d 0 obj\n", n)
+		writeObject(buf, o)
+		buf.WriteString("\nendobj\n")
+	}
+
+	xref := buf.Len()
+	buf.WriteString("xref\n")
+	if len(u.headerComment) > 0 {
+		buf.WriteString("0 1\n0000000000 65535 f\r\n")
+	}
+	for n := 0; n < u.size; {
+		if _, ok := offsets[n]; !ok {
+			n++
+			continue
+		}
+		end := n
+		for _, ok := offsets[end]; ok; _, ok = offsets[end] {
+			end++
+		}
+		fmt.Fprintf(buf, "%d %d\n", n, end-n)
+		for ; n < end; n++ {
+			fmt.Fprintf(buf, "%010d 00000 n\r\n", offsets[n])
+		}
+	}
+
+	trailer := Dict{}
+	for k, v := range u.doc.Trailer {
+		trailer[k] = v
+	}
+	for k, v := range u.Trailer {
+		trailer[k] = v
+	}
+	trailer["Size"] = u.size
+	delete(trailer, "Prev")
+	if len(u.headerComment) == 0 {
+		trailer["Prev"] = u.doc.startxref
+	}
+	buf.WriteString("trailer\n")
+	writeObject(buf, trailer)
+	fmt.Fprintf(buf, "\nstartxref\n%d\n%%%%EOF\n", xref)
+
+	return buf.WriteTo(w)
+}
@@ -0,0 +1,185 @@
+package pdf
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+)
+
+// minimalPDF builds single page PDF document with valid xref table.
+func minimalPDF(objs ...string) []byte {
+	var b bytes.Buffer
+	b.WriteString("%PDF-1.4\n")
+	offsets := make([]int, len(objs))
+	for i, o := range objs {
+		offsets[i] = b.Len()
+		fmt.Fprintf(&b, "%d 0 obj\n%s\nendobj\n", i+1, o)
+	}
+	xref := b.Len()
+	fmt.Fprintf(&b, "xref\n0 %d\n0000000000 65535 f\r\n", len(objs)+1)
+	for _, off := range offsets {
+		fmt.Fprintf(&b, "%010d 00000 n\r\n", off)
+	}
+	fmt.Fprintf(&b, "trailer\n<< /Size %d /Root 1 0 R /Info %d 0 R >>\nstartxref\n%d\n%%%%EOF\n",
+		len(objs)+1, len(objs), xref)
+	return b.Bytes()
+}
+
+func testDocument() []byte {
+	return minimalPDF(
+		"<< /Type /Catalog /Pages 2 0 R >>",
+		"<< /Type /Pages /Kids [3 0 R] /Count 1 >>",
+		"<< /Type /Page /Parent 2 0 R /MediaBox [0 0 595 842] /Contents 4 0 R >>",
+		"<< /Length 5 0 R >>\nstream\nBT ET\nendstream",
+		"5",
+		"<< /Producer (wkhtmltopdf \\(Qt\\)) /Title <FEFF0442> >>",
+	)
+}
+
+func TestParse(t *testing.T) {
+	doc, err := Parse(testDocument())
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+	o, err := doc.Object(Ref{Num: 4})
+	if err != nil {
+		t.Fatalf("Object() error = %v", err)
+	}
+	s, ok := o.(*Stream)
+	if !ok || string(s.Data) != "BT ET" {
+		t.Errorf("Object(4) = %#v, want stream with data %q", o, "BT ET")
+	}
+	o, _ = doc.Object(Ref{Num: 6})
+	info, _ := o.(Dict)
+	if info["Producer"] != String("wkhtmltopdf (Qt)") {
+		t.Errorf("Info.Producer = %q", info["Producer"])
+	}
+	if info["Title"] != String("\xfe\xff\x04\x42") {
+		t.Errorf("Info.Title = %q", info["Title"])
+	}
+	if _, err := Parse([]byte("%PDF-1.4\n")); err == nil {
+		t.Errorf("Parse() of truncated file: error expected")
+	}
+}
+
+func TestUpdate_SetMetadata(t *testing.T) {
+	doc, err := Parse(testDocument())
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+	u := doc.Update()
+	err = u.SetMetadata(&Metadata{
+		Title:     "Заголовок",
+		Source:    "https://example.com/?a=1&b=2",
+		Created:   time.Date(2020, 11, 5, 10, 0, 0, 0, time.UTC),
+		Published: time.Date(2020, 11, 1, 8, 30, 0, 0, time.UTC),
+		Custom:    map[string]string{"presets": "auto,margins:a4"},
+	})
+	if err != nil {
+		t.Fatalf("SetMetadata() error = %v", err)
+	}
+	var b bytes.Buffer
+	if _, err := u.WriteTo(&b); err != nil {
+		t.Fatalf("WriteTo() error = %v", err)
+	}
+
+	doc, err = Parse(b.Bytes())
+	if err != nil {
+		t.Fatalf("Parse() of updated document error = %v", err)
+	}
+	o, _ := doc.Resolve(doc.Trailer["Info"])
+	info, _ := o.(Dict)
+	if info["Title"] != TextString("Заголовок") {
+		t.Errorf("Info.Title = %q", info["Title"])
+	}
+	if info["Producer"] != String("wkhtmltopdf (Qt)") {
+		t.Errorf("Info.Producer was not preserved: %q", info["Producer"])
+	}
+	if info["CreationDate"] != String("D:20201105100000Z") {
+		t.Errorf("Info.CreationDate = %q", info["CreationDate"])
+	}
+	cat, err := doc.Catalog()
+	if err != nil {
+		t.Fatalf("Catalog() error = %v", err)
+	}
+	if cat["Pages"] != (Ref{Num: 2}) {
+		t.Errorf("Catalog.Pages = %v", cat["Pages"])
+	}
+	o, _ = doc.Resolve(cat["Metadata"])
+	xmp, ok := o.(*Stream)
+	if !ok {
+		t.Fatalf("Catalog.Metadata = %#v, want stream", o)
+	}
+	for _, want := range []string{
+		"<dc:source>https://example.com/?a=1&amp;b=2</dc:source>",
+		"<clip:presets>auto,margins:a4</clip:presets>",
+		">Заголовок</rdf:li>",
+		"<dc:date><rdf:Seq><rdf:li>2020-11-01T08:30:00Z</rdf:li></rdf:Seq></dc:date>",
+	} {
+		if !strings.Contains(string(xmp.Data), want) {
+			t.Errorf("XMP packet doesn't contain %q", want)
+		}
+	}
+}
+
+func TestUpdate_ConvertPDFA(t *testing.T) {
+	data := minimalPDF(
+		"<< /Type /Catalog /Pages 2 0 R /OpenAction << /S /JavaScript /JS (print\\(\\)) >> >>",
+		"<< /Type /Pages /Kids [3 0 R] /Count 1 >>",
+		"<< /Type /Page /Parent 2 0 R /MediaBox [0 0 595 842] /Annots [4 0 R] "+
+			"/Resources << /Font << /F1 5 0 R >> >> >>",
+		"<< /Type /Annot /Subtype /Link /Rect [0 0 10 10] /A 6 0 R >>",
+		"<< /Type /Font /Subtype /TrueType /BaseFont /Arial /FontDescriptor 7 0 R >>",
+		"<< /S /JavaScript /JS (alert\\(1\\)) >>",
+		"<< /Type /FontDescriptor /FontName /Arial /FontFile2 8 0 R >>",
+		"<< /Length 0 >>\nstream\n\nendstream",
+		"<< /Producer (Qt 4.8.7) >>",
+	)
+	doc, err := Parse(data)
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+	if v := doc.CheckPDFA(); len(v) == 0 {
+		t.Errorf("CheckPDFA() of source document: violations expected")
+	}
+	u := doc.Update()
+	err = u.ConvertPDFA(&Metadata{Title: "Title", Custom: map[string]string{"presets": "auto"}})
+	if err != nil {
+		t.Fatalf("ConvertPDFA() error = %v", err)
+	}
+	var b bytes.Buffer
+	if _, err := u.WriteTo(&b); err != nil {
+		t.Fatalf("WriteTo() error = %v", err)
+	}
+	doc, err = Parse(b.Bytes())
+	if err != nil {
+		t.Fatalf("Parse() of converted document error = %v", err)
+	}
+	if v := doc.CheckPDFA(); len(v) != 0 {
+		t.Errorf("CheckPDFA() of converted document = %q, want no violations", v)
+	}
+	o, _ := doc.Resolve(doc.Trailer["Info"])
+	if info := o.(Dict); info["Producer"] != String("Qt 4.8.7") {
+		t.Errorf("Info.Producer = %q", info["Producer"])
+	}
+	cat, _ := doc.Catalog()
+	o, _ = doc.Resolve(cat["Metadata"])
+	for _, want := range []string{"<pdfaid:part>2</pdfaid:part>", "<pdf:Producer>Qt 4.8.7</pdf:Producer>",
+		"<pdfaSchema:prefix>clip</pdfaSchema:prefix>"} {
+		if !strings.Contains(string(o.(*Stream).Data), want) {
+			t.Errorf("XMP packet doesn't contain %q", want)
+		}
+	}
+}
+
+func TestSRGBProfile(t *testing.T) {
+	p := SRGBProfile()
+	if n := int(p[0])<<24 | int(p[1])<<16 | int(p[2])<<8 | int(p[3]); n != len(p) {
+		t.Errorf("profile size in header = %d, want %d", n, len(p))
+	}
+	if string(p[36:40]) != "acsp" {
+		t.Errorf("profile signature = %q", p[36:40])
+	}
+}
@@ -0,0 +1,93 @@
+package pdf
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math"
+)
+
+// SRGBIdentifier is registered name of sRGB output condition.
+const SRGBIdentifier = "sRGB IEC61966-2.1"
+
+// SRGBProfile returns ICC v2 display profile for sRGB color space
+// (D50 adapted primaries and sampled sRGB tone curve).
+func SRGBProfile() []byte {
+	type tag struct {
+		sig  string
+		data []byte
+	}
+	s15 := func(v float64) uint32 { return uint32(int32(math.Round(v * 65536))) }
+	xyz := func(x, y, z float64) []byte {
+		b := make([]byte, 20)
+		copy(b, "XYZ ")
+		binary.BigEndian.PutUint32(b[8:], s15(x))
+		binary.BigEndian.PutUint32(b[12:], s15(y))
+		binary.BigEndian.PutUint32(b[16:], s15(z))
+		return b
+	}
+	text := func(s string) []byte {
+		return append(append([]byte("text\x00\x00\x00\x00"), s...), 0)
+	}
+	desc := func(s string) []byte {
+		var b bytes.Buffer
+		b.WriteString("desc\x00\x00\x00\x00")
+		_ = binary.Write(&b, binary.BigEndian, uint32(len(s)+1))
+		b.WriteString(s)
+		b.WriteByte(0)
+		b.Write(make([]byte, 4+4+2+1+67)) // empty unicode and scriptcode descriptions
+		return b.Bytes()
+	}
+	const points = 1024
+	trc := make([]byte, 12+points*2)
+	copy(trc, "curv")
+	binary.BigEndian.PutUint32(trc[8:], points)
+	for i := 0; i < points; i++ {
+		v := float64(i) / (points - 1)
+		if v <= 0.04045 {
+			v /= 12.92
+		} else {
+			v = math.Pow((v+0.055)/1.055, 2.4)
+		}
+		binary.BigEndian.PutUint16(trc[12+i*2:], uint16(math.Round(v*65535)))
+	}
+
+	tags := []tag{
+		{"desc", desc(SRGBIdentifier)},
+		{"cprt", text("No copyright, use freely")},
+		{"wtpt", xyz(0.9642, 1, 0.8249)},
+		{"rXYZ", xyz(0.4361, 0.2225, 0.0139)},
+		{"gXYZ", xyz(0.3851, 0.7169, 0.0971)},
+		{"bXYZ", xyz(0.1431, 0.0606, 0.7141)},
+		{"rTRC", trc},
+		{"gTRC", trc},
+		{"bTRC", trc},
+	}
+
+	const headerSize = 128
+	offset := headerSize + 4 + len(tags)*12
+	var table, data bytes.Buffer
+	_ = binary.Write(&table, binary.BigEndian, uint32(len(tags)))
+	for _, t := range tags {
+		table.WriteString(t.sig)
+		_ = binary.Write(&table, binary.BigEndian, uint32(offset+data.Len()))
+		_ = binary.Write(&table, binary.BigEndian, uint32(len(t.data)))
+		data.Write(t.data)
+		for data.Len()%4 != 0 {
+			data.WriteByte(0)
+		}
+	}
+
+	h := make([]byte, headerSize)
+	binary.BigEndian.PutUint32(h[0:], uint32(headerSize+table.Len()+data.Len()))
+	binary.BigEndian.PutUint32(h[8:], 0x02100000) // version 2.1
+	copy(h[12:], "mntrRGB XYZ ")
+	binary.BigEndian.PutUint16(h[24:], 2000) // creation date: 2000-01-01
+	binary.BigEndian.PutUint16(h[26:], 1)
+	binary.BigEndian.PutUint16(h[28:], 1)
+	copy(h[36:], "acsp")
+	binary.BigEndian.PutUint32(h[68:], s15(0.9642)) // D50 illuminant
+	binary.BigEndian.PutUint32(h[72:], s15(1))
+	binary.BigEndian.PutUint32(h[76:], s15(0.8249))
+
+	return append(append(h, table.Bytes()...), data.Bytes()...)
+}
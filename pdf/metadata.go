@@ -0,0 +1,235 @@
+package pdf
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ClipNS is XMP namespace for clip specific properties.
+const ClipNS = "https://github.com/dinalt/clip/ns/1.0/"
+
+// Metadata is document metadata written to Info dictionary and XMP packet.
+type Metadata struct {
+	Title     string
+	Author    string
+	Subject   string
+	Keywords  string
+	Creator   string
+	Producer  string
+	Source    string    // source URL (dc:source)
+	Published time.Time // original publication date
+	Created   time.Time
+	Custom    map[string]string // additional properties (Info entries and clip:<key> XMP properties)
+
+	PDFAPart        int    // PDF/A part identification (pdfaid:part), not written if zero
+	PDFAConformance string // PDF/A conformance level (pdfaid:conformance)
+}
+
+// SetMetadata writes m into document Info dictionary and XMP metadata
+// stream, referenced from catalog. Values missed in m are taken from
+// existing Info dictionary, so Info and XMP stay consistent.
+func (u *Update) SetMetadata(m *Metadata) error {
+	info := Dict{}
+	if r, ok := u.doc.Trailer["Info"].(Ref); ok {
+		o, err := u.Object(r)
+		if err != nil {
+			return err
+		}
+		if d, ok := o.(Dict); ok {
+			for k, v := range d {
+				info[k] = v
+			}
+		}
+	}
+	m = m.withDefaults(info)
+	if m.PDFAPart > 0 {
+		// PDF/A requires every Info entry to have XMP equivalent
+		for k := range info {
+			if !standardInfoKeys[k] {
+				delete(info, k)
+			}
+		}
+	}
+	setText := func(k Name, v string) {
+		if v != "" {
+			info[k] = TextString(v)
+		}
+	}
+	setText("Title", m.Title)
+	setText("Author", m.Author)
+	setText("Subject", m.Subject)
+	setText("Keywords", m.Keywords)
+	setText("Creator", m.Creator)
+	setText("Producer", m.Producer)
+	if !m.Created.IsZero() {
+		info["CreationDate"] = String(infoDate(m.Created))
+		info["ModDate"] = String(infoDate(m.Created))
+	}
+	if m.PDFAPart == 0 {
+		setText("Source", m.Source)
+		if !m.Published.IsZero() {
+			info["Published"] = String(infoDate(m.Published))
+		}
+		for k, v := range m.Custom {
+			setText(Name(k), v)
+		}
+	}
+	u.Trailer["Info"] = u.Add(info)
+
+	cat, err := u.Catalog()
+	if err != nil {
+		return err
+	}
+	xmp := &Stream{
+		Dict: Dict{"Type": Name("Metadata"), "Subtype": Name("XML")},
+		Data: m.xmp(),
+	}
+	cat["Metadata"] = u.Add(xmp)
+	u.SetCatalog(cat)
+	return nil
+}
+
+var standardInfoKeys = map[Name]bool{
+	"Title": true, "Author": true, "Subject": true, "Keywords": true,
+	"Creator": true, "Producer": true, "CreationDate": true, "ModDate": true,
+}
+
+// withDefaults returns copy of m with empty fields filled from Info
+// dictionary values.
+func (m *Metadata) withDefaults(info Dict) *Metadata {
+	res := *m
+	text := func(dst *string, k Name) {
+		if s, ok := info[k].(String); ok && *dst == "" {
+			*dst = DecodeText(s)
+		}
+	}
+	text(&res.Title, "Title")
+	text(&res.Author, "Author")
+	text(&res.Subject, "Subject")
+	text(&res.Keywords, "Keywords")
+	text(&res.Creator, "Creator")
+	text(&res.Producer, "Producer")
+	if s, ok := info["CreationDate"].(String); ok && res.Created.IsZero() {
+		res.Created = parseInfoDate(string(s))
+	}
+	return &res
+}
+
+// parseInfoDate parses PDF date string. Zero time is returned on failure.
+func parseInfoDate(s string) time.Time {
+	s = strings.TrimPrefix(s, "D:")
+	s = strings.ReplaceAll(strings.TrimSuffix(s, "'"), "'", "")
+	layouts := []string{"20060102150405Z0700", "20060102150405Z07", "20060102150405Z",
+		"20060102150405", "200601021504", "2006010215", "20060102"}
+	for _, l := range layouts {
+		if t, err := time.Parse(l, s); err == nil {
+			return t
+		}
+	}
+	return time.Time{}
+}
+
+// infoDate formats t as PDF date string.
+func infoDate(t time.Time) string {
+	_, offset := t.Zone()
+	sign := '+'
+	if offset < 0 {
+		sign = '-'
+		offset = -offset
+	}
+	if offset == 0 {
+		return t.Format("D:20060102150405Z")
+	}
+	return fmt.Sprintf("D:%s%c%02d'%02d'", t.Format("20060102150405"),
+		sign, offset/3600, offset%3600/60)
+}
+
+// xmp returns XMP packet for metadata.
+func (m *Metadata) xmp() []byte {
+	var b bytes.Buffer
+	esc := func(s string) string {
+		var eb bytes.Buffer
+		_ = xml.EscapeText(&eb, []byte(s))
+		return eb.String()
+	}
+	prop := func(name, v string) {
+		if v != "" {
+			fmt.Fprintf(&b, "<%s>%s</%s>\n", name, esc(v), name)
+		}
+	}
+	b.WriteString("<?xpacket begin=\"\xef\xbb\xbf\" id=\"W5M0MpCehiHzreSzNTczkc9d\"?>\n" +
+		"<x:xmpmeta xmlns:x=\"adobe:ns:meta/\">\n" +
+		"<rdf:RDF xmlns:rdf=\"http://www.w3.org/1999/02/22-rdf-syntax-ns#\">\n" +
+		"<rdf:Description rdf:about=\"\"" +
+		" xmlns:dc=\"http://purl.org/dc/elements/1.1/\"" +
+		" xmlns:xmp=\"http://ns.adobe.com/xap/1.0/\"" +
+		" xmlns:pdf=\"http://ns.adobe.com/pdf/1.3/\"" +
+		" xmlns:pdfaid=\"http://www.aiim.org/pdfa/ns/id/\"" +
+		" xmlns:pdfaExtension=\"http://www.aiim.org/pdfa/ns/extension/\"" +
+		" xmlns:pdfaSchema=\"http://www.aiim.org/pdfa/ns/schema#\"" +
+		" xmlns:pdfaProperty=\"http://www.aiim.org/pdfa/ns/property#\"" +
+		" xmlns:clip=\"" + ClipNS + "\">\n")
+	if m.PDFAPart > 0 {
+		prop("pdfaid:part", strconv.Itoa(m.PDFAPart))
+		prop("pdfaid:conformance", m.PDFAConformance)
+	}
+	if m.Title != "" {
+		fmt.Fprintf(&b, "<dc:title><rdf:Alt><rdf:li xml:lang=\"x-default\">%s</rdf:li></rdf:Alt></dc:title>\n",
+			esc(m.Title))
+	}
+	if m.Author != "" {
+		fmt.Fprintf(&b, "<dc:creator><rdf:Seq><rdf:li>%s</rdf:li></rdf:Seq></dc:creator>\n",
+			esc(m.Author))
+	}
+	if m.Subject != "" {
+		fmt.Fprintf(&b, "<dc:description><rdf:Alt><rdf:li xml:lang=\"x-default\">%s</rdf:li></rdf:Alt></dc:description>\n",
+			esc(m.Subject))
+	}
+	prop("dc:source", m.Source)
+	if !m.Published.IsZero() { // dc:date is ordered array
+		fmt.Fprintf(&b, "<dc:date><rdf:Seq><rdf:li>%s</rdf:li></rdf:Seq></dc:date>\n",
+			m.Published.Format(time.RFC3339))
+	}
+	prop("pdf:Keywords", m.Keywords)
+	prop("pdf:Producer", m.Producer)
+	prop("xmp:CreatorTool", m.Creator)
+	if !m.Created.IsZero() {
+		prop("xmp:CreateDate", m.Created.Format(time.RFC3339))
+		prop("xmp:ModifyDate", m.Created.Format(time.RFC3339))
+		prop("xmp:MetadataDate", m.Created.Format(time.RFC3339))
+	}
+	keys := make([]string, 0, len(m.Custom))
+	for k := range m.Custom {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		prop("clip:"+k, m.Custom[k])
+	}
+	if m.PDFAPart > 0 && len(keys) > 0 {
+		// PDF/A requires description of non-predefined schemas
+		b.WriteString("<pdfaExtension:schemas><rdf:Bag><rdf:li rdf:parseType=\"Resource\">\n" +
+			"<pdfaSchema:schema>clip</pdfaSchema:schema>\n" +
+			"<pdfaSchema:namespaceURI>" + ClipNS + "</pdfaSchema:namespaceURI>\n" +
+			"<pdfaSchema:prefix>clip</pdfaSchema:prefix>\n" +
+			"<pdfaSchema:property><rdf:Seq>\n")
+		for _, k := range keys {
+			fmt.Fprintf(&b, "<rdf:li rdf:parseType=\"Resource\">"+
+				"<pdfaProperty:name>%s</pdfaProperty:name>"+
+				"<pdfaProperty:valueType>Text</pdfaProperty:valueType>"+
+				"<pdfaProperty:category>external</pdfaProperty:category>"+
+				"<pdfaProperty:description>%s</pdfaProperty:description></rdf:li>\n", esc(k), esc(k))
+		}
+		b.WriteString("</rdf:Seq></pdfaSchema:property>\n</rdf:li></rdf:Bag></pdfaExtension:schemas>\n")
+	}
+	b.WriteString("</rdf:Description>\n</rdf:RDF>\n</x:xmpmeta>\n")
+	// padding allows in-place editing by other tools
+	b.Write(bytes.Repeat([]byte(" "), 2048))
+	b.WriteString("\n<?xpacket end=\"w\"?>")
+	return b.Bytes()
+}
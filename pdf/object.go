@@ -0,0 +1,153 @@
+// Package pdf is a minimal PDF post-processor. It is able to read objects
+// from documents with classic cross-reference tables (such as produced by
+// wkhtmltopdf) and to append incremental updates to them.
+package pdf
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+	"strconv"
+	"unicode/utf16"
+)
+
+// Object is any PDF object: nil (null), bool, int, float64, Name,
+// String, Array, Dict, Ref or *Stream.
+type Object interface{}
+
+// Name is PDF name object (without leading slash).
+type Name string
+
+// String is PDF string object (raw bytes).
+type String string
+
+// Array is PDF array object.
+type Array []Object
+
+// Dict is PDF dictionary object.
+type Dict map[Name]Object
+
+// Ref is indirect object reference.
+type Ref struct {
+	Num int
+	Gen int
+}
+
+// String is fmt.Stringer implementation.
+func (r Ref) String() string {
+	return fmt.Sprintf("%d %d R", r.Num, r.Gen)
+}
+
+// Stream is PDF stream object. Data is stored as is (not decoded).
+type Stream struct {
+	Dict Dict
+	Data []byte
+}
+
+// writeObject serializes o to buf.
+func writeObject(buf *bytes.Buffer, o Object) {
+	switch v := o.(type) {
+	case nil:
+		buf.WriteString("null")
+	case bool:
+		buf.WriteString(strconv.FormatBool(v))
+	case int:
+		buf.WriteString(strconv.Itoa(v))
+	case float64:
+		buf.WriteString(strconv.FormatFloat(v, 'f', -1, 64))
+	case Name:
+		writeName(buf, v)
+	case String:
+		writeString(buf, v)
+	case Ref:
+		buf.WriteString(v.String())
+	case Array:
+		buf.WriteByte('[')
+		for i := range v {
+			if i > 0 {
+				buf.WriteByte(' ')
+			}
+			writeObject(buf, v[i])
+		}
+		buf.WriteByte(']')
+	case Dict:
+		keys := make([]string, 0, len(v))
+		for k := range v {
+			keys = append(keys, string(k))
+		}
+		sort.Strings(keys)
+		buf.WriteString("<<")
+		for _, k := range keys {
+			writeName(buf, Name(k))
+			buf.WriteByte(' ')
+			writeObject(buf, v[Name(k)])
+		}
+		buf.WriteString(">>")
+	case *Stream:
+		d := make(Dict, len(v.Dict)+1)
+		for k, val := range v.Dict {
+			d[k] = val
+		}
+		d["Length"] = len(v.Data)
+		writeObject(buf, d)
+		buf.WriteString("\nstream\n")
+		buf.Write(v.Data)
+		buf.WriteString("\nendstream")
+	default:
+		panic(fmt.Sprintf("pdf.writeObject: unsupported type %T", o))
+	}
+}
+
+func writeName(buf *bytes.Buffer, n Name) {
+	buf.WriteByte('/')
+	for i := 0; i < len(n); i++ {
+		c := n[i]
+		if c < '!' || c > '~' || isDelimiter(c) || c == '#' {
+			fmt.Fprintf(buf, "#%02X", c)
+			continue
+		}
+		buf.WriteByte(c)
+	}
+}
+
+// writeString writes s as hex string, which doesn't require escaping.
+func writeString(buf *bytes.Buffer, s String) {
+	fmt.Fprintf(buf, "<%X>", []byte(s))
+}
+
+// TextString encodes s as PDF text string: as is for ASCII and as
+// UTF-16BE with byte order mark otherwise.
+func TextString(s string) String {
+	ascii := true
+	for i := 0; i < len(s); i++ {
+		if s[i] >= 0x80 {
+			ascii = false
+			break
+		}
+	}
+	if ascii {
+		return String(s)
+	}
+	b := []byte{0xfe, 0xff}
+	for _, c := range utf16.Encode([]rune(s)) {
+		b = append(b, byte(c>>8), byte(c))
+	}
+	return String(b)
+}
+
+// DecodeText decodes PDF text string: UTF-16BE if s starts with byte order
+// mark, PDFDocEncoding (treated as Latin-1) otherwise.
+func DecodeText(s String) string {
+	if len(s) >= 2 && s[0] == 0xfe && s[1] == 0xff {
+		u := make([]uint16, 0, len(s)/2)
+		for i := 2; i+1 < len(s); i += 2 {
+			u = append(u, uint16(s[i])<<8|uint16(s[i+1]))
+		}
+		return string(utf16.Decode(u))
+	}
+	r := make([]rune, len(s))
+	for i := 0; i < len(s); i++ {
+		r[i] = rune(s[i])
+	}
+	return string(r)
+}
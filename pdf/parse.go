@@ -0,0 +1,266 @@
+package pdf
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"strconv"
+)
+
+// Package errors.
+var (
+	ErrMalformed   = errors.New("malformed PDF")
+	ErrUnsupported = errors.New("unsupported PDF feature")
+)
+
+func isWhitespace(c byte) bool {
+	switch c {
+	case 0, '\t', '\n', '\f', '\r', ' ':
+		return true
+	}
+	return false
+}
+
+func isDelimiter(c byte) bool {
+	switch c {
+	case '(', ')', '<', '>', '[', ']', '{', '}', '/', '%':
+		return true
+	}
+	return false
+}
+
+// parser reads PDF objects from data starting at pos.
+type parser struct {
+	data []byte
+	pos  int
+}
+
+func (p *parser) errorf(format string, v ...interface{}) error {
+	return fmt.Errorf("%w: offset %d: %s", ErrMalformed, p.pos, fmt.Sprintf(format, v...))
+}
+
+func (p *parser) skipSpace() {
+	for p.pos < len(p.data) {
+		c := p.data[p.pos]
+		switch {
+		case isWhitespace(c):
+			p.pos++
+		case c == '%':
+			for p.pos < len(p.data) && p.data[p.pos] != '\n' && p.data[p.pos] != '\r' {
+				p.pos++
+			}
+		default:
+			return
+		}
+	}
+}
+
+// keyword reads regular characters sequence.
+func (p *parser) keyword() string {
+	p.skipSpace()
+	start := p.pos
+	for p.pos < len(p.data) && !isWhitespace(p.data[p.pos]) && !isDelimiter(p.data[p.pos]) {
+		p.pos++
+	}
+	return string(p.data[start:p.pos])
+}
+
+// expect reads keyword and checks it is equal to kw.
+func (p *parser) expect(kw string) error {
+	if got := p.keyword(); got != kw {
+		return p.errorf("%q expected, got %q", kw, got)
+	}
+	return nil
+}
+
+// object reads next object. Indirect references are recognized.
+func (p *parser) object() (Object, error) {
+	p.skipSpace()
+	if p.pos >= len(p.data) {
+		return nil, p.errorf("unexpected end of data")
+	}
+	switch c := p.data[p.pos]; {
+	case c == '/':
+		return p.name(), nil
+	case c == '(':
+		return p.literalString()
+	case c == '<' && p.pos+1 < len(p.data) && p.data[p.pos+1] == '<':
+		return p.dict()
+	case c == '<':
+		return p.hexString()
+	case c == '[':
+		return p.array()
+	}
+
+	kw := p.keyword()
+	switch kw {
+	case "":
+		return nil, p.errorf("unexpected character %q", p.data[p.pos])
+	case "null":
+		return nil, nil
+	case "true":
+		return true, nil
+	case "false":
+		return false, nil
+	}
+	n, err := strconv.Atoi(kw)
+	if err != nil {
+		f, err := strconv.ParseFloat(kw, 64)
+		if err != nil {
+			return nil, p.errorf("unexpected keyword %q", kw)
+		}
+		return f, nil
+	}
+	// look ahead for "<gen> R"
+	save := p.pos
+	if gen, err := strconv.Atoi(p.keyword()); err == nil && p.keyword() == "R" {
+		return Ref{n, gen}, nil
+	}
+	p.pos = save
+	return n, nil
+}
+
+func (p *parser) name() Name {
+	p.pos++ // skip slash
+	var b []byte
+	for p.pos < len(p.data) && !isWhitespace(p.data[p.pos]) && !isDelimiter(p.data[p.pos]) {
+		c := p.data[p.pos]
+		if c == '#' && p.pos+2 < len(p.data) {
+			if v, err := strconv.ParseUint(string(p.data[p.pos+1:p.pos+3]), 16, 8); err == nil {
+				b = append(b, byte(v))
+				p.pos += 3
+				continue
+			}
+		}
+		b = append(b, c)
+		p.pos++
+	}
+	return Name(b)
+}
+
+func (p *parser) literalString() (String, error) {
+	p.pos++ // skip (
+	var (
+		b     []byte
+		depth = 1
+	)
+	for p.pos < len(p.data) {
+		c := p.data[p.pos]
+		p.pos++
+		switch c {
+		case '(':
+			depth++
+		case ')':
+			depth--
+			if depth == 0 {
+				return String(b), nil
+			}
+		case '\\':
+			if p.pos >= len(p.data) {
+				return "", p.errorf("unterminated string")
+			}
+			c = p.data[p.pos]
+			p.pos++
+			switch c {
+			case 'n':
+				c = '\n'
+			case 'r':
+				c = '\r'
+			case 't':
+				c = '\t'
+			case 'b':
+				c = '\b'
+			case 'f':
+				c = '\f'
+			case '\r':
+				if p.pos < len(p.data) && p.data[p.pos] == '\n' {
+					p.pos++
+				}
+				continue
+			case '\n':
+				continue
+			}
+			if c >= '0' && c <= '7' {
+				v := int(c - '0')
+				for i := 0; i < 2 && p.pos < len(p.data) &&
+					p.data[p.pos] >= '0' && p.data[p.pos] <= '7'; i++ {
+					v = v*8 + int(p.data[p.pos]-'0')
+					p.pos++
+				}
+				c = byte(v)
+			}
+		}
+		b = append(b, c)
+	}
+	return "", p.errorf("unterminated string")
+}
+
+func (p *parser) hexString() (String, error) {
+	end := bytes.IndexByte(p.data[p.pos:], '>')
+	if end < 0 {
+		return "", p.errorf("unterminated hex string")
+	}
+	var digits []byte
+	for _, c := range p.data[p.pos+1 : p.pos+end] {
+		if !isWhitespace(c) {
+			digits = append(digits, c)
+		}
+	}
+	if len(digits)%2 == 1 {
+		digits = append(digits, '0')
+	}
+	b := make([]byte, len(digits)/2)
+	for i := range b {
+		v, err := strconv.ParseUint(string(digits[i*2:i*2+2]), 16, 8)
+		if err != nil {
+			return "", p.errorf("bad hex string")
+		}
+		b[i] = byte(v)
+	}
+	p.pos += end + 1
+	return String(b), nil
+}
+
+func (p *parser) array() (Array, error) {
+	p.pos++ // skip [
+	res := Array{}
+	for {
+		p.skipSpace()
+		if p.pos >= len(p.data) {
+			return nil, p.errorf("unterminated array")
+		}
+		if p.data[p.pos] == ']' {
+			p.pos++
+			return res, nil
+		}
+		o, err := p.object()
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, o)
+	}
+}
+
+func (p *parser) dict() (Dict, error) {
+	p.pos += 2 // skip <<
+	res := Dict{}
+	for {
+		p.skipSpace()
+		if p.pos+1 >= len(p.data) {
+			return nil, p.errorf("unterminated dictionary")
+		}
+		if p.data[p.pos] == '>' && p.data[p.pos+1] == '>' {
+			p.pos += 2
+			return res, nil
+		}
+		if p.data[p.pos] != '/' {
+			return nil, p.errorf("name expected as dictionary key")
+		}
+		k := p.name()
+		v, err := p.object()
+		if err != nil {
+			return nil, err
+		}
+		res[k] = v
+	}
+}
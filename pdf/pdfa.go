@@ -0,0 +1,293 @@
+package pdf
+
+import (
+	"bytes"
+	"crypto/md5" //nolint:gosec // used for document identifier, not for security
+	"fmt"
+	"sort"
+)
+
+// Annotation flags.
+const (
+	annotInvisible = 1
+	annotHidden    = 2
+	annotPrint     = 4
+	annotNoView    = 32
+)
+
+// ConvertPDFA makes document PDF/A-2b compliant as far as it can be done
+// without re-rendering: writes m as metadata with PDF/A identification,
+// adds sRGB output intent and document ID, sets print flag on annotations
+// and strips JavaScript and additional actions. Fonts are not embedded
+// and transparency is not flattened here, it would require re-rendering:
+// wkhtmltopdf embeds fonts it uses, CheckPDFA reports fonts it didn't, so
+// such documents stay non-conforming. Transparency is allowed by PDF/A-2
+// and is neither changed nor checked.
+func (u *Update) ConvertPDFA(m *Metadata) error {
+	ma := *m
+	ma.PDFAPart = 2
+	ma.PDFAConformance = "B"
+	err := u.SetMetadata(&ma)
+	if err != nil {
+		return err
+	}
+
+	for _, r := range u.doc.Refs() {
+		o, err := u.Object(r)
+		if err != nil {
+			return err
+		}
+		d, ok := o.(Dict)
+		if !ok {
+			continue
+		}
+		if d["S"] == Name("JavaScript") {
+			u.Set(r, nil) // referenced action becomes null (no action)
+			continue
+		}
+		if stripActive(d) {
+			u.Set(r, d)
+		}
+	}
+
+	cat, err := u.Catalog()
+	if err != nil {
+		return err
+	}
+	profile := u.Add(&Stream{Dict: Dict{"N": 3}, Data: SRGBProfile()})
+	cat["OutputIntents"] = Array{Dict{
+		"Type":                      Name("OutputIntent"),
+		"S":                         Name("GTS_PDFA1"),
+		"OutputConditionIdentifier": String(SRGBIdentifier),
+		"Info":                      String(SRGBIdentifier),
+		"RegistryName":              String("http://www.color.org"),
+		"DestOutputProfile":         profile,
+	}}
+	u.SetCatalog(cat)
+
+	if nl := bytes.IndexByte(u.doc.data, '\n'); nl < 0 || !binaryComment(u.doc.data[nl:]) {
+		u.InsertHeaderComment([]byte("%\xe2\xe3\xcf\xd3\n"))
+	}
+	if _, ok := u.doc.Trailer["ID"]; !ok {
+		sum := md5.Sum(u.doc.data) //nolint:gosec
+		u.Trailer["ID"] = Array{String(sum[:]), String(sum[:])}
+	}
+	return nil
+}
+
+// stripActive removes JavaScript and additional actions from d and nested
+// direct objects and fixes annotation flags. Reports if d was changed.
+func stripActive(d Dict) bool {
+	var changed bool
+	if isAnnot(d) && d["Subtype"] != Name("Popup") {
+		f, _ := d["F"].(int)
+		if nf := (f | annotPrint) &^ (annotInvisible | annotHidden | annotNoView); nf != f {
+			d["F"] = nf
+			changed = true
+		}
+	}
+	for _, k := range []Name{"A", "OpenAction"} {
+		if a, ok := d[k].(Dict); ok && a["S"] == Name("JavaScript") {
+			delete(d, k)
+			changed = true
+		}
+	}
+	for _, k := range []Name{"AA", "JavaScript", "JS"} {
+		if _, ok := d[k]; ok {
+			delete(d, k)
+			changed = true
+		}
+	}
+	for _, v := range d {
+		changed = stripActiveObject(v) || changed
+	}
+	return changed
+}
+
+func stripActiveObject(o Object) bool {
+	switch v := o.(type) {
+	case Dict:
+		return stripActive(v)
+	case Array:
+		var changed bool
+		for i := range v {
+			changed = stripActiveObject(v[i]) || changed
+		}
+		return changed
+	}
+	return false
+}
+
+func isAnnot(d Dict) bool {
+	if d["Type"] == Name("Annot") {
+		return true
+	}
+	_, hasRect := d["Rect"]
+	_, hasSubtype := d["Subtype"].(Name)
+	return d["Type"] == nil && hasRect && hasSubtype
+}
+
+// CheckPDFA reports violations of PDF/A-2b requirements found in document.
+// It checks only the subset of requirements, which are relevant for
+// wkhtmltopdf output and can be checked without content streams parsing.
+func (d *Document) CheckPDFA() []string {
+	var res []string
+	report := func(format string, v ...interface{}) {
+		res = append(res, fmt.Sprintf(format, v...))
+	}
+
+	if !bytes.HasPrefix(d.data, []byte("%PDF-1.")) || len(d.data) < 8 ||
+		d.data[7] < '0' || d.data[7] > '7' {
+		report("file header: PDF version 1.0-1.7 required")
+	}
+	if nl := bytes.IndexAny(d.data, "\r\n"); nl < 0 || !binaryComment(d.data[nl:]) {
+		report("file header: binary comment is missing")
+	}
+	if _, ok := d.Trailer["Encrypt"]; ok {
+		report("trailer: encryption is not allowed")
+	}
+	if _, ok := d.Trailer["ID"].(Array); !ok {
+		report("trailer: document ID is missing")
+	}
+
+	cat, err := d.Catalog()
+	if err != nil {
+		return append(res, err.Error())
+	}
+	o, _ := d.Resolve(cat["Metadata"])
+	if s, ok := o.(*Stream); !ok || !bytes.Contains(s.Data, []byte("pdfaid:part")) {
+		report("catalog: XMP metadata with PDF/A identification is missing")
+	}
+	if !d.hasOutputIntent(cat) {
+		report("catalog: PDF/A output intent is missing")
+	}
+
+	refs := d.Refs()
+	sort.Slice(refs, func(i, j int) bool { return refs[i].Num < refs[j].Num })
+	for _, r := range refs {
+		o, err := d.Object(r)
+		if err != nil {
+			report("object %v: %v", r, err)
+			continue
+		}
+		var dict Dict
+		switch v := o.(type) {
+		case Dict:
+			dict = v
+		case *Stream:
+			dict = v.Dict
+			if _, ok := v.Dict["F"]; ok {
+				report("object %v: external stream", r)
+			}
+			if hasFilter(v.Dict, "LZWDecode") {
+				report("object %v: LZWDecode filter", r)
+			}
+		default:
+			continue
+		}
+		for _, msg := range checkDict(dict) {
+			report("object %v: %s", r, msg)
+		}
+	}
+	return res
+}
+
+// binaryComment reports if b (starting from end of header line) contains
+// comment with at least four bytes above 127.
+func binaryComment(b []byte) bool {
+	b = bytes.TrimLeft(b, "\r\n")
+	if len(b) < 5 || b[0] != '%' {
+		return false
+	}
+	for _, c := range b[1:5] {
+		if c <= 127 {
+			return false
+		}
+	}
+	return true
+}
+
+func (d *Document) hasOutputIntent(cat Dict) bool {
+	o, _ := d.Resolve(cat["OutputIntents"])
+	intents, _ := o.(Array)
+	for _, i := range intents {
+		o, _ := d.Resolve(i)
+		if oi, ok := o.(Dict); ok && oi["S"] == Name("GTS_PDFA1") && oi["DestOutputProfile"] != nil {
+			return true
+		}
+	}
+	return false
+}
+
+func hasFilter(d Dict, name Name) bool {
+	switch f := d["Filter"].(type) {
+	case Name:
+		return f == name
+	case Array:
+		for _, v := range f {
+			if v == name {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func checkDict(d Dict) []string {
+	var res []string
+	if d["S"] == Name("JavaScript") {
+		res = append(res, "JavaScript action")
+	}
+	for _, k := range []Name{"AA", "JS"} {
+		if _, ok := d[k]; ok {
+			res = append(res, fmt.Sprintf("%s entry is not allowed", k))
+		}
+	}
+	if isAnnot(d) && d["Subtype"] != Name("Popup") {
+		f, _ := d["F"].(int)
+		if f&annotPrint == 0 || f&(annotInvisible|annotHidden|annotNoView) != 0 {
+			res = append(res, "annotation flags: print flag required")
+		}
+	}
+	switch d["Type"] {
+	case Name("FontDescriptor"):
+		_, f1 := d["FontFile"]
+		_, f2 := d["FontFile2"]
+		_, f3 := d["FontFile3"]
+		if !f1 && !f2 && !f3 {
+			res = append(res, fmt.Sprintf("font %s is not embedded", nameOf(d["FontName"])))
+		}
+	case Name("Font"):
+		switch d["Subtype"] {
+		case Name("Type0"), Name("Type3"):
+		default:
+			if _, ok := d["FontDescriptor"]; !ok {
+				res = append(res, fmt.Sprintf("font %s is not embedded", nameOf(d["BaseFont"])))
+			}
+		}
+	}
+	for _, v := range d {
+		res = append(res, checkObject(v)...)
+	}
+	return res
+}
+
+// checkObject checks direct objects nested in dictionary.
+func checkObject(o Object) []string {
+	switch v := o.(type) {
+	case Dict:
+		return checkDict(v)
+	case Array:
+		var res []string
+		for i := range v {
+			res = append(res, checkObject(v[i])...)
+		}
+		return res
+	}
+	return nil
+}
+
+func nameOf(o Object) Name {
+	n, _ := o.(Name)
+	return n
+}
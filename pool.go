@@ -0,0 +1,383 @@
+package clip
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/SebastiaanKlippert/go-wkhtmltopdf"
+)
+
+// ErrPoolClosed is returned by RendererPool.Acquire after pool is closed.
+var ErrPoolClosed = errors.New("renderer pool is closed")
+
+// PoolOptions configure RendererPool.
+type PoolOptions struct {
+	Bin       string // wkhtmltopdf executable (looked up by go-wkhtmltopdf if empty)
+	Size      int    // max concurrent renders
+	Warm      bool   // keep pre-started wkhtmltopdf processes (batch mode)
+	MaxJobs   int    // jobs rendered by warm process before it's recycled, 0 - no limit
+	MaxMemory int64  // warm process RSS limit (bytes) checked after each job (Linux only), 0 - no limit
+}
+
+// RendererPool limits count of concurrent renders. In warm mode each pool
+// worker keeps long-lived wkhtmltopdf process, which reads jobs from stdin
+// (--read-args-from-stdin), so Qt initialization cost is paid once. Dead
+// processes are restarted, and processes are recycled after MaxJobs jobs
+// or when they grow beyond MaxMemory.
+type RendererPool struct {
+	opts    PoolOptions
+	bin     string
+	workers chan *Worker
+	closeC  chan struct{}
+}
+
+// Worker is renderer pool slot. Use WithWorker to render with it.
+type Worker struct {
+	pool *RendererPool
+	proc *warmProcess // nil if pool isn't warm or process failed to start
+}
+
+// NewRendererPool creates pool and starts warm processes if opts.Warm is set.
+func NewRendererPool(opts PoolOptions) (*RendererPool, error) {
+	if opts.Size < 1 {
+		return nil, errors.New("clip.NewRendererPool: pool size should be positive")
+	}
+	p := &RendererPool{
+		opts:    opts,
+		workers: make(chan *Worker, opts.Size),
+		closeC:  make(chan struct{}),
+	}
+	if opts.Warm {
+		p.bin = opts.Bin
+		if p.bin == "" {
+			_, err := wkhtmltopdf.NewPDFGenerator()
+			if err != nil {
+				return nil, fmt.Errorf("wkhtmltopdf.NewPDFGenerator: %w", err)
+			}
+			p.bin = wkhtmltopdf.GetPath()
+		}
+	}
+	for i := 0; i < opts.Size; i++ {
+		w := &Worker{pool: p}
+		if opts.Warm {
+			w.proc, _ = startWarmProcess(p.bin) // retried on release
+		}
+		p.workers <- w
+	}
+	return p, nil
+}
+
+// Acquire waits for free worker. Worker must be returned with Release.
+func (p *RendererPool) Acquire(ctx context.Context) (*Worker, error) {
+	select {
+	case <-ctx.Done():
+		return nil, fmt.Errorf("context error: %w", ctx.Err())
+	case <-p.closeC:
+		return nil, ErrPoolClosed
+	case w := <-p.workers:
+		return w, nil
+	}
+}
+
+// Release returns worker to pool, restarting or recycling its process
+// if needed.
+func (p *RendererPool) Release(w *Worker) {
+	if p.opts.Warm {
+		if w.proc != nil && (!w.proc.alive() || w.proc.exhausted(p.opts)) {
+			w.proc.stop()
+			w.proc = nil
+		}
+		if w.proc == nil {
+			w.proc, _ = startWarmProcess(p.bin)
+		}
+	}
+	p.workers <- w
+}
+
+// Close waits for all workers to be released and stops warm processes.
+func (p *RendererPool) Close() error {
+	close(p.closeC)
+	for i := 0; i < p.opts.Size; i++ {
+		w := <-p.workers
+		if w.proc != nil {
+			w.proc.stop()
+		}
+	}
+	return nil
+}
+
+type workerKey struct{}
+
+// WithWorker returns ctx, which makes ToPDFCtx render with pool worker w.
+func WithWorker(ctx context.Context, w *Worker) context.Context {
+	return context.WithValue(ctx, workerKey{}, w)
+}
+
+func workerFromContext(ctx context.Context) *Worker {
+	w, _ := ctx.Value(workerKey{}).(*Worker)
+	return w
+}
+
+// canRun reports if job could be passed to warm process: it should be
+// the same executable, and arguments should fit into single line.
+func (w *Worker) canRun(bin string, args []string) bool {
+	if w == nil || w.proc == nil || bin != w.pool.bin || !w.proc.alive() {
+		return false
+	}
+	for _, a := range args {
+		if strings.ContainsAny(a, "\r\n") {
+			return false
+		}
+	}
+	return true
+}
+
+// warmProcess is wkhtmltopdf running in --read-args-from-stdin mode.
+type warmProcess struct {
+	cmd    *exec.Cmd
+	stdin  io.WriteCloser
+	lines  chan string   // stderr lines
+	exited chan struct{} // closed when process exits
+	jobs   int
+}
+
+func startWarmProcess(bin string) (*warmProcess, error) {
+	cmd := exec.Command(bin, "--read-args-from-stdin") //nolint:gosec
+	setProcessGroup(cmd)
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, fmt.Errorf("exec.Cmd.StdinPipe: %w", err)
+	}
+	stderr, err := cmd.StderrPipe()
+	if err != nil {
+		return nil, fmt.Errorf("exec.Cmd.StderrPipe: %w", err)
+	}
+	err = cmd.Start()
+	if err != nil {
+		return nil, fmt.Errorf("exec.Cmd.Start: %w", err)
+	}
+	wp := &warmProcess{
+		cmd:    cmd,
+		stdin:  stdin,
+		lines:  make(chan string, 256),
+		exited: make(chan struct{}),
+	}
+	go func() {
+		sc := bufio.NewScanner(stderr)
+		sc.Split(scanLinesCR)
+		for sc.Scan() {
+			select {
+			case wp.lines <- sc.Text():
+			default: // nobody listens, drop
+			}
+		}
+		_ = cmd.Wait()
+		close(wp.exited)
+	}()
+	return wp, nil
+}
+
+func (wp *warmProcess) alive() bool {
+	select {
+	case <-wp.exited:
+		return false
+	default:
+		return true
+	}
+}
+
+// exhausted reports if process should be recycled.
+func (wp *warmProcess) exhausted(opts PoolOptions) bool {
+	if opts.MaxJobs > 0 && wp.jobs >= opts.MaxJobs {
+		return true
+	}
+	if opts.MaxMemory > 0 {
+		if rss, ok := processRSS(wp.cmd.Process.Pid); ok && rss > opts.MaxMemory {
+			return true
+		}
+	}
+	return false
+}
+
+// stop closes process stdin (so it exits after current job), and kills
+// it if it doesn't exit in time.
+func (wp *warmProcess) stop() {
+	_ = wp.stdin.Close()
+	select {
+	case <-wp.exited:
+	case <-time.After(5 * time.Second):
+		wp.kill()
+	}
+}
+
+func (wp *warmProcess) kill() {
+	killProcessGroup(wp.cmd)
+	<-wp.exited
+}
+
+// tailInterval is period of output file polling by warmProcess.render.
+const tailInterval = 50 * time.Millisecond
+
+// render passes job to process. Input and output "-" arguments are replaced
+// by temporary files. Output file is copied to stdout while the job is
+// rendered, so output is streamed, and process is killed if stdout write
+// fails. Semantics (errors, timeout) are the same as of runRenderer.
+func (wp *warmProcess) render(ctx context.Context, args []string, stdin io.Reader, stdout io.Writer,
+	timeout time.Duration) error {
+	for len(wp.lines) > 0 { // skip output of previous job
+		<-wp.lines
+	}
+	wp.jobs++
+
+	dir, err := ioutil.TempDir("", "clip-job-")
+	if err != nil {
+		return fmt.Errorf("ioutil.TempDir: %w", err)
+	}
+	defer func() { _ = os.RemoveAll(dir) }()
+	in, out := dir+string(os.PathSeparator)+"in.html", dir+string(os.PathSeparator)+"out"
+	if stdin != nil {
+		f, err := os.Create(in)
+		if err != nil {
+			return fmt.Errorf("os.Create: %w", err)
+		}
+		_, err = io.Copy(f, stdin)
+		if cerr := f.Close(); err == nil {
+			err = cerr
+		}
+		if err != nil {
+			return fmt.Errorf("write input: %w", err)
+		}
+	}
+	line := make([]string, 0, len(args)+2)
+	line = append(line, "--log-level", "info") // "Done" line marks job end
+	for i, a := range args {
+		switch {
+		case a == "-" && i == len(args)-1:
+			a = out
+		case a == "-":
+			a = in
+		}
+		line = append(line, quoteArg(a))
+	}
+	_, err = io.WriteString(wp.stdin, strings.Join(line, " ")+"\n")
+	if err != nil {
+		return fmt.Errorf("write job: %w", err)
+	}
+
+	var timeoutC <-chan time.Time
+	if timeout > 0 {
+		t := time.NewTimer(timeout)
+		defer t.Stop()
+		timeoutC = t.C
+	}
+	var f *os.File // output file, opened when it's created
+	defer func() {
+		if f != nil {
+			_ = f.Close()
+		}
+	}()
+	tail := func() error {
+		if f == nil {
+			var err error
+			if f, err = os.Open(out); err != nil {
+				return nil // not created yet
+			}
+		}
+		if _, err := io.Copy(stdout, f); err != nil {
+			return fmt.Errorf("io.Copy: %w", err)
+		}
+		return nil
+	}
+	ticker := time.NewTicker(tailInterval)
+	defer ticker.Stop()
+	var msgs []string
+	for done := false; !done; {
+		select {
+		case <-ticker.C:
+			if err := tail(); err != nil {
+				wp.kill()
+				return err
+			}
+		case l := <-wp.lines:
+			l = strings.TrimSpace(l)
+			switch {
+			case l == "Done":
+				done = true
+			case strings.HasPrefix(l, "Warning:"), strings.HasPrefix(l, "Error:"),
+				strings.HasPrefix(l, "Exit with code"):
+				msgs = append(msgs, l)
+			}
+		case <-wp.exited:
+			msgs = append(msgs, "renderer exited")
+			done = true
+		case <-ctx.Done():
+			wp.kill()
+			return fmt.Errorf("context error: %w", ctx.Err())
+		case <-timeoutC:
+			wp.kill()
+			return fmt.Errorf("%w: warm renderer killed after %v", ErrRenderTimeout, timeout)
+		}
+	}
+
+	if err := tail(); err != nil {
+		return err
+	}
+	if len(msgs) > 0 {
+		return errors.New(strings.Join(msgs, "\n"))
+	}
+	return nil
+}
+
+// quoteArg quotes command line argument for wkhtmltopdf stdin arguments
+// parser.
+func quoteArg(a string) string {
+	if a != "" && !strings.ContainsAny(a, " \t\"'\\") {
+		return a
+	}
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(a) + `"`
+}
+
+// scanLinesCR is bufio.SplitFunc splitting by \n and \r (progress bar
+// updates are separated by \r).
+func scanLinesCR(data []byte, atEOF bool) (advance int, token []byte, err error) {
+	if i := bytes.IndexAny(data, "\r\n"); i >= 0 {
+		return i + 1, data[:i], nil
+	}
+	if atEOF && len(data) > 0 {
+		return len(data), data, nil
+	}
+	return 0, nil, nil
+}
+
+// processRSS returns resident set size of process from procfs.
+func processRSS(pid int) (int64, bool) {
+	b, err := ioutil.ReadFile("/proc/" + strconv.Itoa(pid) + "/status")
+	if err != nil {
+		return 0, false
+	}
+	for _, l := range strings.Split(string(b), "\n") {
+		if !strings.HasPrefix(l, "VmRSS:") {
+			continue
+		}
+		fs := strings.Fields(strings.TrimPrefix(l, "VmRSS:"))
+		if len(fs) == 0 {
+			return 0, false
+		}
+		kb, err := strconv.ParseInt(fs[0], 10, 64)
+		if err != nil {
+			return 0, false
+		}
+		return kb << 10, true
+	}
+	return 0, false
+}
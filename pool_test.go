@@ -0,0 +1,127 @@
+package clip
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io/ioutil"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fakeBatchRenderer imitates wkhtmltopdf --read-args-from-stdin: for each
+// line it copies input (second to last argument) to output (last one).
+const fakeBatchRenderer = `#!/bin/sh
+while read -r line; do
+	eval "set -- $line"
+	for a; do in="$out"; out="$a"; done
+	case "$(cat "$in")" in
+	*hang*) sleep 10 ;;
+	*stream*) printf head > "$out"; sleep 10 ;;
+	*fail*) echo "Exit with code 1 due to network error" >&2; exit 1 ;;
+	esac
+	printf 'Loading pages (1/6)\r[====] 100%%\n' >&2
+	cat "$in" > "$out"
+	echo Done >&2
+done
+`
+
+func Test_warmProcess(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("requires sh")
+	}
+	bin := filepath.Join(t.TempDir(), "renderer")
+	if err := ioutil.WriteFile(bin, []byte(fakeBatchRenderer), 0755); err != nil { //nolint:gosec
+		t.Fatal(err)
+	}
+	wp, err := startWarmProcess(bin)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer wp.stop()
+
+	for _, in := range []string{"first", "second with \"quotes\" and 'spaces'"} {
+		var out bytes.Buffer
+		err = wp.render(context.Background(), []string{"--title", in, "page", "-", "-"}, strings.NewReader(in), &out, 0)
+		if err != nil || out.String() != in {
+			t.Errorf("render() = %v, output %q, want %q", err, out.String(), in)
+		}
+	}
+	if !wp.alive() || wp.jobs != 2 {
+		t.Errorf("process alive = %v, jobs = %d", wp.alive(), wp.jobs)
+	}
+	if !wp.exhausted(PoolOptions{MaxJobs: 2}) || wp.exhausted(PoolOptions{MaxJobs: 3}) {
+		t.Error("exhausted() doesn't respect MaxJobs")
+	}
+
+	err = wp.render(context.Background(), []string{"page", "-", "-"}, strings.NewReader("hang"), ioutil.Discard,
+		100*time.Millisecond)
+	if !errors.Is(err, ErrRenderTimeout) || wp.alive() {
+		t.Errorf("render() of hanging job = %v, process alive = %v", err, wp.alive())
+	}
+
+	// output is streamed while job is rendered, failed write kills process
+	wp, err = startWarmProcess(bin)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sw := &failWriter{}
+	start := time.Now()
+	err = wp.render(context.Background(), []string{"page", "-", "-"}, strings.NewReader("stream"), sw, 0)
+	if !errors.Is(err, errWriteFailed) || sw.buf.String() != "head" || time.Since(start) > 5*time.Second || wp.alive() {
+		t.Errorf("render() with failed write = %v, output %q, process alive = %v", err, sw.buf.String(), wp.alive())
+	}
+
+	wp, err = startWarmProcess(bin)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = wp.render(context.Background(), []string{"page", "-", "-"}, strings.NewReader("fail"), ioutil.Discard, 0)
+	if err == nil || !strings.Contains(err.Error(), "Exit with code 1") {
+		t.Errorf("render() of failed job = %v", err)
+	}
+	if wp.alive() {
+		t.Error("failed process is alive")
+	}
+}
+
+var errWriteFailed = errors.New("write failed")
+
+// failWriter keeps the first write and fails.
+type failWriter struct {
+	buf bytes.Buffer
+}
+
+func (w *failWriter) Write(p []byte) (int, error) {
+	_, _ = w.buf.Write(p)
+	return 0, errWriteFailed
+}
+
+func TestRendererPool(t *testing.T) {
+	p, err := NewRendererPool(PoolOptions{Size: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	w, err := p.Acquire(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if _, err = p.Acquire(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Acquire() from empty pool = %v", err)
+	}
+	if w.canRun("wkhtmltopdf", nil) {
+		t.Error("worker of cold pool can run jobs")
+	}
+	p.Release(w)
+	if err = p.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = p.Acquire(context.Background()); !errors.Is(err, ErrPoolClosed) {
+		t.Errorf("Acquire() from closed pool = %v", err)
+	}
+}
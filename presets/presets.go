@@ -11,8 +11,8 @@ import (
 )
 
 type preset struct {
-	URLRegexp string `json:"url_regexp,omitempty"`
-	*regexp.Regexp
+	URLRegexp string         `json:"url_regexp,omitempty"`
+	Regexp    *regexp.Regexp `json:"-"` // not embedded: promoted UnmarshalText would break preset decoding
 	*clip.Params
 }
 
@@ -27,6 +27,22 @@ func FromJSONFile(file string) (Presets, error) {
 	return FromJSON(f)
 }
 
+// FromJSONFiles reads and merges presets files. Presets of later files
+// replace presets with the same name of earlier ones.
+func FromJSONFiles(files ...string) (Presets, error) {
+	res := make(Presets)
+	for _, file := range files {
+		ps, err := FromJSONFile(file)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", file, err)
+		}
+		for k, v := range ps {
+			res[k] = v
+		}
+	}
+	return res, nil
+}
+
 func FromJSON(r io.Reader) (Presets, error) {
 	var res map[string]preset
 
@@ -34,6 +50,15 @@ func FromJSON(r io.Reader) (Presets, error) {
 	if err != nil {
 		return nil, fmt.Errorf("json.Decoder.Decode: %w", err)
 	}
+	for name, v := range res {
+		if v.Params == nil || v.Rewrite == nil {
+			continue
+		}
+		err = clip.ValidateRewrite(*v.Rewrite)
+		if err != nil {
+			return nil, fmt.Errorf("preset %s: %w", name, err)
+		}
+	}
 	return res, nil
 }
 
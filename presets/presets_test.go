@@ -1,7 +1,11 @@
 package presets
 
 import (
+	"errors"
 	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
 	"reflect"
 	"strings"
 	"testing"
@@ -39,6 +43,18 @@ func TestFromJSON(t *testing.T) {
 			},
 			false,
 		},
+		{
+			"invalid rewrite rule",
+			args{
+				strings.NewReader(`{
+					"test": {
+						"rewrite": [{"selector": "div[", "action": "unwrap"}]
+					}
+				}`),
+			},
+			nil,
+			true,
+		},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
@@ -54,6 +70,32 @@ func TestFromJSON(t *testing.T) {
 	}
 }
 
+func TestFromJSONFiles(t *testing.T) {
+	dir, err := ioutil.TempDir("", "clip-presets-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = os.RemoveAll(dir) }()
+	a, b := filepath.Join(dir, "a.json"), filepath.Join(dir, "b.json")
+	_ = ioutil.WriteFile(a, []byte(`{"x": {"query": "a"}, "y": {"query": "a"}}`), 0600)
+	_ = ioutil.WriteFile(b, []byte(`{"y": {"query": "b"}}`), 0600)
+
+	got, err := FromJSONFiles(a, b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if q := *got.ByName("x").Query; q != "a" {
+		t.Errorf("x query = %s, want a", q)
+	}
+	if q := *got.ByName("y").Query; q != "b" {
+		t.Errorf("y query = %s, want b (later file wins)", q)
+	}
+	_, err = FromJSONFiles(a, filepath.Join(dir, "none.json"))
+	if !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("FromJSONFiles() error = %v, want os.ErrNotExist", err)
+	}
+}
+
 func newParams(query string, pageWidth uint, enableJavascript bool) *clip.Params {
 	return &clip.Params{
 		Query:            &query,
@@ -0,0 +1,143 @@
+package clip
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+
+	"github.com/SebastiaanKlippert/go-wkhtmltopdf"
+)
+
+// Preview defaults and limits.
+const (
+	DefaultPreviewDPI = 24
+	MaxPreviewDPI     = 300  // max preview resolution
+	MaxPreviewSize    = 4096 // max preview width and height, px
+	defaultMargin     = 10   // wkhtmltopdf default margin, mm
+	cssDPI            = 96
+	mmPerInch         = 25.4
+)
+
+// pageSizes are page dimensions (width, height) in millimeters.
+var pageSizes = map[string][2]float64{
+	wkhtmltopdf.PageSizeA0:        {841, 1189},
+	wkhtmltopdf.PageSizeA1:        {594, 841},
+	wkhtmltopdf.PageSizeA2:        {420, 594},
+	wkhtmltopdf.PageSizeA3:        {297, 420},
+	wkhtmltopdf.PageSizeA4:        {210, 297},
+	wkhtmltopdf.PageSizeA5:        {148, 210},
+	wkhtmltopdf.PageSizeA6:        {105, 148},
+	wkhtmltopdf.PageSizeA7:        {74, 105},
+	wkhtmltopdf.PageSizeA8:        {52, 74},
+	wkhtmltopdf.PageSizeA9:        {37, 52},
+	wkhtmltopdf.PageSizeB0:        {1000, 1414},
+	wkhtmltopdf.PageSizeB1:        {707, 1000},
+	wkhtmltopdf.PageSizeB2:        {500, 707},
+	wkhtmltopdf.PageSizeB3:        {353, 500},
+	wkhtmltopdf.PageSizeB4:        {250, 353},
+	wkhtmltopdf.PageSizeB5:        {176, 250},
+	wkhtmltopdf.PageSizeB6:        {125, 176},
+	wkhtmltopdf.PageSizeB7:        {88, 125},
+	wkhtmltopdf.PageSizeB8:        {62, 88},
+	wkhtmltopdf.PageSizeB9:        {33, 62},
+	wkhtmltopdf.PageSizeB10:       {31, 44},
+	wkhtmltopdf.PageSizeC5E:       {163, 229},
+	wkhtmltopdf.PageSizeComm10E:   {105, 241},
+	wkhtmltopdf.PageSizeDLE:       {110, 220},
+	wkhtmltopdf.PageSizeExecutive: {190.5, 254},
+	wkhtmltopdf.PageSizeFolio:     {210, 330},
+	wkhtmltopdf.PageSizeLedger:    {431.8, 279.4},
+	wkhtmltopdf.PageSizeLegal:     {215.9, 355.6},
+	wkhtmltopdf.PageSizeLetter:    {215.9, 279.4},
+	wkhtmltopdf.PageSizeTabloid:   {279.4, 431.8},
+}
+
+// pageGeometry returns page size and margins (top, right, bottom, left)
+// in millimeters for PDF generated with p.
+func (p *Params) pageGeometry() (size [2]float64, margins [4]float64) {
+	size = pageSizes[wkhtmltopdf.PageSizeA4]
+	if p.PageSize != nil {
+		if s, ok := pageSizes[*p.PageSize]; ok {
+			size = s
+		}
+	}
+	if p.PageWidth != nil && *p.PageWidth > 0 {
+		size[0] = float64(*p.PageWidth)
+	}
+	if p.PageHeight != nil && *p.PageHeight > 0 {
+		size[1] = float64(*p.PageHeight)
+	}
+	if p.Orientation != nil && *p.Orientation == wkhtmltopdf.OrientationLandscape {
+		size[0], size[1] = size[1], size[0]
+	}
+	for i, m := range []*uint{p.MarginTop, p.MarginRight, p.MarginBottom, p.MarginLeft} {
+		margins[i] = defaultMargin
+		if m != nil {
+			margins[i] = float64(*m)
+		}
+	}
+	return size, margins
+}
+
+// PreviewCtx renders PNG preview of first page of PDF document, which is
+// generated by ToPDFCtx for the same url and p, so headers, footers, print
+// styles and page breaks are the same as in the document. Page is
+// rasterized by pdftoppm. Preview width is calculated from page width and
+// dpi (DefaultPreviewDPI is used if dpi is zero) and limited by maxWidth
+// if it's not zero. Both preview dimensions are limited by MaxPreviewSize,
+// dpi above MaxPreviewDPI is rejected with ValidationError.
+func (c *Clipper) PreviewCtx(ctx context.Context, url string, w io.Writer, p *Params, maxWidth, dpi uint) error {
+	if p == nil {
+		panic("clip.PreviewCtx: params is nil")
+	}
+	bin, err := c.rasterBinPath()
+	if err != nil {
+		return err
+	}
+	if dpi == 0 {
+		dpi = DefaultPreviewDPI
+	}
+	if dpi > MaxPreviewDPI {
+		return &ValidationError{fmt.Sprintf("preview dpi should not exceed %d", MaxPreviewDPI)}
+	}
+	if maxWidth == 0 || maxWidth > MaxPreviewSize {
+		maxWidth = MaxPreviewSize
+	}
+	size, _ := p.pageGeometry()
+	width := size[0] / mmPerInch * float64(dpi)
+	if width > float64(maxWidth) {
+		width = float64(maxWidth)
+	}
+	height := width * size[1] / size[0]
+	if height > MaxPreviewSize { // tall page
+		width, height = width*MaxPreviewSize/height, MaxPreviewSize
+	}
+	if width < 1 {
+		width = 1
+	}
+	if height < 1 {
+		height = 1
+	}
+
+	var doc bytes.Buffer
+	err = c.ToPDFCtx(ctx, url, &doc, p)
+	var ignored *IgnoredError
+	if err != nil && !errors.As(err, &ignored) {
+		return err
+	}
+	args := []string{"-png", "-f", "1", "-l", "1", "-singlefile",
+		"-scale-to-x", strconv.Itoa(int(width)), "-scale-to-y", strconv.Itoa(int(height)), "-"}
+	var img bytes.Buffer
+	err = c.run(ctx, bin, args, &doc, &img)
+	if err != nil {
+		return fmt.Errorf("pdftoppm: %w", err)
+	}
+	if img.Len() == 0 {
+		return errors.New("no preview was generated")
+	}
+	_, err = img.WriteTo(w)
+	return err
+}
@@ -0,0 +1,53 @@
+package clip
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"runtime"
+	"testing"
+)
+
+func TestClipper_PreviewCtx(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("requires sh")
+	}
+	dir := t.TempDir()
+	script := func(name, body string) string {
+		path := filepath.Join(dir, name)
+		if err := ioutil.WriteFile(path, []byte("#!/bin/sh\n"+body+"\n"), 0755); err != nil { //nolint:gosec
+			t.Fatal(err)
+		}
+		return path
+	}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("content-type", "text/html")
+		_, _ = w.Write([]byte("<html><body><p>page</p></body></html>"))
+	}))
+	defer srv.Close()
+
+	c := NewClipper(ClipperOptions{
+		WkhtmltopdfPath: script("wkhtmltopdf", "cat >/dev/null; printf '%%PDF-1.4 page %%%%EOF'"),
+		PdftoppmPath:    script("pdftoppm", `echo "$@"; cat`),
+	})
+	var out bytes.Buffer
+	err := c.PreviewCtx(context.Background(), srv.URL, &out, &Params{}, 100, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// A4 page is scaled to max width
+	want := "-png -f 1 -l 1 -singlefile -scale-to-x 100 -scale-to-y 141 -\n%PDF-1.4 page %%EOF"
+	if out.String() != want {
+		t.Errorf("PreviewCtx() output = %q, want %q", out.String(), want)
+	}
+
+	err = c.PreviewCtx(context.Background(), srv.URL, &out, &Params{}, 0, MaxPreviewDPI+1)
+	var verr *ValidationError
+	if !errors.As(err, &verr) {
+		t.Errorf("PreviewCtx() with large dpi error = %v, want ValidationError", err)
+	}
+}
@@ -0,0 +1,143 @@
+package clip
+
+import (
+	"fmt"
+	"html"
+	"regexp"
+	"strings"
+
+	"github.com/PuerkitoBio/goquery"
+	"github.com/andybalholm/cascadia"
+	nethtml "golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+// Rewrite actions.
+const (
+	RewriteUnwrap          = "unwrap"            // replace element with its children
+	RewriteReplaceWithText = "replace_with_text" // replace element with Value text (element text if Value is empty)
+	RewriteReplaceWithHTML = "replace_with_html" // replace element with Value HTML
+	RewriteSetAttr         = "set_attr"          // set Attr to Value
+	RewriteRemoveAttr      = "remove_attr"       // remove Attr
+	RewriteWrap            = "wrap"              // wrap element with Value HTML
+	RewriteMoveBefore      = "move_before"       // move element before the nearest Target element
+	RewriteRenameTag       = "rename_tag"        // change element tag to Value
+)
+
+// RewriteRule is declarative DOM change applied to elements matching
+// Selector. "{name}" placeholders in Value are replaced by element
+// attributes values ("{text}" by element text). Target element of
+// move_before is searched in element ancestors, nearest first.
+type RewriteRule struct {
+	Selector string `json:"selector"`
+	Action   string `json:"action"`
+	Attr     string `json:"attr,omitempty"`
+	Value    string `json:"value,omitempty"`
+	Target   string `json:"target,omitempty"`
+}
+
+var tagNameRe = regexp.MustCompile(`^[a-zA-Z][a-zA-Z0-9-]*$`)
+
+// Validate checks rule selectors and required fields.
+func (r *RewriteRule) Validate() error {
+	if _, err := cascadia.Compile(r.Selector); err != nil || r.Selector == "" {
+		return fmt.Errorf("bad selector %q", r.Selector)
+	}
+	switch r.Action {
+	case RewriteUnwrap, RewriteReplaceWithText:
+	case RewriteSetAttr, RewriteRemoveAttr:
+		if r.Attr == "" {
+			return fmt.Errorf("%s: attr is required", r.Action)
+		}
+	case RewriteReplaceWithHTML, RewriteWrap:
+		if r.Value == "" {
+			return fmt.Errorf("%s: value is required", r.Action)
+		}
+	case RewriteMoveBefore:
+		if _, err := cascadia.Compile(r.Target); err != nil || r.Target == "" {
+			return fmt.Errorf("%s: bad target %q", r.Action, r.Target)
+		}
+	case RewriteRenameTag:
+		if !tagNameRe.MatchString(r.Value) {
+			return fmt.Errorf("%s: bad tag name %q", r.Action, r.Value)
+		}
+	default:
+		return fmt.Errorf("unknown action %q", r.Action)
+	}
+	return nil
+}
+
+// ValidateRewrite validates rewrite rules list.
+func ValidateRewrite(rules []RewriteRule) error {
+	for i := range rules {
+		err := rules[i].Validate()
+		if err != nil {
+			return &ValidationError{fmt.Sprintf("rewrite rule %d: %s", i+1, err)}
+		}
+	}
+	return nil
+}
+
+var placeholderRe = regexp.MustCompile(`\{([a-zA-Z_:][-a-zA-Z0-9_:.]*)\}`)
+
+// expand replaces placeholders in v with s attributes or text.
+func expand(v string, s *goquery.Selection, escape bool) string {
+	return placeholderRe.ReplaceAllStringFunc(v, func(m string) string {
+		name := m[1 : len(m)-1]
+		var res string
+		if name == "text" {
+			res = strings.TrimSpace(s.Text())
+		} else {
+			res = s.AttrOr(name, "")
+		}
+		if escape {
+			res = html.EscapeString(res)
+		}
+		return res
+	})
+}
+
+// rewrite applies rules to doc in order. Rules should be validated.
+func rewrite(doc *goquery.Document, rules []RewriteRule) {
+	for _, r := range rules {
+		r := r
+		doc.Find(r.Selector).Each(func(_ int, s *goquery.Selection) {
+			applyRule(&r, s)
+		})
+	}
+}
+
+func applyRule(r *RewriteRule, s *goquery.Selection) {
+	switch r.Action {
+	case RewriteUnwrap:
+		s.ReplaceWithSelection(s.Contents())
+	case RewriteReplaceWithText:
+		text := strings.TrimSpace(s.Text())
+		if r.Value != "" {
+			text = expand(r.Value, s, false)
+		}
+		s.ReplaceWithNodes(&nethtml.Node{Type: nethtml.TextNode, Data: text})
+	case RewriteReplaceWithHTML:
+		s.ReplaceWithHtml(expand(r.Value, s, true))
+	case RewriteSetAttr:
+		s.SetAttr(r.Attr, expand(r.Value, s, false))
+	case RewriteRemoveAttr:
+		s.RemoveAttr(r.Attr)
+	case RewriteWrap:
+		s.WrapHtml(expand(r.Value, s, true))
+	case RewriteMoveBefore:
+		for p := s.Parent(); p.Length() > 0; p = p.Parent() {
+			t := p.Find(r.Target).FilterFunction(func(_ int, t *goquery.Selection) bool {
+				return t.Get(0) != s.Get(0) && !s.Contains(t.Get(0))
+			}).First()
+			if t.Length() > 0 {
+				t.BeforeSelection(s)
+				return
+			}
+		}
+	case RewriteRenameTag:
+		n := s.Get(0)
+		n.Data = strings.ToLower(r.Value)
+		n.DataAtom = atom.Lookup([]byte(n.Data))
+	}
+}
@@ -0,0 +1,105 @@
+package clip
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/PuerkitoBio/goquery"
+)
+
+func Test_rewrite(t *testing.T) {
+	const page = `<html><body>
+		<div class="post"><h1>Title</h1><span class="w"><b>bold</b></span>
+		<a class="ref" href="/x" title="X">link</a><font class="c">code</font>
+		<figure><img data-src="a.png" src="stub.png"><p class="cap">caption</p></figure></div>
+	</body></html>`
+	tests := []struct {
+		name string
+		rule RewriteRule
+		want string // body html without spaces
+	}{
+		{
+			"unwrap",
+			RewriteRule{Selector: ".w", Action: RewriteUnwrap},
+			`<h1>Title</h1><b>bold</b>`,
+		},
+		{
+			"replace with text",
+			RewriteRule{Selector: "a.ref", Action: RewriteReplaceWithText, Value: "{text} ({href})"},
+			`<b>bold</b></span>link(/x)<fontclass="c">`,
+		},
+		{
+			"replace with html",
+			RewriteRule{Selector: "a.ref", Action: RewriteReplaceWithHTML, Value: `<abbr title="{title}">{text}</abbr>`},
+			`</span><abbrtitle="X">link</abbr><fontclass="c">`,
+		},
+		{
+			"set attr",
+			RewriteRule{Selector: "img[data-src]", Action: RewriteSetAttr, Attr: "src", Value: "{data-src}"},
+			`<imgdata-src="a.png"src="a.png"/>`,
+		},
+		{
+			"remove attr",
+			RewriteRule{Selector: "img", Action: RewriteRemoveAttr, Attr: "data-src"},
+			`<figure><imgsrc="stub.png"/>`,
+		},
+		{
+			"wrap",
+			RewriteRule{Selector: "font.c", Action: RewriteWrap, Value: "<pre></pre>"},
+			`<pre><fontclass="c">code</font></pre>`,
+		},
+		{
+			"move before",
+			RewriteRule{Selector: ".cap", Action: RewriteMoveBefore, Target: "img"},
+			`<figure><pclass="cap">caption</p><imgdata-src`,
+		},
+		{
+			"rename tag",
+			RewriteRule{Selector: "font.c", Action: RewriteRenameTag, Value: "CODE"},
+			`<codeclass="c">code</code>`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.rule.Validate(); err != nil {
+				t.Fatal(err)
+			}
+			doc, err := goquery.NewDocumentFromReader(strings.NewReader(page))
+			if err != nil {
+				t.Fatal(err)
+			}
+			rewrite(doc, []RewriteRule{tt.rule})
+			got, _ := doc.Find("body").Html()
+			got = strings.Join(strings.Fields(got), "")
+			if !strings.Contains(got, tt.want) {
+				t.Errorf("rewrite() body =\n%s\nwant substring\n%s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestValidateRewrite(t *testing.T) {
+	tests := []struct {
+		name    string
+		rule    RewriteRule
+		wantErr string
+	}{
+		{"bad selector", RewriteRule{Selector: "div[", Action: RewriteUnwrap}, "bad selector"},
+		{"empty selector", RewriteRule{Action: RewriteUnwrap}, "bad selector"},
+		{"unknown action", RewriteRule{Selector: "div", Action: "explode"}, "unknown action"},
+		{"no attr", RewriteRule{Selector: "div", Action: RewriteSetAttr}, "attr is required"},
+		{"no value", RewriteRule{Selector: "div", Action: RewriteWrap}, "value is required"},
+		{"bad target", RewriteRule{Selector: "div", Action: RewriteMoveBefore}, "bad target"},
+		{"bad tag", RewriteRule{Selector: "div", Action: RewriteRenameTag, Value: "<p>"}, "bad tag name"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := ValidateRewrite([]RewriteRule{{Selector: "p", Action: RewriteUnwrap}, tt.rule})
+			var verr *ValidationError
+			if !errors.As(err, &verr) || !strings.HasPrefix(verr.Message, "rewrite rule 2: ") || !strings.Contains(verr.Message, tt.wantErr) {
+				t.Errorf("ValidateRewrite() error = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,344 @@
+package clip
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/PuerkitoBio/goquery"
+	"go.starlark.net/starlark"
+)
+
+// ScriptOptions limit Params.Script execution.
+type ScriptOptions struct {
+	MaxSteps        uint64        // max Starlark execution steps, 0 - no limit
+	Timeout         time.Duration // max run time, 0 - no limit
+	MaxStringSize   int           // max length of strings passed to and returned by selection methods, 0 - no limit
+	MaxDocumentSize int64         // max document size (initial HTML size plus strings set by script), 0 - no limit
+}
+
+// DefaultScriptOptions are used by Clipper, if ClipperOptions.Script is nil.
+var DefaultScriptOptions = ScriptOptions{
+	MaxSteps:        1000000,
+	Timeout:         2 * time.Second,
+	MaxStringSize:   1 << 20,
+	MaxDocumentSize: 20 << 20,
+}
+
+// ScriptError is returned when Params.Script fails to compile, fails at
+// run time or exceeds its limits.
+type ScriptError struct {
+	Message string
+}
+
+func (e *ScriptError) Error() string {
+	return "script error: " + e.Message
+}
+
+// runScript runs Starlark script src on doc. Script has access to
+// predeclared doc (document selection) and url (document URL) values
+// only: load, while loops and recursion aren't allowed. Starlark allows
+// loops in functions only, so if script defines transform function, it's
+// called with doc after script is executed.
+func runScript(ctx context.Context, src string, doc *goquery.Document, opts ScriptOptions) error {
+	st := &scriptState{opts: opts}
+	if opts.MaxDocumentSize > 0 {
+		h, err := doc.Html()
+		if err != nil {
+			return fmt.Errorf("doc.Html: %w", err)
+		}
+		st.size = int64(len(h))
+	}
+	thread := &starlark.Thread{
+		Name:  "clip script",
+		Print: func(*starlark.Thread, string) {},
+	}
+	if opts.MaxSteps > 0 {
+		thread.SetMaxExecutionSteps(opts.MaxSteps)
+	}
+	if opts.Timeout > 0 {
+		t := time.AfterFunc(opts.Timeout, func() {
+			thread.Cancel(fmt.Sprintf("timeout after %v", opts.Timeout))
+		})
+		defer t.Stop()
+	}
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-ctx.Done():
+			thread.Cancel("context is done")
+		case <-done:
+		}
+	}()
+
+	url := ""
+	if doc.Url != nil {
+		url = doc.Url.String()
+	}
+	predeclared := starlark.StringDict{
+		"doc": &selection{doc.Selection, doc, st},
+		"url": starlark.String(url),
+	}
+	globals, err := starlark.ExecFile(thread, "script", src, predeclared)
+	if fn, ok := globals["transform"].(starlark.Callable); ok && err == nil {
+		_, err = starlark.Call(thread, fn, starlark.Tuple{predeclared["doc"]}, nil)
+	}
+	if err != nil {
+		if ctx.Err() != nil {
+			return fmt.Errorf("context error: %w", ctx.Err())
+		}
+		if ee, ok := err.(*starlark.EvalError); ok {
+			return &ScriptError{ee.Backtrace()}
+		}
+		return &ScriptError{err.Error()}
+	}
+	return nil
+}
+
+// selection is Starlark value wrapping goquery selection. Methods:
+//
+//	find(selector), first(), last(), eq(i), parent(), children()
+//	remove(), attr(name), set_attr(name, value), remove_attr(name),
+//	add_class(name), remove_class(name), text(), set_text(s), html(),
+//	set_html(s), wrap(html), move(target, position="append")
+//
+// target of move is selection or selector, position is append, prepend,
+// before or after. Selections are iterable and indexable by element.
+type selection struct {
+	s   *goquery.Selection
+	doc *goquery.Document
+	st  *scriptState
+}
+
+// scriptState tracks script memory usage.
+type scriptState struct {
+	opts ScriptOptions
+	size int64 // estimated document size
+}
+
+// checkString checks s length for method fn.
+func (st *scriptState) checkString(fn string, s string) error {
+	if max := st.opts.MaxStringSize; max > 0 && len(s) > max {
+		return fmt.Errorf("%s: string length %d exceeds limit %d", fn, len(s), max)
+	}
+	return nil
+}
+
+// grow adds n bytes to document size. Removed content isn't subtracted,
+// so size is upper estimate.
+func (st *scriptState) grow(fn string, n int64) error {
+	st.size += n
+	if max := st.opts.MaxDocumentSize; max > 0 && st.size > max {
+		return fmt.Errorf("%s: document size exceeds limit %d", fn, max)
+	}
+	return nil
+}
+
+var (
+	_ starlark.HasAttrs  = (*selection)(nil)
+	_ starlark.Indexable = (*selection)(nil)
+	_ starlark.Iterable  = (*selection)(nil)
+)
+
+func (sel *selection) String() string        { return fmt.Sprintf("<selection of %d>", sel.s.Length()) }
+func (sel *selection) Type() string          { return "selection" }
+func (sel *selection) Freeze()               {}
+func (sel *selection) Truth() starlark.Bool  { return sel.s.Length() > 0 }
+func (sel *selection) Hash() (uint32, error) { return 0, fmt.Errorf("unhashable type: selection") }
+func (sel *selection) Len() int              { return sel.s.Length() }
+
+func (sel *selection) Index(i int) starlark.Value {
+	return sel.wrap(sel.s.Eq(i))
+}
+
+func (sel *selection) Iterate() starlark.Iterator {
+	return &selectionIterator{sel: sel}
+}
+
+func (sel *selection) wrap(s *goquery.Selection) *selection {
+	return &selection{s, sel.doc, sel.st}
+}
+
+type selectionIterator struct {
+	sel *selection
+	i   int
+}
+
+func (it *selectionIterator) Next(p *starlark.Value) bool {
+	if it.i >= it.sel.s.Length() {
+		return false
+	}
+	*p = it.sel.Index(it.i)
+	it.i++
+	return true
+}
+
+func (it *selectionIterator) Done() {}
+
+type selectionMethod func(sel *selection, fn *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error)
+
+var selectionMethods = map[string]selectionMethod{
+	"find": func(sel *selection, fn *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+		var selector string
+		if err := starlark.UnpackPositionalArgs(fn.Name(), args, kwargs, 1, &selector); err != nil {
+			return nil, err
+		}
+		return sel.wrap(sel.s.Find(selector)), nil
+	},
+	"first":    navigate(func(s *goquery.Selection) *goquery.Selection { return s.First() }),
+	"last":     navigate(func(s *goquery.Selection) *goquery.Selection { return s.Last() }),
+	"parent":   navigate(func(s *goquery.Selection) *goquery.Selection { return s.Parent() }),
+	"children": navigate(func(s *goquery.Selection) *goquery.Selection { return s.Children() }),
+	"eq": func(sel *selection, fn *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+		var i int
+		if err := starlark.UnpackPositionalArgs(fn.Name(), args, kwargs, 1, &i); err != nil {
+			return nil, err
+		}
+		return sel.wrap(sel.s.Eq(i)), nil
+	},
+	"remove": func(sel *selection, fn *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+		if err := starlark.UnpackPositionalArgs(fn.Name(), args, kwargs, 0); err != nil {
+			return nil, err
+		}
+		sel.s.Remove()
+		return starlark.None, nil
+	},
+	"attr": func(sel *selection, fn *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+		var name string
+		if err := starlark.UnpackPositionalArgs(fn.Name(), args, kwargs, 1, &name); err != nil {
+			return nil, err
+		}
+		if v, ok := sel.s.Attr(name); ok {
+			return starlark.String(v), nil
+		}
+		return starlark.None, nil
+	},
+	"set_attr": func(sel *selection, fn *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+		var name, value string
+		if err := starlark.UnpackPositionalArgs(fn.Name(), args, kwargs, 2, &name, &value); err != nil {
+			return nil, err
+		}
+		if err := sel.st.checkString(fn.Name(), value); err != nil {
+			return nil, err
+		}
+		if err := sel.st.grow(fn.Name(), int64(len(name)+len(value))*int64(sel.s.Length())); err != nil {
+			return nil, err
+		}
+		sel.s.SetAttr(name, value)
+		return sel, nil
+	},
+	"remove_attr":  stringSetter(false, func(s *goquery.Selection, v string) { s.RemoveAttr(v) }),
+	"add_class":    stringSetter(true, func(s *goquery.Selection, v string) { s.AddClass(v) }),
+	"remove_class": stringSetter(false, func(s *goquery.Selection, v string) { s.RemoveClass(v) }),
+	"set_text":     stringSetter(true, func(s *goquery.Selection, v string) { s.SetText(v) }),
+	"set_html":     stringSetter(true, func(s *goquery.Selection, v string) { s.SetHtml(v) }),
+	"wrap":         stringSetter(true, func(s *goquery.Selection, v string) { s.WrapHtml(v) }),
+	"text": func(sel *selection, fn *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+		if err := starlark.UnpackPositionalArgs(fn.Name(), args, kwargs, 0); err != nil {
+			return nil, err
+		}
+		t := sel.s.Text()
+		if err := sel.st.checkString(fn.Name(), t); err != nil {
+			return nil, err
+		}
+		return starlark.String(t), nil
+	},
+	"html": func(sel *selection, fn *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+		if err := starlark.UnpackPositionalArgs(fn.Name(), args, kwargs, 0); err != nil {
+			return nil, err
+		}
+		h, err := sel.s.Html()
+		if err != nil {
+			return nil, err
+		}
+		if err := sel.st.checkString(fn.Name(), h); err != nil {
+			return nil, err
+		}
+		return starlark.String(h), nil
+	},
+	"move": func(sel *selection, fn *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+		var (
+			target   starlark.Value
+			position = "append"
+		)
+		if err := starlark.UnpackArgs(fn.Name(), args, kwargs, "target", &target, "position?", &position); err != nil {
+			return nil, err
+		}
+		var dst *goquery.Selection
+		switch t := target.(type) {
+		case *selection:
+			dst = t.s
+		case starlark.String:
+			dst = sel.doc.Find(string(t))
+		default:
+			return nil, fmt.Errorf("%s: target should be selection or selector, got %s", fn.Name(), target.Type())
+		}
+		switch position {
+		case "append":
+			dst.AppendSelection(sel.s)
+		case "prepend":
+			dst.PrependSelection(sel.s)
+		case "before":
+			dst.BeforeSelection(sel.s)
+		case "after":
+			dst.AfterSelection(sel.s)
+		default:
+			return nil, fmt.Errorf("%s: unknown position %q", fn.Name(), position)
+		}
+		return sel, nil
+	},
+}
+
+// navigate returns method returning selection derived from receiver.
+func navigate(f func(*goquery.Selection) *goquery.Selection) selectionMethod {
+	return func(sel *selection, fn *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+		if err := starlark.UnpackPositionalArgs(fn.Name(), args, kwargs, 0); err != nil {
+			return nil, err
+		}
+		return sel.wrap(f(sel.s)), nil
+	}
+}
+
+// stringSetter returns method changing receiver with single string
+// argument. Method returns receiver, so calls can be chained. If grows is
+// true, argument size is added to document size for each element.
+func stringSetter(grows bool, f func(*goquery.Selection, string)) selectionMethod {
+	return func(sel *selection, fn *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+		var v string
+		if err := starlark.UnpackPositionalArgs(fn.Name(), args, kwargs, 1, &v); err != nil {
+			return nil, err
+		}
+		if err := sel.st.checkString(fn.Name(), v); err != nil {
+			return nil, err
+		}
+		if grows {
+			if err := sel.st.grow(fn.Name(), int64(len(v))*int64(sel.s.Length())); err != nil {
+				return nil, err
+			}
+		}
+		f(sel.s, v)
+		return sel, nil
+	}
+}
+
+func (sel *selection) Attr(name string) (starlark.Value, error) {
+	m, ok := selectionMethods[name]
+	if !ok {
+		return nil, nil
+	}
+	return starlark.NewBuiltin(name, func(_ *starlark.Thread, fn *starlark.Builtin, args starlark.Tuple,
+		kwargs []starlark.Tuple) (starlark.Value, error) {
+		return m(sel, fn, args, kwargs)
+	}), nil
+}
+
+func (sel *selection) AttrNames() []string {
+	res := make([]string, 0, len(selectionMethods))
+	for name := range selectionMethods {
+		res = append(res, name)
+	}
+	sort.Strings(res)
+	return res
+}
@@ -0,0 +1,133 @@
+package clip
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/PuerkitoBio/goquery"
+)
+
+const loop = "def transform(doc):\n    for i in range(1000000000):\n        pass"
+
+func Test_runScript(t *testing.T) {
+	const page = `<html><body>
+		<div class="post"><h1>Title</h1><p class="ad">ad</p><p>text</p></div>
+		<aside><img data-src="a.png"></aside>
+	</body></html>`
+	tests := []struct {
+		name    string
+		script  string
+		opts    ScriptOptions
+		want    string // body html without spaces
+		wantErr string
+	}{
+		{
+			name: "dom api",
+			script: `
+doc.find(".ad").remove()
+
+def transform(doc):
+    for img in doc.find("img"):
+        img.set_attr("src", img.attr("data-src")).remove_attr("data-src")
+    doc.find("aside img").move(".post h1", "after")
+    doc.find("aside").remove()
+    doc.find("h1").wrap("<header></header>").add_class("t")
+    if doc.find("h2").attr("x") == None and len(doc.find("p")) == 1:
+        doc.find("p")[0].set_text(url)
+`,
+			opts: DefaultScriptOptions,
+			want: `<divclass="post"><header><h1class="t">Title</h1></header><imgsrc="a.png"/>` +
+				`<p>http://example.com/post</p></div>`,
+		},
+		{
+			name:    "runtime error",
+			script:  `doc.find("p").move(1)`,
+			opts:    DefaultScriptOptions,
+			wantErr: "target should be selection or selector",
+		},
+		{
+			name:    "syntax error",
+			script:  `doc.find(`,
+			opts:    DefaultScriptOptions,
+			wantErr: "script:",
+		},
+		{
+			name:    "load is not allowed",
+			script:  `load("x.star", "y")`,
+			opts:    DefaultScriptOptions,
+			wantErr: "load",
+		},
+		{
+			name:    "steps limit",
+			script:  loop,
+			opts:    ScriptOptions{MaxSteps: 1000},
+			wantErr: "too many steps",
+		},
+		{
+			name:    "string limit",
+			script:  `doc.find("h1").set_html("x" * 100)`,
+			opts:    ScriptOptions{MaxStringSize: 10},
+			wantErr: "set_html: string length 100 exceeds limit 10",
+		},
+		{
+			name:    "html limit",
+			script:  `doc.find("body").html()`,
+			opts:    ScriptOptions{MaxStringSize: 10},
+			wantErr: "html: string length",
+		},
+		{
+			name: "document size limit",
+			script: `
+def transform(doc):
+    for i in range(100):
+        doc.find("div").wrap("<section>" + "x" * 1000 + "</section>")
+`,
+			opts:    ScriptOptions{MaxDocumentSize: 10000},
+			wantErr: "wrap: document size exceeds limit 10000",
+		},
+		{
+			name:    "timeout",
+			script:  loop,
+			opts:    ScriptOptions{Timeout: 50 * time.Millisecond},
+			wantErr: "timeout after 50ms",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			doc, err := goquery.NewDocumentFromReader(strings.NewReader(page))
+			if err != nil {
+				t.Fatal(err)
+			}
+			doc.Url, _ = doc.Url.Parse("http://example.com/post")
+			err = runScript(context.Background(), tt.script, doc, tt.opts)
+			if tt.wantErr != "" {
+				var serr *ScriptError
+				if !errors.As(err, &serr) || !strings.Contains(serr.Message, tt.wantErr) {
+					t.Errorf("runScript() error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, _ := doc.Find("body").Html()
+			got = strings.Join(strings.Fields(got), "")
+			if got != tt.want {
+				t.Errorf("runScript() body =\n%s\nwant\n%s", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_runScript_cancel(t *testing.T) {
+	doc, _ := goquery.NewDocumentFromReader(strings.NewReader("<html><body></body></html>"))
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	err := runScript(ctx, loop, doc, ScriptOptions{})
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("runScript() error = %v, want context.DeadlineExceeded", err)
+	}
+}
@@ -0,0 +1,89 @@
+package clip
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// peekSize is count of first output bytes held back until renderer
+// result is known.
+const peekSize = 512
+
+// tailSize is count of last output bytes kept to check if output is
+// complete.
+const tailSize = 32
+
+// ErrIncompleteOutput is returned when renderer output is truncated
+// (renderer was killed or failed while writing it). Part of output may be
+// already written.
+var ErrIncompleteOutput = errors.New("incomplete output")
+
+// peekWriter passes renderer output to w, holding back first peekSize
+// bytes. So nothing is written to w (and HTTP response headers are not
+// committed) if renderer fails without output.
+type peekWriter struct {
+	w    io.Writer
+	head []byte
+	tail []byte // last tailSize bytes
+	n    int64  // bytes written to peekWriter
+	err  error  // w write error
+}
+
+func newPeekWriter(w io.Writer) *peekWriter {
+	return &peekWriter{w: w, head: make([]byte, 0, peekSize)}
+}
+
+func (pw *peekWriter) Write(b []byte) (int, error) {
+	if pw.err != nil {
+		return 0, pw.err
+	}
+	n := len(b)
+	pw.n += int64(n)
+	if len(b) >= tailSize {
+		pw.tail = append(pw.tail[:0], b[len(b)-tailSize:]...)
+	} else {
+		pw.tail = append(pw.tail, b...)
+		if len(pw.tail) > tailSize {
+			pw.tail = append(pw.tail[:0], pw.tail[len(pw.tail)-tailSize:]...)
+		}
+	}
+	if pw.head != nil {
+		k := cap(pw.head) - len(pw.head)
+		if k > len(b) {
+			k = len(b)
+		}
+		pw.head = append(pw.head, b[:k]...)
+		b = b[k:]
+		if len(b) == 0 {
+			return n, nil
+		}
+		if pw.flush(); pw.err != nil {
+			return 0, pw.err
+		}
+	}
+	if _, err := pw.w.Write(b); err != nil {
+		pw.err = fmt.Errorf("write: %w", err)
+		return 0, pw.err
+	}
+	return n, nil
+}
+
+// flush writes held back bytes to w. Further writes go to w directly.
+func (pw *peekWriter) flush() {
+	head := pw.head
+	pw.head = nil
+	if len(head) == 0 || pw.err != nil {
+		return
+	}
+	if _, err := pw.w.Write(head); err != nil {
+		pw.err = fmt.Errorf("write: %w", err)
+	}
+}
+
+// pdfComplete reports if output ends with PDF end-of-file marker, i.e.
+// renderer wasn't killed while writing it.
+func (pw *peekWriter) pdfComplete() bool {
+	return bytes.HasSuffix(bytes.TrimRight(pw.tail, "\r\n \x00"), []byte("%%EOF"))
+}
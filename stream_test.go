@@ -0,0 +1,75 @@
+package clip
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+func Test_peekWriter(t *testing.T) {
+	var dst bytes.Buffer
+	pw := newPeekWriter(&dst)
+	_, _ = pw.Write([]byte("%PDF-"))
+	if dst.Len() != 0 {
+		t.Fatalf("first bytes were not held back: %q", dst.String())
+	}
+	tail := strings.Repeat("x", peekSize)
+	_, _ = pw.Write([]byte(tail))
+	if dst.Len() == 0 {
+		t.Fatal("output was not streamed after peekSize bytes")
+	}
+	_, _ = pw.Write([]byte("end"))
+	pw.flush()
+	if want := "%PDF-" + tail + "end"; dst.String() != want || pw.n != int64(len(want)) || pw.err != nil {
+		t.Errorf("output = %d bytes, n = %d, err = %v", dst.Len(), pw.n, pw.err)
+	}
+
+	if pw.pdfComplete() {
+		t.Error("truncated output is reported as complete PDF")
+	}
+	_, _ = pw.Write([]byte("%%EO"))
+	_, _ = pw.Write([]byte("F\n"))
+	if !pw.pdfComplete() {
+		t.Error("complete output is reported as truncated PDF")
+	}
+
+	dst.Reset()
+	pw = newPeekWriter(&dst)
+	pw.flush()
+	if pw.n != 0 || dst.Len() != 0 {
+		t.Errorf("empty output was written")
+	}
+}
+
+func TestClipper_ToPDFCtx_truncated(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("requires sh")
+	}
+	bin := filepath.Join(t.TempDir(), "wkhtmltopdf")
+	err := ioutil.WriteFile(bin, []byte("#!/bin/sh\ncat >/dev/null; printf '%%PDF-1.4 '; "+
+		"head -c 1000 /dev/zero; echo killed >&2; exit 1\n"), 0755) //nolint:gosec
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("content-type", "text/html")
+		_, _ = w.Write([]byte("<html><body><p>page</p></body></html>"))
+	}))
+	defer srv.Close()
+
+	var dst bytes.Buffer
+	err = NewClipper(ClipperOptions{WkhtmltopdfPath: bin}).ToPDFCtx(context.Background(), srv.URL, &dst, &Params{})
+	if !errors.Is(err, ErrIncompleteOutput) {
+		t.Errorf("ToPDFCtx() error = %v, want ErrIncompleteOutput", err)
+	}
+	if dst.Len() == 0 {
+		t.Error("output wasn't streamed")
+	}
+}
@@ -0,0 +1,702 @@
+package clip
+
+import (
+	"fmt"
+	"html"
+	"strings"
+	"unicode"
+)
+
+// TeX symbols by command name: identifiers (letters and letter-like
+// symbols) and operators.
+var (
+	texIdentifiers = map[string]string{
+		"alpha": "α", "beta": "β", "gamma": "γ", "delta": "δ", "epsilon": "ϵ", "varepsilon": "ε",
+		"zeta": "ζ", "eta": "η", "theta": "θ", "vartheta": "ϑ", "iota": "ι", "kappa": "κ",
+		"lambda": "λ", "mu": "μ", "nu": "ν", "xi": "ξ", "pi": "π", "varpi": "ϖ", "rho": "ρ",
+		"varrho": "ϱ", "sigma": "σ", "varsigma": "ς", "tau": "τ", "upsilon": "υ", "phi": "ϕ",
+		"varphi": "φ", "chi": "χ", "psi": "ψ", "omega": "ω",
+		"Gamma": "Γ", "Delta": "Δ", "Theta": "Θ", "Lambda": "Λ", "Xi": "Ξ", "Pi": "Π",
+		"Sigma": "Σ", "Upsilon": "Υ", "Phi": "Φ", "Psi": "Ψ", "Omega": "Ω",
+		"infty": "∞", "partial": "∂", "nabla": "∇", "emptyset": "∅", "varnothing": "∅",
+		"hbar": "ℏ", "ell": "ℓ", "aleph": "ℵ", "Re": "ℜ", "Im": "ℑ", "wp": "℘",
+		"imath": "ı", "jmath": "ȷ",
+	}
+	texOperators = map[string]string{
+		"pm": "±", "mp": "∓", "times": "×", "div": "÷", "cdot": "⋅", "ast": "∗", "star": "⋆",
+		"circ": "∘", "bullet": "∙", "leq": "≤", "le": "≤", "geq": "≥", "ge": "≥", "neq": "≠",
+		"ne": "≠", "approx": "≈", "equiv": "≡", "sim": "∼", "simeq": "≃", "cong": "≅",
+		"propto": "∝", "ll": "≪", "gg": "≫", "in": "∈", "notin": "∉", "ni": "∋",
+		"subset": "⊂", "supset": "⊃", "subseteq": "⊆", "supseteq": "⊇", "cup": "∪", "cap": "∩",
+		"setminus": "∖", "wedge": "∧", "land": "∧", "vee": "∨", "lor": "∨", "neg": "¬",
+		"lnot": "¬", "forall": "∀", "exists": "∃", "nexists": "∄", "to": "→",
+		"rightarrow": "→", "leftarrow": "←", "gets": "←", "leftrightarrow": "↔",
+		"Rightarrow": "⇒", "Leftarrow": "⇐", "Leftrightarrow": "⇔", "implies": "⟹",
+		"iff": "⟺", "mapsto": "↦", "uparrow": "↑", "downarrow": "↓", "ldots": "…",
+		"cdots": "⋯", "vdots": "⋮", "ddots": "⋱", "dots": "…", "prime": "′", "angle": "∠",
+		"perp": "⊥", "parallel": "∥", "mid": "∣", "oplus": "⊕", "otimes": "⊗", "odot": "⊙",
+		"langle": "⟨", "rangle": "⟩", "lfloor": "⌊", "rfloor": "⌋", "lceil": "⌈", "rceil": "⌉",
+		"vert": "|", "lvert": "|", "rvert": "|", "Vert": "‖", "lVert": "‖", "rVert": "‖",
+		"|": "‖", "{": "{", "}": "}", "lbrace": "{", "rbrace": "}", "%": "%", "#": "#",
+		"&": "&", "$": "$", "_": "_", "colon": ":", "triangle": "△", "square": "□",
+		"degree": "°", "cdotp": "⋅", "backslash": "∖", "models": "⊨", "vdash": "⊢",
+		"top": "⊤", "bot": "⊥", "therefore": "∴", "because": "∵",
+	}
+	// big operators, limits are placed under and over them in display mode
+	texBigOperators = map[string]string{
+		"sum": "∑", "prod": "∏", "coprod": "∐", "bigcup": "⋃", "bigcap": "⋂",
+		"bigoplus": "⨁", "bigotimes": "⨂", "bigodot": "⨀", "bigvee": "⋁", "bigwedge": "⋀",
+		"bigsqcup": "⨆",
+	}
+	texIntegrals = map[string]string{
+		"int": "∫", "iint": "∬", "iiint": "∭", "oint": "∮",
+	}
+	texFunctions = map[string]bool{
+		"sin": true, "cos": true, "tan": true, "cot": true, "sec": true, "csc": true,
+		"arcsin": true, "arccos": true, "arctan": true, "sinh": true, "cosh": true,
+		"tanh": true, "coth": true, "log": true, "ln": true, "lg": true, "exp": true,
+		"det": true, "dim": true, "ker": true, "deg": true, "arg": true, "gcd": true,
+		"hom": true, "mod": true,
+	}
+	// functions with limits under them in display mode
+	texLimitFunctions = map[string]bool{
+		"lim": true, "liminf": true, "limsup": true, "max": true, "min": true,
+		"sup": true, "inf": true, "Pr": true,
+	}
+	texSpaces = map[string]string{
+		",": "0.167em", ":": "0.222em", ">": "0.222em", ";": "0.278em", " ": "0.333em",
+		"quad": "1em", "qquad": "2em", "!": "-0.167em", "enspace": "0.5em", "thinspace": "0.167em",
+	}
+	texAccents = map[string]string{
+		"hat": "^", "widehat": "^", "bar": "¯", "overline": "¯", "vec": "→", "tilde": "~",
+		"widetilde": "~", "dot": "˙", "ddot": "¨", "check": "ˇ", "breve": "˘",
+		"overrightarrow": "→", "overleftarrow": "←", "overbrace": "⏞",
+	}
+	texUnderAccents = map[string]string{
+		"underline": "_", "underbrace": "⏟",
+	}
+	texFontVariants = map[string]string{
+		"mathrm": "normal", "mathbf": "bold", "mathit": "italic", "mathbb": "double-struck",
+		"mathcal": "script", "mathscr": "script", "mathfrak": "fraktur", "mathsf": "sans-serif",
+		"mathtt": "monospace", "boldsymbol": "bold-italic", "bm": "bold-italic",
+	}
+	texTextVariants = map[string]string{
+		"text": "", "mbox": "", "textrm": "", "textbf": "bold", "textit": "italic",
+		"texttt": "monospace", "textsf": "sans-serif",
+	}
+	// environments fences
+	texMatrixFences = map[string][2]string{
+		"matrix": {"", ""}, "smallmatrix": {"", ""}, "pmatrix": {"(", ")"},
+		"bmatrix": {"[", "]"}, "Bmatrix": {"{", "}"}, "vmatrix": {"|", "|"},
+		"Vmatrix": {"‖", "‖"}, "cases": {"{", ""}, "array": {"", ""},
+		"aligned": {"", ""}, "align": {"", ""}, "align*": {"", ""}, "gathered": {"", ""},
+		"gather": {"", ""}, "gather*": {"", ""}, "split": {"", ""}, "alignat": {"", ""},
+	}
+	// ignored commands (without arguments)
+	texIgnored = map[string]bool{
+		"displaystyle": true, "textstyle": true, "scriptstyle": true, "nonumber": true,
+		"notag": true, "limits": true, "nolimits": true, "big": true, "Big": true,
+		"bigg": true, "Bigg": true, "bigl": true, "bigr": true, "Bigl": true, "Bigr": true,
+		"biggl": true, "biggr": true, "Biggl": true, "Biggr": true, "middle": true,
+	}
+)
+
+// texToken is TeX command (without backslash) or character.
+type texToken struct {
+	cmd  bool
+	text string
+}
+
+// texParser converts TeX math subset (LaTeX and AMS math commands, which
+// are common in articles) to MathML.
+type texParser struct {
+	src     []rune
+	pos     int
+	display bool
+}
+
+// texToMathML converts TeX formula to MathML math element. Original
+// formula is kept in annotation.
+func texToMathML(tex string, display bool) (string, error) {
+	p := &texParser{src: []rune(tex), display: display}
+	body, err := p.parseList(func(texToken) bool { return false })
+	if err != nil {
+		return "", err
+	}
+	if tok, ok := p.peek(); ok {
+		return "", fmt.Errorf("unexpected %s", tok)
+	}
+	mode := "inline"
+	if display {
+		mode = "block"
+	}
+	return `<math xmlns="http://www.w3.org/1998/Math/MathML" display="` + mode + `"><semantics><mrow>` +
+		body + `</mrow><annotation encoding="application/x-tex">` + html.EscapeString(tex) +
+		`</annotation></semantics></math>`, nil
+}
+
+func (t texToken) String() string {
+	if t.cmd {
+		return `"\` + t.text + `"`
+	}
+	return `"` + t.text + `"`
+}
+
+func (t texToken) is(cmd bool, text string) bool {
+	return t.cmd == cmd && t.text == text
+}
+
+func (p *texParser) skipSpace() {
+	for p.pos < len(p.src) && unicode.IsSpace(p.src[p.pos]) {
+		p.pos++
+	}
+}
+
+// peek returns next token without consuming it.
+func (p *texParser) peek() (texToken, bool) {
+	pos := p.pos
+	tok, ok := p.next()
+	p.pos = pos
+	return tok, ok
+}
+
+// next consumes next token.
+func (p *texParser) next() (texToken, bool) {
+	p.skipSpace()
+	if p.pos >= len(p.src) {
+		return texToken{}, false
+	}
+	r := p.src[p.pos]
+	p.pos++
+	if r != '\\' {
+		return texToken{text: string(r)}, true
+	}
+	if p.pos >= len(p.src) {
+		return texToken{cmd: true, text: " "}, true
+	}
+	start := p.pos
+	for p.pos < len(p.src) && isTeXLetter(p.src[p.pos]) {
+		p.pos++
+	}
+	if p.pos == start {
+		p.pos++
+	}
+	return texToken{cmd: true, text: string(p.src[start:p.pos])}, true
+}
+
+func isTeXLetter(r rune) bool {
+	return r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z'
+}
+
+// parseList parses atoms until end of input or stop token (which isn't
+// consumed).
+func (p *texParser) parseList(stop func(texToken) bool) (string, error) {
+	var b strings.Builder
+	for {
+		tok, ok := p.peek()
+		if !ok || stop(tok) || tok.is(false, "}") {
+			return b.String(), nil
+		}
+		s, err := p.parseScripted()
+		if err != nil {
+			return "", err
+		}
+		b.WriteString(s)
+	}
+}
+
+// parseScripted parses atom with its subscripts and superscripts.
+func (p *texParser) parseScripted() (string, error) {
+	base, limits, err := p.parseAtom()
+	if err != nil {
+		return "", err
+	}
+	var sub, sup string
+	hasSub, hasSup, primes := false, false, 0
+	for {
+		tok, ok := p.peek()
+		if !ok {
+			break
+		}
+		switch {
+		case tok.is(true, "limits"):
+			p.next()
+			limits = true
+			continue
+		case tok.is(true, "nolimits"):
+			p.next()
+			limits = false
+			continue
+		case tok.is(false, "'"):
+			p.next()
+			sup += "<mo>′</mo>"
+			hasSup = true
+			primes++
+			continue
+		case tok.is(false, "^") || tok.is(false, "_"):
+			p.next()
+			if tok.text == "^" && hasSup && len(sup) > primes*len("<mo>′</mo>") || tok.text == "_" && hasSub {
+				return "", fmt.Errorf("double %s", tok)
+			}
+			arg, err := p.parseArg()
+			if err != nil {
+				return "", err
+			}
+			if tok.text == "^" {
+				sup += arg
+				hasSup = true
+			} else {
+				sub = arg
+				hasSub = true
+			}
+			continue
+		}
+		break
+	}
+	if !hasSub && !hasSup {
+		return base, nil
+	}
+	base, sub, sup = mrow(base), mrow(sub), mrow(sup)
+	under, over, both := "msub", "msup", "msubsup"
+	if limits && p.display {
+		under, over, both = "munder", "mover", "munderover"
+	}
+	switch {
+	case hasSub && hasSup:
+		return "<" + both + ">" + base + sub + sup + "</" + both + ">", nil
+	case hasSub:
+		return "<" + under + ">" + base + sub + "</" + under + ">", nil
+	}
+	return "<" + over + ">" + base + sup + "</" + over + ">", nil
+}
+
+// mrow wraps s in mrow element, unless s is single element, so it can be
+// used as argument of elements with fixed children count.
+func mrow(s string) string {
+	n, depth := 0, 0
+	for rest := s; ; {
+		i := strings.IndexByte(rest, '<')
+		if i < 0 {
+			break
+		}
+		rest = rest[i:]
+		end := strings.IndexByte(rest, '>')
+		if end < 0 {
+			break
+		}
+		switch {
+		case rest[1] == '/':
+			depth--
+		case rest[end-1] == '/':
+			if depth == 0 {
+				n++
+			}
+		default:
+			if depth == 0 {
+				n++
+			}
+			depth++
+		}
+		rest = rest[end+1:]
+	}
+	if n == 1 {
+		return s
+	}
+	return "<mrow>" + s + "</mrow>"
+}
+
+// parseArg parses command argument or script: group or single token.
+func (p *texParser) parseArg() (string, error) {
+	tok, ok := p.peek()
+	if !ok {
+		return "", fmt.Errorf("missing argument")
+	}
+	if tok.is(false, "{") {
+		s, err := p.parseGroup()
+		return mrow(s), err
+	}
+	if tok.cmd {
+		s, _, err := p.parseAtom()
+		return mrow(s), err
+	}
+	p.next()
+	return charAtom(tok.text)
+}
+
+// parseGroup parses {...} group content.
+func (p *texParser) parseGroup() (string, error) {
+	tok, ok := p.next()
+	if !ok || !tok.is(false, "{") {
+		return "", fmt.Errorf("expected \"{\"")
+	}
+	s, err := p.parseList(func(texToken) bool { return false })
+	if err != nil {
+		return "", err
+	}
+	if tok, ok = p.next(); !ok || !tok.is(false, "}") {
+		return "", fmt.Errorf("missing \"}\"")
+	}
+	return s, nil
+}
+
+// readRaw reads {...} group source.
+func (p *texParser) readRaw() (string, error) {
+	p.skipSpace()
+	if p.pos >= len(p.src) || p.src[p.pos] != '{' {
+		return "", fmt.Errorf("expected \"{\"")
+	}
+	depth, start := 0, p.pos+1
+	for ; p.pos < len(p.src); p.pos++ {
+		switch p.src[p.pos] {
+		case '\\':
+			p.pos++
+		case '{':
+			depth++
+		case '}':
+			depth--
+			if depth == 0 {
+				p.pos++
+				return string(p.src[start : p.pos-1]), nil
+			}
+		}
+	}
+	return "", fmt.Errorf("missing \"}\"")
+}
+
+// charAtom returns element of single character.
+func charAtom(c string) (string, error) {
+	r := []rune(c)[0]
+	switch {
+	case r >= '0' && r <= '9':
+		return "<mn>" + c + "</mn>", nil
+	case unicode.IsLetter(r):
+		return "<mi>" + html.EscapeString(c) + "</mi>", nil
+	case c == "-":
+		return "<mo>−</mo>", nil
+	case c == "~":
+		return `<mspace width="0.333em"/>`, nil
+	case c == "&" || c == "#" || c == "$" || c == "^" || c == "_" || c == "}":
+		return "", fmt.Errorf("unexpected %q", c)
+	}
+	return "<mo>" + html.EscapeString(c) + "</mo>", nil
+}
+
+// parseAtom parses single atom. limits reports if atom is big operator
+// with limits placed under and over it in display mode.
+func (p *texParser) parseAtom() (s string, limits bool, err error) {
+	tok, ok := p.peek()
+	if !ok {
+		return "", false, fmt.Errorf("unexpected end of formula")
+	}
+	if !tok.cmd {
+		switch {
+		case tok.text == "{":
+			s, err = p.parseGroup()
+			return "<mrow>" + s + "</mrow>", false, err
+		case tok.text == "^" || tok.text == "_" || tok.text == "'":
+			return "<mrow></mrow>", false, nil // script without base
+		case tok.text[0] >= '0' && tok.text[0] <= '9' || tok.text == ".":
+			return p.parseNumber(), false, nil
+		}
+		p.next()
+		s, err = charAtom(tok.text)
+		return s, false, err
+	}
+	p.next()
+	return p.parseCommand(tok.text)
+}
+
+func (p *texParser) parseNumber() string {
+	p.skipSpace()
+	start := p.pos
+	for p.pos < len(p.src) && (p.src[p.pos] >= '0' && p.src[p.pos] <= '9' ||
+		p.src[p.pos] == '.' && p.pos+1 < len(p.src) && p.src[p.pos+1] >= '0' && p.src[p.pos+1] <= '9') {
+		p.pos++
+	}
+	if p.pos == start { // single dot
+		p.pos++
+		return "<mo>.</mo>"
+	}
+	return "<mn>" + string(p.src[start:p.pos]) + "</mn>"
+}
+
+// parseCommand parses command cmd arguments.
+func (p *texParser) parseCommand(cmd string) (string, bool, error) {
+	if v, ok := texIdentifiers[cmd]; ok {
+		return "<mi>" + v + "</mi>", false, nil
+	}
+	if v, ok := texOperators[cmd]; ok {
+		return "<mo>" + html.EscapeString(v) + "</mo>", false, nil
+	}
+	if v, ok := texBigOperators[cmd]; ok {
+		return `<mo movablelimits="true">` + v + "</mo>", true, nil
+	}
+	if v, ok := texIntegrals[cmd]; ok {
+		return "<mo>" + v + "</mo>", false, nil
+	}
+	if texFunctions[cmd] {
+		return "<mi>" + cmd + "</mi><mo>⁡</mo>", false, nil
+	}
+	if texLimitFunctions[cmd] {
+		name := map[string]string{"liminf": "lim inf", "limsup": "lim sup"}[cmd]
+		if name == "" {
+			name = cmd
+		}
+		return "<mi>" + name + "</mi>", true, nil
+	}
+	if w, ok := texSpaces[cmd]; ok {
+		return `<mspace width="` + w + `"/>`, false, nil
+	}
+	if texIgnored[cmd] {
+		if strings.HasPrefix(cmd, "big") || strings.HasPrefix(cmd, "Big") || cmd == "middle" {
+			d, err := p.parseDelimiter()
+			if err != nil || d == "" {
+				return "", false, err
+			}
+			return `<mo stretchy="true">` + html.EscapeString(d) + "</mo>", false, nil
+		}
+		return "", false, nil
+	}
+	if v, ok := texAccents[cmd]; ok {
+		arg, err := p.parseArg()
+		return `<mover accent="true">` + arg + `<mo stretchy="true">` + html.EscapeString(v) + "</mo></mover>", false, err
+	}
+	if v, ok := texUnderAccents[cmd]; ok {
+		arg, err := p.parseArg()
+		return `<munder accentunder="true">` + arg + `<mo stretchy="true">` + v + "</mo></munder>", false, err
+	}
+	if v, ok := texFontVariants[cmd]; ok {
+		return p.parseVariant(v)
+	}
+	if v, ok := texTextVariants[cmd]; ok {
+		text, err := p.readRaw()
+		if err != nil {
+			return "", false, err
+		}
+		attr := ""
+		if v != "" {
+			attr = ` mathvariant="` + v + `"`
+		}
+		return "<mtext" + attr + ">" + html.EscapeString(text) + "</mtext>", false, nil
+	}
+
+	switch cmd {
+	case "frac", "dfrac", "tfrac", "cfrac", "binom", "dbinom", "tbinom":
+		num, err := p.parseArg()
+		if err != nil {
+			return "", false, err
+		}
+		den, err := p.parseArg()
+		if err != nil {
+			return "", false, err
+		}
+		if strings.HasSuffix(cmd, "binom") {
+			return `<mrow><mo>(</mo><mfrac linethickness="0">` + num + den + `</mfrac><mo>)</mo></mrow>`, false, nil
+		}
+		return "<mfrac>" + num + den + "</mfrac>", false, nil
+	case "sqrt":
+		var index string
+		if tok, ok := p.peek(); ok && tok.is(false, "[") {
+			p.next()
+			var err error
+			index, err = p.parseList(func(t texToken) bool { return t.is(false, "]") })
+			if err != nil {
+				return "", false, err
+			}
+			if tok, ok = p.next(); !ok || !tok.is(false, "]") {
+				return "", false, fmt.Errorf("missing \"]\"")
+			}
+		}
+		arg, err := p.parseArg()
+		if err != nil {
+			return "", false, err
+		}
+		if index != "" {
+			return "<mroot>" + arg + mrow(index) + "</mroot>", false, nil
+		}
+		return "<msqrt>" + arg + "</msqrt>", false, nil
+	case "operatorname":
+		name, err := p.readRaw()
+		return "<mi>" + html.EscapeString(name) + "</mi><mo>⁡</mo>", false, err
+	case "left":
+		return p.parseLeftRight()
+	case "begin":
+		return p.parseEnv()
+	case "\\", "newline":
+		return `<mspace linebreak="newline"/>`, false, nil
+	case "label", "tag":
+		_, err := p.readRaw()
+		return "", false, err
+	case "not":
+		arg, _, err := p.parseAtom()
+		return `<mrow><menclose notation="updiagonalstrike">` + arg + `</menclose></mrow>`, false, err
+	case "pmod":
+		arg, err := p.parseArg()
+		return "<mo>(</mo><mi>mod</mi><mspace width=\"0.333em\"/>" + arg + "<mo>)</mo>", false, err
+	}
+	return "", false, fmt.Errorf(`unknown command "\%s"`, cmd)
+}
+
+// parseVariant parses argument of font command with mathvariant.
+func (p *texParser) parseVariant(variant string) (string, bool, error) {
+	pos := p.pos
+	raw, err := p.readRaw()
+	if err == nil && raw != "" && strings.IndexFunc(raw, func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	}) < 0 {
+		// plain letters: single identifier per letter, as in TeX
+		var b strings.Builder
+		for _, r := range raw {
+			tag := "mi"
+			if unicode.IsDigit(r) {
+				tag = "mn"
+			}
+			b.WriteString("<" + tag + ` mathvariant="` + variant + `">` + string(r) + "</" + tag + ">")
+		}
+		return b.String(), false, nil
+	}
+	p.pos = pos
+	arg, err := p.parseArg()
+	return `<mstyle mathvariant="` + variant + `">` + arg + "</mstyle>", false, err
+}
+
+// parseDelimiter parses \left, \right or \big delimiter. Empty string is
+// returned for ".".
+func (p *texParser) parseDelimiter() (string, error) {
+	tok, ok := p.next()
+	switch {
+	case !ok:
+		return "", fmt.Errorf("missing delimiter")
+	case tok.is(false, "."):
+		return "", nil
+	case !tok.cmd:
+		return tok.text, nil
+	}
+	if v, ok := texOperators[tok.text]; ok {
+		return v, nil
+	}
+	return "", fmt.Errorf("bad delimiter %s", tok)
+}
+
+// parseLeftRight parses \left ... \right group.
+func (p *texParser) parseLeftRight() (string, bool, error) {
+	left, err := p.parseDelimiter()
+	if err != nil {
+		return "", false, err
+	}
+	body, err := p.parseList(func(t texToken) bool { return t.is(true, "right") })
+	if err != nil {
+		return "", false, err
+	}
+	if tok, ok := p.next(); !ok || !tok.is(true, "right") {
+		return "", false, fmt.Errorf(`missing "\right"`)
+	}
+	right, err := p.parseDelimiter()
+	if err != nil {
+		return "", false, err
+	}
+	return "<mrow>" + fence(left) + body + fence(right) + "</mrow>", false, nil
+}
+
+func fence(d string) string {
+	if d == "" {
+		return ""
+	}
+	return `<mo fence="true" stretchy="true">` + html.EscapeString(d) + "</mo>"
+}
+
+// parseEnv parses \begin{name} ... \end{name} environment into table.
+func (p *texParser) parseEnv() (string, bool, error) {
+	name, err := p.readRaw()
+	if err != nil {
+		return "", false, err
+	}
+	fences, ok := texMatrixFences[name]
+	if !ok {
+		if name == "equation" || name == "equation*" || name == "displaymath" {
+			body, err := p.parseList(func(t texToken) bool { return t.is(true, "end") })
+			if err != nil {
+				return "", false, err
+			}
+			return body, false, p.parseEnd(name)
+		}
+		return "", false, fmt.Errorf("unknown environment %q", name)
+	}
+	if name == "array" || name == "alignat" {
+		if _, err = p.readRaw(); err != nil { // columns spec
+			return "", false, err
+		}
+	}
+	stop := func(t texToken) bool {
+		return t.is(false, "&") || t.is(true, "\\") || t.is(true, "cr") || t.is(true, "end")
+	}
+	var rows [][]string
+	row := []string{}
+	for {
+		cell, err := p.parseList(stop)
+		if err != nil {
+			return "", false, err
+		}
+		row = append(row, cell)
+		tok, ok := p.peek()
+		switch {
+		case !ok:
+			return "", false, fmt.Errorf(`missing "\end{%s}"`, name)
+		case tok.is(false, "}"):
+			return "", false, fmt.Errorf(`unexpected "}"`)
+		case tok.is(true, "end"):
+			rows = append(rows, row)
+		case tok.is(false, "&"):
+			p.next()
+			continue
+		default: // row end
+			p.next()
+			rows = append(rows, row)
+			row = []string{}
+			continue
+		}
+		break
+	}
+	if err = p.parseEnd(name); err != nil {
+		return "", false, err
+	}
+	// trailing \\ produces empty row
+	if last := rows[len(rows)-1]; len(rows) > 1 && len(last) == 1 && last[0] == "" {
+		rows = rows[:len(rows)-1]
+	}
+
+	align := ""
+	switch name {
+	case "cases":
+		align = ` columnalign="left"`
+	case "aligned", "align", "align*", "split", "alignat":
+		align = ` columnalign="right left"`
+	}
+	var b strings.Builder
+	b.WriteString("<mrow>" + fence(fences[0]) + "<mtable" + align + ">")
+	for _, row := range rows {
+		b.WriteString("<mtr>")
+		for _, cell := range row {
+			b.WriteString("<mtd>" + cell + "</mtd>")
+		}
+		b.WriteString("</mtr>")
+	}
+	b.WriteString("</mtable>" + fence(fences[1]) + "</mrow>")
+	return b.String(), false, nil
+}
+
+// parseEnd parses \end{name}.
+func (p *texParser) parseEnd(name string) error {
+	tok, ok := p.next()
+	if !ok || !tok.is(true, "end") {
+		return fmt.Errorf(`missing "\end{%s}"`, name)
+	}
+	end, err := p.readRaw()
+	if err != nil {
+		return err
+	}
+	if end != name {
+		return fmt.Errorf(`"\begin{%s}" ended by "\end{%s}"`, name, end)
+	}
+	return nil
+}
@@ -0,0 +1,72 @@
+package clip
+
+import (
+	"strings"
+	"testing"
+)
+
+func Test_texToMathML(t *testing.T) {
+	tests := []struct {
+		tex     string
+		display bool
+		want    string // math content without annotation
+		wantErr bool
+	}{
+		{tex: `x^2+y_1`, want: `<msup><mi>x</mi><mn>2</mn></msup><mo>+</mo><msub><mi>y</mi><mn>1</mn></msub>`},
+		{tex: `\frac12`, want: `<mfrac><mn>1</mn><mn>2</mn></mfrac>`},
+		{tex: `\frac{a+b}{2}`, want: `<mfrac><mrow><mi>a</mi><mo>+</mo><mi>b</mi></mrow><mn>2</mn></mfrac>`},
+		{tex: `3.14 - \alpha`, want: `<mn>3.14</mn><mo>−</mo><mi>α</mi>`},
+		{tex: `\sqrt[3]{x}`, want: `<mroot><mi>x</mi><mn>3</mn></mroot>`},
+		{tex: `f'(x)`, want: `<msup><mi>f</mi><mo>′</mo></msup><mo>(</mo><mi>x</mi><mo>)</mo>`},
+		{
+			tex:     `\sum_{i=1}^n i`,
+			display: true,
+			want: `<munderover><mo movablelimits="true">∑</mo><mrow><mi>i</mi><mo>=</mo><mn>1</mn></mrow>` +
+				`<mi>n</mi></munderover><mi>i</mi>`,
+		},
+		{tex: `\sum_{i} i`, want: `<msub><mo movablelimits="true">∑</mo><mi>i</mi></msub><mi>i</mi>`},
+		{tex: `\sin x`, want: `<mi>sin</mi><mo>⁡</mo><mi>x</mi>`},
+		{
+			tex:  `\left( \frac{1}{x} \right.`,
+			want: `<mrow><mo fence="true" stretchy="true">(</mo><mfrac><mn>1</mn><mi>x</mi></mfrac></mrow>`,
+		},
+		{tex: `\mathbb{R}^n`, want: `<msup><mi mathvariant="double-struck">R</mi><mi>n</mi></msup>`},
+		{tex: `\text{if } x`, want: `<mtext>if </mtext><mi>x</mi>`},
+		{tex: `\hat{x}`, want: `<mover accent="true"><mi>x</mi><mo stretchy="true">^</mo></mover>`},
+		{
+			tex: `\begin{pmatrix} a & b \\ c & d \\ \end{pmatrix}`,
+			want: `<mrow><mo fence="true" stretchy="true">(</mo><mtable><mtr><mtd><mi>a</mi></mtd><mtd><mi>b</mi></mtd></mtr>` +
+				`<mtr><mtd><mi>c</mi></mtd><mtd><mi>d</mi></mtd></mtr></mtable><mo fence="true" stretchy="true">)</mo></mrow>`,
+		},
+		{tex: `a < b`, want: `<mi>a</mi><mo>&lt;</mo><mi>b</mi>`},
+		{tex: `\frac{1}{2`, wantErr: true},
+		{tex: `x}`, wantErr: true},
+		{tex: `x^1^2`, wantErr: true},
+		{tex: `\unknowncmd`, wantErr: true},
+		{tex: `\begin{matrix} a \end{pmatrix}`, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.tex, func(t *testing.T) {
+			got, err := texToMathML(tt.tex, tt.display)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("texToMathML() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			mode := "inline"
+			if tt.display {
+				mode = "block"
+			}
+			prefix := `<math xmlns="http://www.w3.org/1998/Math/MathML" display="` + mode + `"><semantics><mrow>`
+			if !strings.HasPrefix(got, prefix) {
+				t.Fatalf("texToMathML() = %s, want %s prefix", got, prefix)
+			}
+			got = strings.TrimPrefix(got, prefix)
+			got = got[:strings.Index(got, "</mrow><annotation")]
+			if got != tt.want {
+				t.Errorf("texToMathML() =\n%s\nwant\n%s", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,128 @@
+package clip
+
+import (
+	"context"
+	"fmt"
+	"html"
+	neturl "net/url"
+	"regexp"
+	"sort"
+
+	"github.com/PuerkitoBio/goquery"
+)
+
+// Transformer changes document DOM. Transformers are enabled by name with
+// Params.Transforms and run in listed order after query, remove and other
+// Params changes are applied.
+type Transformer func(ctx context.Context, doc *goquery.Document, p *Params) error
+
+// builtinTransformers are available to every Clipper. Transformers are
+// created for clipper, so they can use its HTTP client and limits.
+var builtinTransformers = map[string]func(c *Clipper) Transformer{
+	"spoilers": func(*Clipper) Transformer { return expandSpoilers },
+	"tweets":   func(*Clipper) Transformer { return convertTweets },
+	"media":    func(c *Clipper) Transformer { return c.convertMedia },
+	"code":     func(*Clipper) Transformer { return highlightCode },
+	"math":     func(*Clipper) Transformer { return renderMath },
+}
+
+// transformer returns transformer by name: registered with
+// ClipperOptions.Transformers or built-in.
+func (c *Clipper) transformer(name string) (Transformer, bool) {
+	if t, ok := c.opts.Transformers[name]; ok {
+		return t, true
+	}
+	if t, ok := builtinTransformers[name]; ok {
+		return t(c), true
+	}
+	return nil, false
+}
+
+// TransformerNames returns sorted names of transformers available to c.
+func (c *Clipper) TransformerNames() []string {
+	var res []string
+	for name := range builtinTransformers {
+		if _, ok := c.opts.Transformers[name]; !ok {
+			res = append(res, name)
+		}
+	}
+	for name := range c.opts.Transformers {
+		res = append(res, name)
+	}
+	sort.Strings(res)
+	return res
+}
+
+// checkTransforms returns ValidationError if p enables unknown transformer.
+func (c *Clipper) checkTransforms(p *Params) error {
+	if p.Transforms == nil {
+		return nil
+	}
+	for _, name := range *p.Transforms {
+		if _, ok := c.transformer(name); !ok {
+			return &ValidationError{"unknown transform: " + name}
+		}
+	}
+	return nil
+}
+
+// transform runs transformers enabled by p.
+func (c *Clipper) transform(ctx context.Context, doc *goquery.Document, p *Params) error {
+	if p.Transforms == nil {
+		return nil
+	}
+	for _, name := range *p.Transforms {
+		t, ok := c.transformer(name)
+		if !ok {
+			return &ValidationError{"unknown transform: " + name}
+		}
+		err := t(ctx, doc, p)
+		if err != nil {
+			return fmt.Errorf("transform %s: %w", name, err)
+		}
+	}
+	return nil
+}
+
+var displayNoneRe = regexp.MustCompile(`(?i)display\s*:\s*none\s*(!\s*important)?\s*;?`)
+
+// expandSpoilers opens collapsed <details> and spoiler blocks, so their
+// content is printed.
+func expandSpoilers(_ context.Context, doc *goquery.Document, _ *Params) error {
+	doc.Find("details").SetAttr("open", "")
+	doc.Find(`[class*="spoiler"],[aria-expanded="false"] + *`).Each(func(_ int, s *goquery.Selection) {
+		s.RemoveAttr("hidden")
+		if style, ok := s.Attr("style"); ok {
+			s.SetAttr("style", displayNoneRe.ReplaceAllString(style, ""))
+		}
+	})
+	doc.Find(`[aria-expanded="false"]`).SetAttr("aria-expanded", "true")
+	return nil
+}
+
+// convertTweets replaces embedded tweet iframes with blockquotes linking
+// to tweets, and removes twitter widgets script, which would replace
+// tweet blockquotes with iframes.
+func convertTweets(_ context.Context, doc *goquery.Document, _ *Params) error {
+	doc.Find(`script[src*="platform.twitter.com"]`).Remove()
+	doc.Find(`iframe[src*="platform.twitter.com/embed"],iframe[data-tweet-id]`).Each(func(_ int, s *goquery.Selection) {
+		id := s.AttrOr("data-tweet-id", "")
+		if id == "" {
+			if u, err := neturl.Parse(s.AttrOr("src", "")); err == nil {
+				id = u.Query().Get("id")
+			}
+		}
+		if id == "" {
+			return
+		}
+		href := "https://twitter.com/i/status/" + neturl.PathEscape(id)
+		s.ReplaceWithHtml(`<blockquote class="twitter-tweet"><a href="` + html.EscapeString(href) + `">` +
+			html.EscapeString(href) + `</a></blockquote>`)
+	})
+	if doc.Find("blockquote.twitter-tweet").Length() > 0 {
+		doc.Find("head").AppendHtml(`<style type="text/css">blockquote.twitter-tweet{` +
+			`margin:1em 0;padding:.5em 1em;border-left:4px solid #1da1f2;` +
+			`page-break-inside:avoid;break-inside:avoid-page}</style>`)
+	}
+	return nil
+}
@@ -0,0 +1,97 @@
+package clip
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/PuerkitoBio/goquery"
+)
+
+func Test_expandSpoilers(t *testing.T) {
+	doc, err := goquery.NewDocumentFromReader(strings.NewReader(`<html><body>
+		<details><summary>more</summary><p>hidden</p></details>
+		<div class="spoiler_text" style="color:red;display: none !important;">s1</div>
+		<div class="post-spoiler" hidden>s2</div>
+		<button aria-expanded="false">toggle</button><div style="display:none">s3</div>
+	</body></html>`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = expandSpoilers(context.Background(), doc, &Params{})
+	if _, ok := doc.Find("details").Attr("open"); !ok {
+		t.Error("details isn't opened")
+	}
+	if style := doc.Find(".spoiler_text").AttrOr("style", ""); style != "color:red;" {
+		t.Errorf("spoiler style = %q", style)
+	}
+	if _, ok := doc.Find(".post-spoiler").Attr("hidden"); ok {
+		t.Error("hidden attribute isn't removed")
+	}
+	if style := doc.Find("button + div").AttrOr("style", ""); style != "" {
+		t.Errorf("collapsed block style = %q", style)
+	}
+	if v := doc.Find("button").AttrOr("aria-expanded", ""); v != "true" {
+		t.Errorf("aria-expanded = %q", v)
+	}
+}
+
+func Test_convertTweets(t *testing.T) {
+	doc, err := goquery.NewDocumentFromReader(strings.NewReader(`<html><head></head><body>
+		<iframe src="https://platform.twitter.com/embed/Tweet.html?id=123&theme=dark"></iframe>
+		<iframe data-tweet-id="456" src="about:blank"></iframe>
+		<blockquote class="twitter-tweet"><a href="https://twitter.com/x/status/789">t</a></blockquote>
+		<script async src="https://platform.twitter.com/widgets.js"></script>
+	</body></html>`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = convertTweets(context.Background(), doc, &Params{})
+	if n := doc.Find("iframe,script").Length(); n != 0 {
+		t.Errorf("%d iframes and scripts left", n)
+	}
+	var hrefs []string
+	doc.Find("blockquote.twitter-tweet a").Each(func(_ int, s *goquery.Selection) {
+		hrefs = append(hrefs, s.AttrOr("href", ""))
+	})
+	want := "https://twitter.com/i/status/123 https://twitter.com/i/status/456 https://twitter.com/x/status/789"
+	if got := strings.Join(hrefs, " "); got != want {
+		t.Errorf("tweet links = %s, want %s", got, want)
+	}
+}
+
+func TestClipper_transform(t *testing.T) {
+	errCustom := errors.New("custom")
+	c := NewClipper(ClipperOptions{Transformers: map[string]Transformer{
+		"mark": func(_ context.Context, doc *goquery.Document, p *Params) error {
+			doc.Find("body").SetAttr("data-title", *p.Title)
+			return nil
+		},
+		"fail": func(context.Context, *goquery.Document, *Params) error { return errCustom },
+	}})
+	if got, want := strings.Join(c.TransformerNames(), ","), "code,fail,mark,math,media,spoilers,tweets"; got != want {
+		t.Errorf("TransformerNames() = %s, want %s", got, want)
+	}
+
+	doc, _ := goquery.NewDocumentFromReader(strings.NewReader(`<html><body><details></details></body></html>`))
+	title, list := "t", []string{"spoilers", "mark"}
+	p := &Params{Title: &title, Transforms: &list}
+	err := c.transform(context.Background(), doc, p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if doc.Find("body").AttrOr("data-title", "") != "t" || doc.Find("details[open]").Length() != 1 {
+		t.Error("transformers aren't applied")
+	}
+
+	list = []string{"fail"}
+	if err = c.transform(context.Background(), doc, p); !errors.Is(err, errCustom) {
+		t.Errorf("transform() error = %v, want custom error", err)
+	}
+	list = []string{"nope"}
+	var verr *ValidationError
+	if err = c.checkTransforms(p); !errors.As(err, &verr) {
+		t.Errorf("checkTransforms() error = %v, want ValidationError", err)
+	}
+}